@@ -1,36 +1,209 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"server/db"
+	"server/internal/config"
+	"server/internal/mail"
+	"server/internal/message"
+	"server/internal/room"
 	"server/internal/routes"
+	"server/internal/token"
 	"server/internal/user"
 	"server/internal/websocket"
-
-	"github.com/ianschenck/envflag"
+	"syscall"
 )
 
-const minSecretKeySize = 32
-
 func main() {
-	var secretKey = envflag.String("SECRET_KEY", "0123456789012345678901234567890123456789", "secret key for jwt signing")
-	if len(*secretKey) < minSecretKeySize{
-		log.Fatalf("SECRET_KEY must be at least %d characters", minSecretKeySize)
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("unknown command %q", args[0])
+		}
+		if err := runMigrate(cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	dbConn, err := db.NewDatabase()
+	userConfig := cfg.Auth.User()
+	wsConfig, err := cfg.Websocket.Hub()
+	if err != nil {
+		log.Fatalf("Invalid websocket configuration: %v", err)
+	}
+	mailer, err := newMailer(cfg.Mail.Driver, cfg.Mail.Dir, cfg.Mail.SMTP())
+	if err != nil {
+		log.Fatalf("Invalid mail configuration: %v", err)
+	}
+	signingKeys, err := loadSigningKeys(cfg.Auth.JWTAlgorithm, cfg.Auth.JWTPrivateKeyFile, cfg.Auth.SecretKey)
 	if err != nil {
-		log.Fatalf("Could not connect to database: %v", err)
+		log.Fatalf("Invalid jwt configuration: %v", err)
+	}
+
+	// The config refuses chat without the postgres user store, so every
+	// other store runs without postgres
+	var dbConn *db.Database
+	if cfg.Database.UserStore == "postgres" {
+		dbConn, err = db.NewDatabase(cfg.Database.DB())
+		if err != nil {
+			log.Fatalf("Could not connect to database: %v", err)
+		}
+		defer dbConn.Close()
+		if cfg.Database.AutoMigrate {
+			migrator, err := db.NewMigrator(dbConn.GetDB())
+			if err != nil {
+				log.Fatalf("Could not load migrations: %v", err)
+			}
+			applied, err := migrator.Up(context.Background())
+			if err != nil {
+				log.Fatalf("Could not migrate database: %v", err)
+			}
+			log.Printf("applied %d migrations", len(applied))
+		}
+	} else {
+		log.Printf("%s user store, serving accounts only", cfg.Database.UserStore)
 	}
-	userRepo := user.NewRepository(dbConn.GetDB())
-	//jwtMaker := token.NewJwtMaker("your-very-secret-key")
-	userService := user.NewService(userRepo, *secretKey)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	keys := token.NewKeySet(signingKeys[0], userConfig.AccessTokenTTL)
+	keys.Use(signingKeys)
+	if cfg.Auth.JWTRotationInterval > 0 {
+		go keys.RunRotation(ctx, cfg.Auth.JWTRotationInterval, func() ([]*token.Key, error) {
+			return token.LoadKeyFile(cfg.Auth.JWTPrivateKeyFile, cfg.Auth.JWTAlgorithm)
+		})
+	}
+	jwtMaker := token.NewJwtMakerWithKeys(keys)
+	tokenHandler := token.NewHandler(keys)
+
+	userRepo, closeUserRepo, err := newUserRepository(cfg.Database.UserStore, cfg.Database.SQLitePath, dbConn)
+	if err != nil {
+		log.Fatalf("Could not open user store: %v", err)
+	}
+	defer closeUserRepo()
+	userService := user.NewService(userRepo, jwtMaker, mailer, userConfig)
 	userHandler := user.NewHandler(userService)
 
-	hub := websocket.NewHub()
-	websocketHandler := websocket.NewHandler(hub)
-	r := routes.InitRouter(userHandler, websocketHandler)
+	var (
+		hub              *websocket.Hub
+		roomHandler      *room.Handler
+		messageHandler   *message.Handler
+		websocketHandler *websocket.Handler
+	)
+	if cfg.Server.Chat {
+		messageRepo := message.NewRepository(dbConn.GetDB())
+		roomRepo := room.NewRepository(dbConn.GetDB())
+
+		messageService := message.NewService(messageRepo, roomRepo)
+		messageHandler = message.NewHandler(messageService)
 
-	http.ListenAndServe(":8080", r)
+		hub = websocket.NewHub(wsConfig, messageRepo, roomRepo)
+		go hub.Run()
+
+		roomService := room.NewService(roomRepo, hub)
+		roomHandler = room.NewHandler(roomService)
+		origins := append([]string{cfg.Auth.AppURL}, cfg.Websocket.AllowedOrigins...)
+		websocketHandler = websocket.NewHandler(hub, roomService, origins)
+	}
+
+	r := routes.InitRouter(jwtMaker, cfg.Auth.AdminAPIKey, userConfig.Verification != user.VerificationOff, tokenHandler, userHandler, roomHandler, messageHandler, websocketHandler)
+
+	srv := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      r,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", cfg.Server.Addr)
+		if cfg.Server.TLSCertFile != "" {
+			serveErr <- srv.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("Server stopped: %v", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process right away
+	stop()
+	log.Printf("shutting down, waiting up to %s", cfg.Server.ShutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	// Upgraded connections are hijacked, srv.Shutdown does not wait for them
+	if hub != nil {
+		if err := hub.Shutdown(shutdownCtx); err != nil {
+			log.Printf("error closing websocket connections: %v", err)
+		}
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error shutting down http server: %v", err)
+	}
+	log.Println("server stopped")
+}
+
+// loadSigningKeys returns the jwt keys, the first one signs.
+func loadSigningKeys(algorithm, keyFile, secretKey string) ([]*token.Key, error) {
+	if algorithm == token.AlgorithmHS256 {
+		return []*token.Key{token.NewHMACKey([]byte(secretKey))}, nil
+	}
+	if keyFile == "" {
+		log.Printf("no JWT_PRIVATE_KEY_FILE set, generating a %s key that only lives as long as this process", algorithm)
+		key, err := token.GenerateKey(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return []*token.Key{key}, nil
+	}
+	return token.LoadKeyFile(keyFile, algorithm)
+}
+
+// newUserRepository returns the user store and a func closing it.
+func newUserRepository(store, sqlitePath string, pg *db.Database) (user.Repository, func(), error) {
+	switch store {
+	case "postgres":
+		return user.NewRepository(pg.GetDB()), func() {}, nil
+	case "sqlite":
+		sqliteConn, err := db.NewSQLiteDatabase(sqlitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := user.CreateSQLiteSchema(context.Background(), sqliteConn.GetDB()); err != nil {
+			sqliteConn.Close()
+			return nil, nil, fmt.Errorf("error creating sqlite schema: %w", err)
+		}
+		return user.NewSQLiteRepository(sqliteConn.GetDB()), sqliteConn.Close, nil
+	case "memory":
+		return user.NewMemoryRepository(), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown user store %q", store)
+	}
+}
+
+func newMailer(driver, dir string, smtpConfig mail.SMTPConfig) (mail.Mailer, error) {
+	switch driver {
+	case "smtp":
+		return mail.NewSMTPMailer(smtpConfig), nil
+	case "file":
+		return mail.NewFileMailer(dir, smtpConfig.From)
+	case "memory":
+		return mail.NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"server/db"
+	"server/internal/config"
+	"strconv"
+)
+
+const migrateUsage = `usage: server [flags] migrate <command>
+
+commands:
+  up              apply every pending migration
+  down [n|all]    roll back the last n migrations, 1 by default
+  status          list applied and pending migrations
+  create [-dir DIR] NAME
+                  add an empty migration to DIR, db/migrations of the
+                  module the command is run in by default`
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	if args[0] == "create" {
+		return createMigration(args[1:])
+	}
+
+	dbConn, err := db.NewDatabase(cfg.Database.DB())
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+	migrator, err := db.NewMigrator(dbConn.GetDB())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if args[1] == "all" {
+				steps = int(^uint(0) >> 1)
+			} else if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, m := range reverted {
+			fmt.Printf("rolled back %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range status.Applied {
+			fmt.Printf("applied  %d_%s\n", m.Version, m.Name)
+		}
+		for _, m := range status.Pending {
+			fmt.Printf("pending  %d_%s\n", m.Version, m.Name)
+		}
+		if status.Dirty {
+			fmt.Printf("database is dirty at version %d\n", status.Version)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+func createMigration(args []string) error {
+	flags := flag.NewFlagSet("migrate create", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory of the migration files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(migrateUsage)
+	}
+	if *dir == "" {
+		var err error
+		if *dir, err = migrationsDir(); err != nil {
+			return err
+		}
+	}
+	paths, err := db.CreateMigration(*dir, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		fmt.Println("created", path)
+	}
+	return nil
+}
+
+// migrationsDir finds db/migrations from anywhere in the module, the
+// binary embeds the migrations from there.
+func migrationsDir() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return filepath.Join(dir, "db", "migrations"), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("not in the server module, pass -dir to say where the migrations are")
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,48 @@
+// Package dbtest connects tests to the postgres database named by
+// TEST_DATABASE_URL. Tests using it are skipped when it is not set.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"server/db"
+)
+
+// Open returns the test database, migrated to the latest version. It is
+// closed when the test ends.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	conn, err := db.NewDatabase(db.Config{DSN: dsn})
+	if err != nil {
+		t.Fatalf("could not open postgres: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	migrator, err := db.NewMigrator(conn.GetDB())
+	if err != nil {
+		t.Fatalf("could not load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+	return conn.GetDB()
+}
+
+// Tx begins a transaction that is rolled back when the test ends, so tests
+// sharing the database don't see each other's rows.
+func Tx(t *testing.T, pg *sql.DB) *sql.Tx {
+	t.Helper()
+	tx, err := pg.Begin()
+	if err != nil {
+		t.Fatalf("could not begin transaction: %v", err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
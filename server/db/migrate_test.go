@@ -0,0 +1,310 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/2_add_b.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+		"m/2_add_b.down.sql": {Data: []byte("DROP TABLE b;")},
+		"m/10_add_c.up.sql":  {Data: []byte("CREATE TABLE c ();")},
+		"m/1_add_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+		"m/1_add_a.down.sql": {Data: []byte("DROP TABLE a;")},
+	}
+	migrations, err := loadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("loadMigrations: %v", err)
+	}
+	want := []Migration{
+		{Version: 1, Name: "add_a", Up: "CREATE TABLE a ();", Down: "DROP TABLE a;"},
+		{Version: 2, Name: "add_b", Up: "CREATE TABLE b ();", Down: "DROP TABLE b;"},
+		{Version: 10, Name: "add_c", Up: "CREATE TABLE c ();"},
+	}
+	if fmt.Sprint(migrations) != fmt.Sprint(want) {
+		t.Errorf("loadMigrations = %+v, want %+v", migrations, want)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"unexpected file": {"m/README.md": {}},
+		"two names":       {"m/1_add_a.up.sql": {}, "m/1_add_b.down.sql": {}},
+	} {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: loadMigrations succeeded, want an error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := NewMigrator(nil)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	for _, migration := range m.migrations {
+		if strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			t.Errorf("migration %d_%s needs both an up and a down script", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	paths, err := CreateMigration(dir, "add_things")
+	if err != nil {
+		t.Fatalf("CreateMigration: %v", err)
+	}
+	if len(paths) != 2 || !strings.HasSuffix(paths[0], "_add_things.up.sql") || !strings.HasSuffix(paths[1], "_add_things.down.sql") {
+		t.Fatalf("CreateMigration = %v, want an up and a down file", paths)
+	}
+	for _, path := range paths {
+		if filepath.Dir(path) != dir {
+			t.Errorf("created %s outside of %s", path, dir)
+		}
+	}
+	if _, err := loadMigrations(os.DirFS(filepath.Dir(dir)), filepath.Base(dir)); err != nil {
+		t.Errorf("the created files don't load: %v", err)
+	}
+
+	for _, name := range []string{"", "add things", "add-things", "../add_things"} {
+		if _, err := CreateMigration(dir, name); err == nil {
+			t.Errorf("CreateMigration(%q) succeeded, want an error", name)
+		}
+	}
+	if _, err := CreateMigration(filepath.Join(dir, "missing"), "add_things"); err == nil {
+		t.Error("CreateMigration into a missing directory succeeded")
+	}
+}
+
+// testDatabase returns a connection to the TEST_DATABASE_URL database that
+// works in a schema of its own, dropped when the test ends, so migrations
+// start from nothing.
+func testDatabase(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("could not create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`) })
+
+	// lib/pq passes unknown settings on to the server
+	switch {
+	case strings.Contains(dsn, "://") && strings.Contains(dsn, "?"):
+		dsn += "&search_path=" + schema
+	case strings.Contains(dsn, "://"):
+		dsn += "?search_path=" + schema
+	default:
+		dsn += " search_path=" + schema
+	}
+	pg, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pg.Close() })
+	return pg
+}
+
+func exists(t *testing.T, pg *sql.DB, table string) bool {
+	t.Helper()
+	var ok bool
+	if err := pg.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, table).Scan(&ok); err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func versions(migrations []Migration) []int64 {
+	var v []int64
+	for _, m := range migrations {
+		v = append(v, m.Version)
+	}
+	return v
+}
+
+var testMigrations = []Migration{
+	{Version: 1, Name: "add_a", Up: `CREATE TABLE a (id int)`, Down: `DROP TABLE a`},
+	{Version: 2, Name: "add_b", Up: `CREATE TABLE b (id int)`, Down: `DROP TABLE b`},
+	{Version: 3, Name: "fill_a", Up: `INSERT INTO a VALUES (1)`, Down: `DELETE FROM a`},
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	pg := testDatabase(t)
+	m := &Migrator{db: pg, migrations: testMigrations}
+	ctx := context.Background()
+
+	status, err := m.Status(ctx)
+	if err != nil || status.Version != 0 || len(status.Applied) != 0 || len(status.Pending) != 3 {
+		t.Fatalf("Status of an empty database = %+v, %v, want everything pending", status, err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil || fmt.Sprint(versions(applied)) != "[1 2 3]" {
+		t.Fatalf("Up = %v, %v, want 1 to 3 applied", versions(applied), err)
+	}
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("second Up = %v, %v, want nothing to do", versions(applied), err)
+	}
+	status, err = m.Status(ctx)
+	if err != nil || status.Version != 3 || status.Dirty || len(status.Applied) != 3 || len(status.Pending) != 0 {
+		t.Errorf("Status after Up = %+v, %v, want version 3", status, err)
+	}
+
+	reverted, err := m.Down(ctx, 1)
+	if err != nil || fmt.Sprint(versions(reverted)) != "[3]" {
+		t.Fatalf("Down(1) = %v, %v, want 3 rolled back", versions(reverted), err)
+	}
+	if status, _ := m.Status(ctx); status.Version != 2 || len(status.Pending) != 1 {
+		t.Errorf("Status after Down(1) = %+v, want version 2", status)
+	}
+	reverted, err = m.Down(ctx, 10)
+	if err != nil || fmt.Sprint(versions(reverted)) != "[2 1]" {
+		t.Fatalf("Down(10) = %v, %v, want 2 then 1 rolled back", versions(reverted), err)
+	}
+	if exists(t, pg, "a") || exists(t, pg, "b") {
+		t.Error("tables are left after rolling everything back")
+	}
+	if status, _ := m.Status(ctx); status.Version != 0 {
+		t.Errorf("Status after rolling everything back = %+v, want version 0", status)
+	}
+}
+
+func TestMigratorFailedMigration(t *testing.T) {
+	pg := testDatabase(t)
+	ctx := context.Background()
+	migrations := append(testMigrations[:1:1],
+		Migration{Version: 2, Name: "broken", Up: `CREATE TABLE b (id int); SELECT 1/0`, Down: `DROP TABLE b`},
+		Migration{Version: 3, Name: "never", Up: `CREATE TABLE c (id int)`, Down: `DROP TABLE c`},
+	)
+	m := &Migrator{db: pg, migrations: migrations}
+
+	applied, err := m.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "2_broken") {
+		t.Fatalf("Up = %v, want migration 2_broken to fail", err)
+	}
+	if fmt.Sprint(versions(applied)) != "[1]" {
+		t.Errorf("Up applied %v, want only 1", versions(applied))
+	}
+	// The failed migration ran in a transaction of its own
+	if !exists(t, pg, "a") || exists(t, pg, "b") || exists(t, pg, "c") {
+		t.Error("want only the table of the first migration")
+	}
+	if status, err := m.Status(ctx); err != nil || status.Version != 1 || status.Dirty {
+		t.Errorf("Status = %+v, %v, want a clean version 1", status, err)
+	}
+
+	// Once fixed it applies
+	migrations[1].Up = `CREATE TABLE b (id int)`
+	if applied, err := m.Up(ctx); err != nil || fmt.Sprint(versions(applied)) != "[2 3]" {
+		t.Errorf("Up after the fix = %v, %v, want 2 and 3 applied", versions(applied), err)
+	}
+}
+
+func TestMigratorRefusesUnknownState(t *testing.T) {
+	pg := testDatabase(t)
+	ctx := context.Background()
+	m := &Migrator{db: pg, migrations: testMigrations}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	if _, err := pg.Exec(`UPDATE schema_migrations SET dirty = true`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("Up of a dirty database = %v, want an error", err)
+	}
+	if _, err := m.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("Down of a dirty database = %v, want an error", err)
+	}
+
+	if _, err := pg.Exec(`UPDATE schema_migrations SET version = 99, dirty = false`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Status(ctx); err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("Status of a newer database = %v, want an error", err)
+	}
+}
+
+func TestMigratorWaitsForLock(t *testing.T) {
+	pg := testDatabase(t)
+	ctx := context.Background()
+	m := &Migrator{db: pg, migrations: testMigrations}
+
+	// Another replica migrating holds the lock
+	other, err := pg.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Up(ctx)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Up finished while the lock was held: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if exists(t, pg, "a") {
+		t.Error("a migration ran while the lock was held")
+	}
+
+	if _, err := other.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Up: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Up did not finish once the lock was released")
+	}
+	if !exists(t, pg, "a") {
+		t.Error("migrations did not run once the lock was released")
+	}
+}
+
+// TestEmbeddedMigrationsRoundTrip checks every down script undoes its up
+// script, so they can be applied again.
+func TestEmbeddedMigrationsRoundTrip(t *testing.T) {
+	pg := testDatabase(t)
+	ctx := context.Background()
+	m, err := NewMigrator(pg)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := m.Up(ctx); err != nil {
+			t.Fatalf("Up: %v", err)
+		}
+		if reverted, err := m.Down(ctx, len(m.migrations)); err != nil || len(reverted) != len(m.migrations) {
+			t.Fatalf("Down = %d migrations, %v, want all %d", len(reverted), err, len(m.migrations))
+		}
+	}
+	if exists(t, pg, "users") {
+		t.Error("users table is left after rolling everything back")
+	}
+}
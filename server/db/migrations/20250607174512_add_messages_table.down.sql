@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "messages";
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE "messages" (
+    "id" bigserial PRIMARY KEY,
+    "room_id" varchar NOT NULL,
+    "username" varchar NOT NULL,
+    "content" text NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX "messages_room_id_id_idx" ON "messages" ("room_id", "id");
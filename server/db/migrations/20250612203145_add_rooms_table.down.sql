@@ -0,0 +1,2 @@
+ALTER TABLE "messages" DROP CONSTRAINT IF EXISTS "messages_room_id_fkey";
+DROP TABLE IF EXISTS "rooms";
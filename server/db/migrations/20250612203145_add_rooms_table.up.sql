@@ -0,0 +1,15 @@
+CREATE TABLE "rooms" (
+    "id" varchar(255) PRIMARY KEY NOT NULL,
+    "owner_id" bigint REFERENCES "users" ("id") ON DELETE SET NULL,
+    "name" varchar NOT NULL,
+    "topic" varchar NOT NULL DEFAULT '',
+    "visibility" varchar(16) NOT NULL DEFAULT 'public',
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Rooms used to only live in memory, keep the history of the ones we know of
+INSERT INTO "rooms" ("id", "name")
+SELECT DISTINCT "room_id", "room_id" FROM "messages";
+
+ALTER TABLE "messages" ADD CONSTRAINT "messages_room_id_fkey"
+    FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "sessions_refresh_token_idx";
+ALTER TABLE "sessions" DROP COLUMN IF EXISTS "family_id";
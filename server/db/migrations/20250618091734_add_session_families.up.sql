@@ -0,0 +1,6 @@
+ALTER TABLE "sessions" ADD COLUMN "family_id" varchar(255);
+UPDATE "sessions" SET "family_id" = "id";
+ALTER TABLE "sessions" ALTER COLUMN "family_id" SET NOT NULL;
+
+CREATE INDEX "sessions_family_id_idx" ON "sessions" ("family_id");
+CREATE INDEX "sessions_refresh_token_idx" ON "sessions" ("refresh_token");
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "sessions_email_idx";
+ALTER TABLE "sessions" DROP COLUMN IF EXISTS "ip_address";
+ALTER TABLE "sessions" DROP COLUMN IF EXISTS "user_agent";
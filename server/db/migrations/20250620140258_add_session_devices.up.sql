@@ -0,0 +1,4 @@
+ALTER TABLE "sessions" ADD COLUMN "user_agent" varchar(512) NOT NULL DEFAULT '';
+ALTER TABLE "sessions" ADD COLUMN "ip_address" varchar(64) NOT NULL DEFAULT '';
+
+CREATE INDEX "sessions_email_idx" ON "sessions" ("email");
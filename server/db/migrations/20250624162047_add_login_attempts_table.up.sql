@@ -0,0 +1,7 @@
+CREATE TABLE "login_attempts" (
+    "scope" varchar(16) NOT NULL,
+    "key" varchar(255) NOT NULL,
+    "failures" integer NOT NULL DEFAULT 0,
+    "last_failure_at" TIMESTAMP NOT NULL,
+    PRIMARY KEY ("scope", "key")
+);
@@ -0,0 +1,14 @@
+ALTER TABLE "users" ADD COLUMN "verified_at" TIMESTAMP;
+-- Accounts created before verification existed are trusted as they are
+UPDATE "users" SET "verified_at" = CURRENT_TIMESTAMP;
+
+CREATE TABLE "email_tokens" (
+    "id" varchar(255) PRIMARY KEY NOT NULL,
+    "email" varchar(255) NOT NULL,
+    "purpose" varchar(32) NOT NULL,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "expires_at" TIMESTAMP NOT NULL,
+    "used_at" TIMESTAMP
+);
+
+CREATE INDEX "email_tokens_email_idx" ON "email_tokens" ("email");
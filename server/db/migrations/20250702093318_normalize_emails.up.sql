@@ -0,0 +1,8 @@
+-- Emails are compared case-insensitively and stored lowercased. This fails
+-- if two accounts only differ by the case of their email, those have to be
+-- merged by hand first.
+UPDATE "users" SET "email" = lower("email") WHERE "email" <> lower("email");
+UPDATE "sessions" SET "email" = lower("email") WHERE "email" <> lower("email");
+UPDATE "email_tokens" SET "email" = lower("email") WHERE "email" <> lower("email");
+
+CREATE UNIQUE INDEX "users_email_lower_key" ON "users" (lower("email"));
@@ -0,0 +1,3 @@
+DELETE FROM "rooms" WHERE "kind" = 'direct';
+DROP TABLE IF EXISTS "direct_rooms";
+ALTER TABLE "rooms" DROP COLUMN IF EXISTS "kind";
@@ -0,0 +1,12 @@
+ALTER TABLE "rooms" ADD COLUMN "kind" varchar(16) NOT NULL DEFAULT 'room';
+
+-- The two participants of a direct conversation, user_a is the lower ID
+CREATE TABLE "direct_rooms" (
+    "room_id" varchar(255) PRIMARY KEY REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "user_a" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "user_b" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    CHECK ("user_a" < "user_b"),
+    UNIQUE ("user_a", "user_b")
+);
+
+CREATE INDEX "direct_rooms_user_b_idx" ON "direct_rooms" ("user_b");
@@ -0,0 +1,26 @@
+CREATE TABLE "room_members" (
+    "room_id" varchar(255) NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "role" varchar(16) NOT NULL DEFAULT 'member',
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("room_id", "user_id")
+);
+
+CREATE INDEX "room_members_user_id_idx" ON "room_members" ("user_id");
+
+-- Owners used to be the only ones allowed in their private rooms
+INSERT INTO "room_members" ("room_id", "user_id", "role", "created_at")
+SELECT "id", "owner_id", 'owner', "created_at" FROM "rooms"
+WHERE "owner_id" IS NOT NULL AND "kind" = 'room';
+
+-- Pending invitations, accepting or declining one deletes it
+CREATE TABLE "room_invites" (
+    "room_id" varchar(255) NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "invited_by" bigint REFERENCES "users" ("id") ON DELETE SET NULL,
+    "role" varchar(16) NOT NULL DEFAULT 'member',
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("room_id", "user_id")
+);
+
+CREATE INDEX "room_invites_user_id_idx" ON "room_invites" ("user_id");
@@ -0,0 +1,13 @@
+-- Shareable links into a room, max_uses and expires_at are unlimited when NULL
+CREATE TABLE "room_invite_links" (
+    "code" varchar(64) PRIMARY KEY NOT NULL,
+    "room_id" varchar(255) NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "created_by" bigint REFERENCES "users" ("id") ON DELETE SET NULL,
+    "role" varchar(16) NOT NULL DEFAULT 'member',
+    "max_uses" integer,
+    "uses" integer NOT NULL DEFAULT 0,
+    "expires_at" TIMESTAMP,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX "room_invite_links_room_id_idx" ON "room_invite_links" ("room_id");
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "room_audit_log";
+DROP TABLE IF EXISTS "room_mutes";
+DROP TABLE IF EXISTS "room_bans";
+ALTER TABLE "rooms" DROP COLUMN IF EXISTS "slow_mode_seconds";
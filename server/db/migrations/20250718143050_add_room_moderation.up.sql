@@ -0,0 +1,32 @@
+ALTER TABLE "rooms" ADD COLUMN "slow_mode_seconds" integer NOT NULL DEFAULT 0;
+
+CREATE TABLE "room_bans" (
+    "room_id" varchar(255) NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "banned_by" bigint REFERENCES "users" ("id") ON DELETE SET NULL,
+    "reason" varchar NOT NULL DEFAULT '',
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY ("room_id", "user_id")
+);
+
+CREATE TABLE "room_mutes" (
+    "room_id" varchar(255) NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "user_id" bigint NOT NULL REFERENCES "users" ("id") ON DELETE CASCADE,
+    "muted_by" bigint REFERENCES "users" ("id") ON DELETE SET NULL,
+    "muted_until" TIMESTAMP NOT NULL,
+    PRIMARY KEY ("room_id", "user_id")
+);
+
+-- Every moderation action, kept when the users involved are deleted
+CREATE TABLE "room_audit_log" (
+    "id" bigserial PRIMARY KEY,
+    "room_id" varchar(255) NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "action" varchar(32) NOT NULL,
+    "actor_id" bigint REFERENCES "users" ("id") ON DELETE SET NULL,
+    "target_id" bigint REFERENCES "users" ("id") ON DELETE SET NULL,
+    "reason" varchar NOT NULL DEFAULT '',
+    "duration_seconds" bigint NOT NULL DEFAULT 0,
+    "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX "room_audit_log_room_id_id_idx" ON "room_audit_log" ("room_id", "id");
@@ -0,0 +1,13 @@
+ALTER TABLE "messages" ADD COLUMN "seq" bigint;
+ALTER TABLE "messages" ADD COLUMN "type" varchar(32) NOT NULL DEFAULT 'message';
+
+-- Number the existing history of every room in the order it was written
+UPDATE "messages" SET "seq" = numbered."seq"
+FROM (
+    SELECT "id", row_number() OVER (PARTITION BY "room_id" ORDER BY "id") AS "seq"
+    FROM "messages"
+) AS numbered
+WHERE "messages"."id" = numbered."id";
+
+ALTER TABLE "messages" ALTER COLUMN "seq" SET NOT NULL;
+CREATE UNIQUE INDEX "messages_room_id_seq_idx" ON "messages" ("room_id", "seq");
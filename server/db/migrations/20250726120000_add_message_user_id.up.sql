@@ -0,0 +1,2 @@
+-- The sender of each event, NULL for history written before it was kept
+ALTER TABLE "messages" ADD COLUMN "user_id" bigint REFERENCES "users" ("id") ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+-- The session a rotated one was replaced by, empty for sessions revoked by
+-- logging out
+ALTER TABLE "sessions" ADD COLUMN "replaced_by" varchar(255) NOT NULL DEFAULT '';
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"database/sql"
+	"net/url"
+
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLiteDatabase opens the SQLite database at path, ":memory:" keeps it
+// in memory. SQLite allows a single writer, so the pool is one connection.
+func NewSQLiteDatabase(path string) (*Database, error) {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "foreign_keys(1)")
+	// Times are compared as text, this format sorts like the times do
+	params.Set("_time_format", "sqlite")
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return &Database{db: db}, nil
+}
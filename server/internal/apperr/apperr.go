@@ -0,0 +1,91 @@
+// Package apperr defines the error kinds shared by the repository, service
+// and handler layers. Handlers hand errors to utils.WriteError, which turns
+// the kind into an HTTP status and the code into the response body.
+package apperr
+
+import "time"
+
+type Kind int
+
+const (
+	// Internal errors are never shown to clients as they are.
+	Internal Kind = iota
+	NotFound
+	Conflict
+	Unauthorized
+	Forbidden
+	Validation
+	RateLimited
+	// Unavailable errors are temporary failures clients may retry.
+	Unavailable
+)
+
+func (k Kind) String() string {
+	switch k {
+	case NotFound:
+		return "not found"
+	case Conflict:
+		return "conflict"
+	case Unauthorized:
+		return "unauthorized"
+	case Forbidden:
+		return "forbidden"
+	case Validation:
+		return "validation"
+	case RateLimited:
+		return "rate limited"
+	case Unavailable:
+		return "unavailable"
+	default:
+		return "internal"
+	}
+}
+
+// Error is a domain error. Code is a stable machine-readable identifier
+// and Message is safe to show to clients, the wrapped cause is only logged.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	// RetryAfter tells rate limited clients when to come back.
+	RetryAfter time.Duration
+	Err        error
+}
+
+// New returns a sentinel error. Packages declare them once and wrap them
+// with Wrap or WithRetryAfter when they have more details.
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target has the same code, so a wrapped copy still
+// matches its sentinel with errors.Is.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e with err as its cause.
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+// WithRetryAfter returns a copy of e telling the client when to retry.
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	c := *e
+	c.RetryAfter = d
+	return &c
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"server/internal/utils"
+)
+
+// AdminKey guards operator endpoints with a static key sent in the
+// X-Admin-Key header. An empty key disables those endpoints.
+func AdminKey(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key == "" {
+				utils.WriteError(w, r, http.StatusNotFound, "not found", errors.New("admin api disabled"))
+				return
+			}
+			given := r.Header.Get("X-Admin-Key")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+				utils.WriteError(w, r, http.StatusUnauthorized, "invalid admin key", errors.New("invalid admin key"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package auth
+
+import "context"
+
+// Identity is the authenticated user behind a request.
+type Identity struct {
+	UserID   int64
+	Email    string
+	Username string
+	Verified bool
+}
+
+type contextKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// IdentityFromContext returns the user put there by Middleware.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"server/internal/apperr"
+	"server/internal/token"
+	"server/internal/utils"
+	"strings"
+)
+
+var (
+	errMissingToken = errors.New("missing access token")
+	// errNotVerified shares its code with user.ErrEmailNotVerified
+	errNotVerified = apperr.New(apperr.Forbidden, "email_not_verified", "email not verified")
+)
+
+// Middleware rejects requests without a valid access token and stores the
+// verified user in the request context. The token is read from the
+// Authorization header first, then from the access_token cookie.
+func Middleware(jwtMaker *token.JWTMaker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenStr, err := accessToken(r)
+			if err != nil {
+				utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", err)
+				return
+			}
+			claims, err := jwtMaker.VerifyToken(tokenStr)
+			if err != nil {
+				utils.WriteError(w, r, http.StatusUnauthorized, "invalid access token", err)
+				return
+			}
+
+			ctx := WithIdentity(r.Context(), Identity{
+				UserID:   int64(claims.ID),
+				Email:    claims.Email,
+				Username: claims.Username,
+				Verified: claims.Verified,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func accessToken(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, tokenStr, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || tokenStr == "" {
+			return "", errors.New("malformed authorization header")
+		}
+		return tokenStr, nil
+	}
+	cookie, err := r.Cookie("access_token")
+	if err != nil {
+		return "", errMissingToken
+	}
+	return cookie.Value, nil
+}
+
+// RequireVerified rejects users who have not verified their email yet. It
+// must run after Middleware.
+func RequireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok || !identity.Verified {
+			utils.WriteError(w, r, http.StatusForbidden, "email not verified", errNotVerified)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
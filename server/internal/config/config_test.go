@@ -0,0 +1,265 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, args, err := Load("server", nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if want := Default(); !reflect.DeepEqual(*c, want) || len(args) != 0 {
+		t.Errorf("Load = %+v with %v, want the defaults", c, args)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+server:
+  addr: ":9000"
+  idle_timeout: 2m
+database:
+  dsn: "postgres://file"
+  max_open_conns: 10
+  max_idle_conns: 5
+auth:
+  access_token_ttl: 5m
+`)
+	t.Setenv("DATABASE_URL", "postgres://env")
+	t.Setenv("DB_MAX_OPEN_CONNS", "20")
+	t.Setenv("HTTP_IDLE_TIMEOUT", "3m")
+
+	c, args, err := Load("server", []string{"-config", path, "-db-max-open-conns", "30", "migrate", "up"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"default", c.Server.ReadTimeout, Default().Server.ReadTimeout},
+		{"file over default", c.Server.Addr, ":9000"},
+		{"file over default", c.Database.MaxIdleConns, 5},
+		{"file over default", c.Auth.AccessTokenTTL, 5 * time.Minute},
+		{"environment over file", c.Database.DSN, "postgres://env"},
+		{"environment over file", c.Server.IdleTimeout, 3 * time.Minute},
+		{"flag over environment", c.Database.MaxOpenConns, 30},
+		{"arguments", args, []string{"migrate", "up"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnvironment(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeFile(t, "server:\n  addr: \":9000\"\n"))
+	c, _, err := Load("server", nil)
+	if err != nil || c.Server.Addr != ":9000" {
+		t.Errorf("Load = %+v, %v, want the address from CONFIG_FILE", c, err)
+	}
+
+	// -config wins over CONFIG_FILE
+	c, _, err = Load("server", []string{"-config", writeFile(t, "server:\n  addr: \":9001\"\n")})
+	if err != nil || c.Server.Addr != ":9001" {
+		t.Errorf("Load = %+v, %v, want the address from -config", c, err)
+	}
+}
+
+func TestLoadAllowedOrigins(t *testing.T) {
+	path := writeFile(t, "websocket:\n  allowed_origins: [\"https://file.example.com\"]\n")
+	c, _, err := Load("server", []string{"-config", path})
+	if err != nil || !reflect.DeepEqual(c.Websocket.AllowedOrigins, []string{"https://file.example.com"}) {
+		t.Errorf("Load = %v, %v, want the origin from the file", c.Websocket.AllowedOrigins, err)
+	}
+
+	t.Setenv("WS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com,")
+	c, _, err = Load("server", []string{"-config", path})
+	if err != nil || !reflect.DeepEqual(c.Websocket.AllowedOrigins, []string{"https://a.example.com", "https://b.example.com"}) {
+		t.Errorf("Load = %v, %v, want the origins from the environment", c.Websocket.AllowedOrigins, err)
+	}
+
+	c, _, err = Load("server", []string{"-ws-allowed-origins", "https://c.example.com"})
+	if err != nil || !reflect.DeepEqual(c.Websocket.AllowedOrigins, []string{"https://c.example.com"}) {
+		t.Errorf("Load = %v, %v, want the origin from the flag", c.Websocket.AllowedOrigins, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{name: "misspelled key", file: "server:\n  adr: \":9000\"\n", wantErr: "field adr not found"},
+		{name: "bad YAML value", file: "server:\n  read_timeout: soon\n", wantErr: "error reading config file"},
+		{name: "missing file", args: []string{"-config", "missing.yaml"}, wantErr: "error opening config file"},
+		{name: "bad environment value", env: map[string]string{"DB_MAX_OPEN_CONNS": "many"}, wantErr: "invalid DB_MAX_OPEN_CONNS"},
+		{name: "bad flag value", args: []string{"-chat=maybe"}, wantErr: "invalid boolean value"},
+		{name: "unknown flag", args: []string{"-colour"}, wantErr: "flag provided but not defined"},
+		{name: "invalid setting", env: map[string]string{"SECRET_KEY": "short"}, wantErr: "auth.secret_key must be at least 32 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, _, err := Load("server", args)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string
+	}{
+		{name: "defaults", change: func(c *Config) {}},
+		{
+			name:    "chat with the sqlite user store",
+			change:  func(c *Config) { c.Database.UserStore = "sqlite" },
+			wantErr: `database.user_store "sqlite" only holds accounts`,
+		},
+		{
+			name:    "chat with the memory user store",
+			change:  func(c *Config) { c.Database.UserStore = "memory" },
+			wantErr: `database.user_store "memory" only holds accounts`,
+		},
+		{
+			name:   "accounts only with the sqlite user store",
+			change: func(c *Config) { c.Database.UserStore, c.Server.Chat = "sqlite", false },
+		},
+		{
+			name:    "sqlite without a path",
+			change:  func(c *Config) { c.Database.UserStore, c.Database.SQLitePath, c.Server.Chat = "sqlite", "", false },
+			wantErr: "database.sqlite_path is required",
+		},
+		{
+			name:    "unknown user store",
+			change:  func(c *Config) { c.Database.UserStore = "redis" },
+			wantErr: `unknown database.user_store "redis"`,
+		},
+		{
+			name:    "rotating an HS256 secret",
+			change:  func(c *Config) { c.Auth.JWTRotationInterval = time.Hour },
+			wantErr: "auth.jwt_rotation_interval needs an EdDSA or ES256 auth.jwt_private_key_file",
+		},
+		{
+			name: "rotating without a key file",
+			change: func(c *Config) {
+				c.Auth.JWTAlgorithm, c.Auth.JWTRotationInterval = "EdDSA", time.Hour
+			},
+			wantErr: "auth.jwt_rotation_interval needs an EdDSA or ES256 auth.jwt_private_key_file",
+		},
+		{
+			name: "rotating a key file",
+			change: func(c *Config) {
+				c.Auth.JWTAlgorithm, c.Auth.JWTPrivateKeyFile, c.Auth.JWTRotationInterval = "ES256", "keys.pem", time.Hour
+			},
+		},
+		{
+			name:    "negative rotation interval",
+			change:  func(c *Config) { c.Auth.JWTRotationInterval = -time.Hour },
+			wantErr: "auth.jwt_rotation_interval can't be negative",
+		},
+		{
+			name:    "unknown algorithm",
+			change:  func(c *Config) { c.Auth.JWTAlgorithm = "RS256" },
+			wantErr: `unknown auth.jwt_algorithm "RS256"`,
+		},
+		{
+			name:    "more idle than open connections",
+			change:  func(c *Config) { c.Database.MaxOpenConns, c.Database.MaxIdleConns = 5, 10 },
+			wantErr: "database.max_idle_conns (10) can't exceed database.max_open_conns (5)",
+		},
+		{
+			name:    "certificate without a key",
+			change:  func(c *Config) { c.Server.TLSCertFile = "cert.pem" },
+			wantErr: "must be set together",
+		},
+		{
+			name:    "refresh tokens shorter than access tokens",
+			change:  func(c *Config) { c.Auth.RefreshTokenTTL = c.Auth.AccessTokenTTL },
+			wantErr: "auth.refresh_token_ttl must be longer than auth.access_token_ttl",
+		},
+		{
+			name: "allowed origins",
+			change: func(c *Config) {
+				c.Websocket.AllowedOrigins = []string{"https://chat.example.com", "http://localhost:3000/"}
+			},
+		},
+		{
+			name:    "origin without a scheme",
+			change:  func(c *Config) { c.Websocket.AllowedOrigins = []string{"chat.example.com"} },
+			wantErr: `websocket.allowed_origins: "chat.example.com" is not an origin`,
+		},
+		{
+			name:    "malformed origin",
+			change:  func(c *Config) { c.Websocket.AllowedOrigins = []string{"http:localhost:3000"} },
+			wantErr: `websocket.allowed_origins: "http:localhost:3000" is not an origin`,
+		},
+		{
+			name:    "origin with a path",
+			change:  func(c *Config) { c.Websocket.AllowedOrigins = []string{"https://example.com/chat"} },
+			wantErr: `websocket.allowed_origins: "https://example.com/chat" is not an origin`,
+		},
+		{
+			name:    "unknown overflow policy",
+			change:  func(c *Config) { c.Websocket.OverflowPolicy = "block" },
+			wantErr: "websocket:",
+		},
+	}
+	for _, tt := range tests {
+		c := Default()
+		tt.change(&c)
+		err := c.Validate()
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: Validate = %v, want no error", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Validate = %v, want an error containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateReportsEverySetting(t *testing.T) {
+	c := Default()
+	c.Server.Addr = ""
+	c.Database.DSN = ""
+	c.Mail.Driver = "pigeon"
+	err := c.Validate()
+	for _, want := range []string{"server.addr is required", "database.dsn is required", `unknown mail.driver "pigeon"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to report %q", err, want)
+		}
+	}
+}
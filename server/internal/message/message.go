@@ -0,0 +1,62 @@
+package message
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+// Message is a stored event of a room's history. Seq numbers the events of
+// a room without gaps in the order they were stored, Type is the
+// websocket event they were sent as, such as "message" or "join". UserID is
+// the sender, nil once the user is deleted.
+type Message struct {
+	ID        int64     `json:"id" db:"id"`
+	RoomID    string    `json:"room_id" db:"room_id"`
+	Seq       int64     `json:"seq" db:"seq"`
+	Type      string    `json:"type" db:"type"`
+	UserID    *int64    `json:"user_id" db:"user_id"`
+	Username  string    `json:"username" db:"username"`
+	Content   string    `json:"content" db:"content"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Page selects a window of a room's history by message ID. Before and After
+// are exclusive bounds and are ignored when zero.
+type Page struct {
+	Before int64
+	After  int64
+	Limit  int
+}
+
+type GetMessagesRequest struct {
+	RoomID string
+	UserID int64
+	Page
+}
+
+type GetMessagesResponse struct {
+	Messages []*Message `json:"messages"`
+	HasMore  bool       `json:"has_more"`
+}
+
+type Repository interface {
+	// CreateMessage stores the message as the room's next event, its Seq is
+	// set to the one after the room's last.
+	CreateMessage(ctx context.Context, message *Message) (*Message, error)
+	ListMessages(ctx context.Context, roomID string, page Page) ([]*Message, error)
+	// ListMessagesSince returns up to limit messages with a sequence number
+	// above since, oldest first.
+	ListMessagesSince(ctx context.Context, roomID string, since int64, limit int) ([]*Message, error)
+	// LastSeq returns the sequence number of the newest message, 0 for an
+	// empty room.
+	LastSeq(ctx context.Context, roomID string) (int64, error)
+}
+
+type Service interface {
+	GetMessages(c context.Context, req *GetMessagesRequest) (*GetMessagesResponse, error)
+}
@@ -0,0 +1,81 @@
+package message
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"server/internal/auth"
+	"server/internal/utils"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	Service
+}
+
+func NewHandler(s Service) *Handler {
+	return &Handler{
+		Service: s,
+	}
+}
+
+// GetMessages serves a room's history. Clients page backwards with ?before=
+// set to the oldest ID they have, or forwards with ?after= set to the newest.
+func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	roomID := chi.URLParam(r, "roomId")
+	if roomID == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, "room ID required", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	var page Page
+	var err error
+	if page.Before, err = parseCursor(query.Get("before")); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid before cursor", err)
+		return
+	}
+	if page.After, err = parseCursor(query.Get("after")); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid after cursor", err)
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if page.Limit, err = strconv.Atoi(limit); err != nil || page.Limit <= 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, "invalid limit", err)
+			return
+		}
+	}
+
+	res, err := h.Service.GetMessages(ctx, &GetMessagesRequest{RoomID: roomID, UserID: identity.UserID, Page: page})
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not load messages", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+func parseCursor(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, fmt.Errorf("cursor must be positive")
+	}
+	return id, nil
+}
@@ -0,0 +1,79 @@
+package message
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"server/internal/auth"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func getMessages(t *testing.T, h *Handler, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity := auth.Identity{UserID: 2, Username: "bob", Verified: true}
+			next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+		})
+	})
+	r.Get("/rooms/{roomId}/messages", h.GetMessages)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rooms/lobby/messages"+query, nil))
+	return w
+}
+
+func TestGetMessagesHandler(t *testing.T) {
+	repo := newMemRepository(30)
+	h := NewHandler(NewService(repo, stubRooms{}))
+
+	w := getMessages(t, h, "?before=21&after=5&limit=10")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", w.Code, w.Body)
+	}
+	if want := (Page{Before: 21, After: 5, Limit: 11}); repo.page != want {
+		t.Errorf("asked the repository for %+v, want %+v", repo.page, want)
+	}
+	var res GetMessagesResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(res.Messages); !slices.Equal(got, span(6, 15)) || !res.HasMore {
+		t.Errorf("got %v with has_more %v, want 6 to 15 with more", got, res.HasMore)
+	}
+
+	// The next page starts after the newest message of this one
+	w = getMessages(t, h, "?before=21&after=15&limit=10")
+	res = GetMessagesResponse{}
+	json.NewDecoder(w.Body).Decode(&res)
+	if got := ids(res.Messages); !slices.Equal(got, span(16, 20)) || res.HasMore {
+		t.Errorf("got %v with has_more %v, want 16 to 20 and no more", got, res.HasMore)
+	}
+}
+
+func TestGetMessagesHandlerRejectsCursors(t *testing.T) {
+	h := NewHandler(NewService(newMemRepository(0), stubRooms{}))
+	for _, query := range []string{
+		"?before=0",
+		"?before=-3",
+		"?before=abc",
+		"?after=0",
+		"?after=1.5",
+		"?limit=0",
+		"?limit=-1",
+		"?limit=ten",
+		"?limit=99999999999999999999",
+	} {
+		if w := getMessages(t, h, query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s answered %d, want 400", query, w.Code)
+		}
+	}
+	// Limits above the maximum are clamped, not refused
+	if w := getMessages(t, h, "?limit=1000"); w.Code != http.StatusOK {
+		t.Errorf("?limit=1000 answered %d, want 200", w.Code)
+	}
+}
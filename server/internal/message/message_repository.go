@@ -0,0 +1,134 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+type repository struct {
+	db DBTX
+}
+
+func NewRepository(db DBTX) Repository {
+	return &repository{db: db}
+}
+
+const messageColumns = `id, room_id, seq, type, user_id, username, content, created_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row scanner) (*Message, error) {
+	var m Message
+	if err := row.Scan(&m.ID, &m.RoomID, &m.Seq, &m.Type, &m.UserID, &m.Username, &m.Content, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *repository) CreateMessage(ctx context.Context, message *Message) (*Message, error) {
+	// Numbering in the insert means a failed one leaves no gap
+	query := `INSERT INTO messages (room_id, seq, type, user_id, username, content, created_at)
+			  SELECT $1, COALESCE(MAX(seq), 0) + 1, $2, $3, $4, $5, $6 FROM messages WHERE room_id = $1
+			  RETURNING id, seq`
+	err := r.db.QueryRowContext(ctx,
+		query,
+		message.RoomID,
+		message.Type,
+		message.UserID,
+		message.Username,
+		message.Content,
+		message.CreatedAt,
+	).Scan(&message.ID, &message.Seq)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting message: %w", err)
+	}
+	return message, nil
+}
+
+// ListMessages returns the page in chronological order. Without an After
+// bound the newest messages before the cursor are returned.
+func (r *repository) ListMessages(ctx context.Context, roomID string, page Page) ([]*Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE room_id = $1`
+	args := []interface{}{roomID}
+	if page.Before > 0 {
+		args = append(args, page.Before)
+		query += fmt.Sprintf(" AND id < $%d", len(args))
+	}
+	if page.After > 0 {
+		args = append(args, page.After)
+		query += fmt.Sprintf(" AND id > $%d", len(args))
+	}
+	if page.After > 0 {
+		query += " ORDER BY id ASC"
+	} else {
+		query += " ORDER BY id DESC"
+	}
+	args = append(args, page.Limit)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve messages: %w", err)
+	}
+
+	if page.After == 0 {
+		slices.Reverse(messages)
+	}
+	return messages, nil
+}
+
+func (r *repository) ListMessagesSince(ctx context.Context, roomID string, since int64, limit int) ([]*Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages
+			  WHERE room_id = $1 AND seq > $2
+			  ORDER BY seq ASC
+			  LIMIT $3`
+	rows, err := r.db.QueryContext(ctx, query, roomID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve messages: %w", err)
+	}
+	return messages, nil
+}
+
+func (r *repository) LastSeq(ctx context.Context, roomID string) (int64, error) {
+	var seq int64
+	query := `SELECT COALESCE(MAX(seq), 0) FROM messages WHERE room_id = $1`
+	if err := r.db.QueryRowContext(ctx, query, roomID).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("error failed to retrieve last sequence number: %w", err)
+	}
+	return seq, nil
+}
+
+func scanMessages(rows *sql.Rows) ([]*Message, error) {
+	messages := []*Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,146 @@
+package message_test
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+
+	"server/db/dbtest"
+	"server/internal/message"
+)
+
+// newRepository returns a repository on the TEST_DATABASE_URL database,
+// in a transaction holding the given rooms.
+func newRepository(t *testing.T, rooms ...string) (message.Repository, *sql.Tx) {
+	t.Helper()
+	tx := dbtest.Tx(t, dbtest.Open(t))
+	for _, id := range rooms {
+		if _, err := tx.Exec(`INSERT INTO rooms (id, name) VALUES ($1, $1)`, id); err != nil {
+			t.Fatalf("could not create room %s: %v", id, err)
+		}
+	}
+	return message.NewRepository(tx), tx
+}
+
+func create(t *testing.T, r message.Repository, roomID, content string) *message.Message {
+	t.Helper()
+	m, err := r.CreateMessage(context.Background(), &message.Message{
+		RoomID:    roomID,
+		Type:      "message",
+		Username:  "alice",
+		Content:   content,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	return m
+}
+
+func TestCreateMessageSeq(t *testing.T) {
+	r, tx := newRepository(t, "lobby", "other")
+	ctx := context.Background()
+
+	for i, want := range []int64{1, 2, 3} {
+		if m := create(t, r, "lobby", "hi"); m.Seq != want {
+			t.Errorf("message %d got seq %d, want %d", i+1, m.Seq, want)
+		}
+	}
+	// Every room is numbered on its own
+	if m := create(t, r, "other", "hi"); m.Seq != 1 {
+		t.Errorf("first message of another room got seq %d, want 1", m.Seq)
+	}
+
+	// A failed insert uses up no number
+	if _, err := tx.Exec(`SAVEPOINT failed_insert`); err != nil {
+		t.Fatal(err)
+	}
+	unknown := int64(-1)
+	_, err := r.CreateMessage(ctx, &message.Message{RoomID: "lobby", Type: "message", UserID: &unknown, CreatedAt: time.Now()})
+	if err == nil {
+		t.Fatal("CreateMessage with an unknown sender succeeded")
+	}
+	if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT failed_insert`); err != nil {
+		t.Fatal(err)
+	}
+	if m := create(t, r, "lobby", "hi"); m.Seq != 4 {
+		t.Errorf("message after a failed insert got seq %d, want 4", m.Seq)
+	}
+
+	last, err := r.LastSeq(ctx, "lobby")
+	if err != nil || last != 4 {
+		t.Errorf("LastSeq = %d, %v, want 4", last, err)
+	}
+	if last, err := r.LastSeq(ctx, "empty"); err != nil || last != 0 {
+		t.Errorf("LastSeq of an empty room = %d, %v, want 0", last, err)
+	}
+}
+
+func TestListMessagesSince(t *testing.T) {
+	r, _ := newRepository(t, "lobby")
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		create(t, r, "lobby", "hi")
+	}
+
+	got, err := r.ListMessagesSince(ctx, "lobby", 1, 3)
+	if err != nil {
+		t.Fatalf("ListMessagesSince: %v", err)
+	}
+	if seqs := seqsOf(got); !slices.Equal(seqs, []int64{2, 3, 4}) {
+		t.Errorf("ListMessagesSince(1, 3) = %v, want [2 3 4]", seqs)
+	}
+	got, err = r.ListMessagesSince(ctx, "lobby", 5, 3)
+	if err != nil || len(got) != 0 {
+		t.Errorf("ListMessagesSince after the last = %v, %v, want nothing", seqsOf(got), err)
+	}
+}
+
+func seqsOf(messages []*message.Message) []int64 {
+	seqs := []int64{}
+	for _, m := range messages {
+		seqs = append(seqs, m.Seq)
+	}
+	return seqs
+}
+
+func TestListMessagesPages(t *testing.T) {
+	r, _ := newRepository(t, "lobby", "other")
+	ctx := context.Background()
+	var ids []int64
+	for i := 0; i < 10; i++ {
+		ids = append(ids, create(t, r, "lobby", "hi").ID)
+		create(t, r, "other", "hi")
+	}
+
+	tests := []struct {
+		name string
+		page message.Page
+		want []int64
+	}{
+		{"newest", message.Page{Limit: 3}, ids[7:]},
+		{"before", message.Page{Before: ids[7], Limit: 3}, ids[4:7]},
+		{"before, less than a page left", message.Page{Before: ids[2], Limit: 3}, ids[:2]},
+		{"after", message.Page{After: ids[2], Limit: 3}, ids[3:6]},
+		{"after, less than a page left", message.Page{After: ids[8], Limit: 3}, ids[9:]},
+		{"between", message.Page{After: ids[2], Before: ids[5], Limit: 10}, ids[3:5]},
+		{"between, more than a page", message.Page{After: ids[2], Before: ids[9], Limit: 2}, ids[3:5]},
+	}
+	for _, tt := range tests {
+		got, err := r.ListMessages(ctx, "lobby", tt.page)
+		if err != nil {
+			t.Errorf("%s: ListMessages: %v", tt.name, err)
+			continue
+		}
+		// Pages are always oldest first
+		var gotIDs []int64
+		for _, m := range got {
+			gotIDs = append(gotIDs, m.ID)
+		}
+		if !slices.Equal(gotIDs, tt.want) {
+			t.Errorf("%s: ListMessages = %v, want %v", tt.name, gotIDs, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"server/internal/room"
+	"time"
+)
+
+type service struct {
+	Repository
+	rooms   room.Repository
+	timeout time.Duration
+}
+
+func NewService(repository Repository, rooms room.Repository) Service {
+	return &service{
+		Repository: repository,
+		rooms:      rooms,
+		timeout:    time.Duration(2) * time.Second,
+	}
+}
+
+func (s *service) GetMessages(c context.Context, req *GetMessagesRequest) (*GetMessagesResponse, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r, err := s.rooms.GetRoomByID(ctx, req.RoomID)
+	if errors.Is(err, room.ErrRoomNotFound) && room.IsParticipant(req.RoomID, req.UserID) {
+		// The direct conversation is stored with its first message
+		return &GetMessagesResponse{Messages: []*Message{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Private history is hidden from everyone who can't join the room
+	ok, err := room.CanRead(ctx, s.rooms, r, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, room.ErrRoomNotFound
+	}
+
+	page := req.Page
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageSize
+	}
+	if page.Limit > MaxPageSize {
+		page.Limit = MaxPageSize
+	}
+	limit := page.Limit
+
+	// Fetch one extra message to know whether there is more to load
+	page.Limit++
+	messages, err := s.Repository.ListMessages(ctx, req.RoomID, page)
+	if err != nil {
+		return nil, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		if page.After > 0 {
+			messages = messages[:limit]
+		} else {
+			messages = messages[1:]
+		}
+	}
+
+	return &GetMessagesResponse{
+		Messages: messages,
+		HasMore:  hasMore,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"server/internal/room"
+)
+
+// memRepository holds the history of the lobby, IDs and sequence numbers
+// count from 1. It remembers the last page it was asked for.
+type memRepository struct {
+	Repository
+	messages []*Message
+	page     Page
+}
+
+func newMemRepository(n int) *memRepository {
+	r := &memRepository{}
+	for i := 1; i <= n; i++ {
+		r.messages = append(r.messages, &Message{ID: int64(i), RoomID: "lobby", Seq: int64(i), Type: "message"})
+	}
+	return r
+}
+
+// ListMessages selects like the postgres repository: the first messages
+// after After, or else the last ones before Before.
+func (r *memRepository) ListMessages(ctx context.Context, roomID string, page Page) ([]*Message, error) {
+	r.page = page
+	window := []*Message{}
+	for _, m := range r.messages {
+		if m.RoomID == roomID && (page.Before == 0 || m.ID < page.Before) && (page.After == 0 || m.ID > page.After) {
+			window = append(window, m)
+		}
+	}
+	if len(window) <= page.Limit {
+		return window, nil
+	}
+	if page.After > 0 {
+		return window[:page.Limit], nil
+	}
+	return window[len(window)-page.Limit:], nil
+}
+
+// stubRooms has the public lobby and the private den with user 1 as its
+// only member.
+type stubRooms struct {
+	room.Repository
+}
+
+func (stubRooms) GetRoomByID(ctx context.Context, id string) (*room.Room, error) {
+	switch id {
+	case "lobby":
+		return &room.Room{ID: id, Visibility: room.VisibilityPublic, Kind: room.KindRoom}, nil
+	case "den":
+		return &room.Room{ID: id, Visibility: room.VisibilityPrivate, Kind: room.KindRoom}, nil
+	}
+	return nil, room.ErrRoomNotFound
+}
+
+func (stubRooms) GetMember(ctx context.Context, roomID string, userID int64) (*room.Member, error) {
+	if roomID == "den" && userID == 1 {
+		return &room.Member{RoomID: roomID, UserID: userID, Role: room.RoleMember}, nil
+	}
+	return nil, room.ErrNotMember
+}
+
+func ids(messages []*Message) []int64 {
+	ids := []int64{}
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// span returns the IDs from first to last.
+func span(first, last int64) []int64 {
+	ids := []int64{}
+	for id := first; id <= last; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestGetMessagesPages(t *testing.T) {
+	repo := newMemRepository(120)
+	s := NewService(repo, stubRooms{})
+	tests := []struct {
+		name    string
+		page    Page
+		want    []int64
+		hasMore bool
+	}{
+		{name: "newest", page: Page{}, want: span(71, 120), hasMore: true},
+		{name: "before", page: Page{Before: 71}, want: span(21, 70), hasMore: true},
+		{name: "last page", page: Page{Before: 21}, want: span(1, 20)},
+		{name: "exactly one page left", page: Page{Before: 51}, want: span(1, 50)},
+		{name: "one more than a page left", page: Page{Before: 52}, want: span(2, 51), hasMore: true},
+		{name: "before the first", page: Page{Before: 1}, want: []int64{}},
+		{name: "after", page: Page{After: 60, Limit: 10}, want: span(61, 70), hasMore: true},
+		{name: "after up to the newest", page: Page{After: 110, Limit: 10}, want: span(111, 120)},
+		{name: "after the newest", page: Page{After: 120}, want: []int64{}},
+		{name: "between", page: Page{After: 10, Before: 20}, want: span(11, 19)},
+		{name: "between, more than a page", page: Page{After: 10, Before: 20, Limit: 5}, want: span(11, 15), hasMore: true},
+		{name: "small limit", page: Page{Limit: 1}, want: []int64{120}, hasMore: true},
+		{name: "limit clamped", page: Page{Limit: 1000}, want: span(21, 120), hasMore: true},
+		{name: "negative limit", page: Page{Limit: -1}, want: span(71, 120), hasMore: true},
+	}
+	for _, tt := range tests {
+		res, err := s.GetMessages(context.Background(), &GetMessagesRequest{RoomID: "lobby", UserID: 2, Page: tt.page})
+		if err != nil {
+			t.Errorf("%s: GetMessages: %v", tt.name, err)
+			continue
+		}
+		if got := ids(res.Messages); !slices.Equal(got, tt.want) || res.HasMore != tt.hasMore {
+			t.Errorf("%s: got %v with has_more %v, want %v with %v", tt.name, got, res.HasMore, tt.want, tt.hasMore)
+		}
+	}
+}
+
+func TestGetMessagesLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		asked int
+	}{
+		{0, DefaultPageSize + 1},
+		{-5, DefaultPageSize + 1},
+		{1, 2},
+		{MaxPageSize, MaxPageSize + 1},
+		{MaxPageSize + 1, MaxPageSize + 1},
+	}
+	for _, tt := range tests {
+		repo := newMemRepository(0)
+		s := NewService(repo, stubRooms{})
+		if _, err := s.GetMessages(context.Background(), &GetMessagesRequest{RoomID: "lobby", Page: Page{Limit: tt.limit}}); err != nil {
+			t.Fatalf("GetMessages: %v", err)
+		}
+		// One extra message tells whether there are more
+		if repo.page.Limit != tt.asked {
+			t.Errorf("limit %d asked the repository for %d messages, want %d", tt.limit, repo.page.Limit, tt.asked)
+		}
+	}
+}
+
+func TestGetMessagesAccess(t *testing.T) {
+	s := NewService(newMemRepository(3), stubRooms{})
+	tests := []struct {
+		name    string
+		roomID  string
+		userID  int64
+		wantErr error
+	}{
+		{name: "public room", roomID: "lobby", userID: 2},
+		{name: "private room member", roomID: "den", userID: 1},
+		{name: "private room outsider", roomID: "den", userID: 2, wantErr: room.ErrRoomNotFound},
+		{name: "unknown room", roomID: "attic", userID: 1, wantErr: room.ErrRoomNotFound},
+		// Direct rooms are stored with their first message
+		{name: "new conversation", roomID: "dm:1:2", userID: 2},
+		{name: "someone else's conversation", roomID: "dm:1:2", userID: 3, wantErr: room.ErrRoomNotFound},
+	}
+	for _, tt := range tests {
+		res, err := s.GetMessages(context.Background(), &GetMessagesRequest{RoomID: tt.roomID, UserID: tt.userID})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: GetMessages = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && res.Messages == nil {
+			t.Errorf("%s: GetMessages returned nil messages, want a list", tt.name)
+		}
+	}
+}
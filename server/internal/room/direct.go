@@ -0,0 +1,74 @@
+package room
+
+import (
+	"fmt"
+	"server/internal/apperr"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KindRoom   = "room"
+	KindDirect = "direct"
+)
+
+// directPrefix can't start a room ID chosen by a user, those are slugs.
+const directPrefix = "dm:"
+
+var ErrUserNotFound = apperr.New(apperr.NotFound, "user_not_found", "user not found")
+
+// Participant is a user taking part in a direct conversation.
+type Participant struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type LastMessage struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Conversation is a direct conversation as seen by one of its participants.
+type Conversation struct {
+	ID             string       `json:"id"`
+	With           Participant  `json:"with"`
+	LastMessage    *LastMessage `json:"last_message"`
+	LastActivityAt time.Time    `json:"last_activity_at"`
+}
+
+// DirectRoomID returns the room of the conversation between two users,
+// the same whichever of them asks.
+func DirectRoomID(a, b int64) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%s%d:%d", directPrefix, a, b)
+}
+
+// DirectParticipants returns the users of a direct room ID, lowest first.
+// It reports false for any other room.
+func DirectParticipants(id string) (a, b int64, ok bool) {
+	rest, ok := strings.CutPrefix(id, directPrefix)
+	if !ok {
+		return 0, 0, false
+	}
+	first, second, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, 0, false
+	}
+	a, errA := strconv.ParseInt(first, 10, 64)
+	b, errB := strconv.ParseInt(second, 10, 64)
+	if errA != nil || errB != nil || a <= 0 || a >= b || DirectRoomID(a, b) != id {
+		return 0, 0, false
+	}
+	return a, b, true
+}
+
+// IsParticipant reports whether the user takes part in the direct room.
+func IsParticipant(id string, userID int64) bool {
+	a, b, ok := DirectParticipants(id)
+	return ok && (userID == a || userID == b)
+}
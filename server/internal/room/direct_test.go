@@ -0,0 +1,72 @@
+package room
+
+import "testing"
+
+func TestDirectRoomID(t *testing.T) {
+	tests := []struct {
+		a, b int64
+		want string
+	}{
+		{1, 2, "dm:1:2"},
+		{2, 1, "dm:1:2"},
+		{7, 42, "dm:7:42"},
+		{42, 7, "dm:7:42"},
+	}
+	for _, tt := range tests {
+		if got := DirectRoomID(tt.a, tt.b); got != tt.want {
+			t.Errorf("DirectRoomID(%d, %d) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDirectParticipants(t *testing.T) {
+	tests := []struct {
+		id     string
+		a, b   int64
+		direct bool
+	}{
+		{"dm:1:2", 1, 2, true},
+		{"dm:7:42", 7, 42, true},
+		{"lobby", 0, 0, false},
+		{"dm:", 0, 0, false},
+		{"dm:1", 0, 0, false},
+		{"dm:1:", 0, 0, false},
+		{"dm::2", 0, 0, false},
+		{"dm:2:1", 0, 0, false},
+		{"dm:1:1", 0, 0, false},
+		{"dm:0:1", 0, 0, false},
+		{"dm:-1:2", 0, 0, false},
+		{"dm:01:2", 0, 0, false},
+		{"dm:+1:2", 0, 0, false},
+		{"dm:1:2:3", 0, 0, false},
+		{"dm:a:b", 0, 0, false},
+		{"DM:1:2", 0, 0, false},
+		{"dm:1:99999999999999999999", 0, 0, false},
+	}
+	for _, tt := range tests {
+		a, b, ok := DirectParticipants(tt.id)
+		if a != tt.a || b != tt.b || ok != tt.direct {
+			t.Errorf("DirectParticipants(%q) = %d, %d, %v, want %d, %d, %v", tt.id, a, b, ok, tt.a, tt.b, tt.direct)
+		}
+	}
+}
+
+func TestIsParticipant(t *testing.T) {
+	tests := []struct {
+		id     string
+		userID int64
+		want   bool
+	}{
+		{"dm:1:2", 1, true},
+		{"dm:1:2", 2, true},
+		{"dm:1:2", 3, false},
+		{"dm:1:2", 0, false},
+		{"dm:2:1", 1, false},
+		{"lobby", 1, false},
+	}
+	for _, tt := range tests {
+		if got := IsParticipant(tt.id, tt.userID); got != tt.want {
+			t.Errorf("IsParticipant(%q, %d) = %v, want %v", tt.id, tt.userID, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInviteLinkUsable(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	tests := []struct {
+		name string
+		link InviteLink
+		want bool
+	}{
+		{"unlimited", InviteLink{Uses: 100}, true},
+		{"uses left", InviteLink{MaxUses: 2, Uses: 1}, true},
+		{"used up", InviteLink{MaxUses: 2, Uses: 2}, false},
+		{"over the limit", InviteLink{MaxUses: 2, Uses: 3}, false},
+		{"not expired", InviteLink{ExpiresAt: &future}, true},
+		{"expired", InviteLink{ExpiresAt: &past}, false},
+		{"expiring now", InviteLink{ExpiresAt: &now}, false},
+		{"expired with uses left", InviteLink{MaxUses: 2, ExpiresAt: &past}, false},
+		{"used up before expiring", InviteLink{MaxUses: 1, Uses: 1, ExpiresAt: &future}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.link.Usable(now); got != tt.want {
+			t.Errorf("%s: Usable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptInviteLink(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+	repo.users[5] = "uma"
+	past := time.Now().Add(-time.Minute)
+
+	link := func(req *CreateInviteLinkRequest) string {
+		t.Helper()
+		req.RoomID = "den"
+		req.CreatedBy = owner
+		l, err := s.CreateInviteLink(ctx, req)
+		if err != nil {
+			t.Fatalf("CreateInviteLink: %v", err)
+		}
+		return l.Code
+	}
+	once := link(&CreateInviteLinkRequest{MaxUses: 1})
+	expired := link(&CreateInviteLinkRequest{ExpiresAt: &past})
+	revoked := link(&CreateInviteLinkRequest{})
+	if err := s.RevokeInviteLink(ctx, "den", revoked, admin); err != nil {
+		t.Fatalf("RevokeInviteLink: %v", err)
+	}
+	admins := link(&CreateInviteLinkRequest{Role: RoleAdmin})
+
+	tests := []struct {
+		name     string
+		code     string
+		userID   int64
+		wantRole string
+		wantErr  error
+	}{
+		{name: "member following a link", code: once, userID: member, wantRole: RoleMember},
+		{name: "new member", code: once, userID: outsider, wantRole: RoleMember},
+		{name: "used up", code: once, userID: 5, wantErr: ErrInviteLinkExpired},
+		{name: "expired", code: expired, userID: 5, wantErr: ErrInviteLinkExpired},
+		{name: "revoked", code: revoked, userID: 5, wantErr: ErrInviteLinkNotFound},
+		{name: "unknown", code: "nope", userID: 5, wantErr: ErrInviteLinkNotFound},
+		{name: "admin keeps their role", code: admins, userID: admin, wantRole: RoleAdmin},
+		{name: "new admin", code: admins, userID: 5, wantRole: RoleAdmin},
+	}
+	for _, tt := range tests {
+		m, err := s.AcceptInviteLink(ctx, tt.code, tt.userID)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: AcceptInviteLink = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (m.UserID != tt.userID || m.Role != tt.wantRole) {
+			t.Errorf("%s: AcceptInviteLink = %+v, want user %d as %s", tt.name, m, tt.userID, tt.wantRole)
+		}
+	}
+
+	// A member following the link again didn't use it up
+	if l, _ := repo.GetInviteLink(ctx, once); l.Uses != 1 {
+		t.Errorf("link used %d times, want 1", l.Uses)
+	}
+}
+
+func TestAcceptInviteLinkBanned(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+	l, err := s.CreateInviteLink(ctx, &CreateInviteLinkRequest{RoomID: "den", CreatedBy: owner})
+	if err != nil {
+		t.Fatalf("CreateInviteLink: %v", err)
+	}
+	if err := repo.BanMember(ctx, &Ban{RoomID: "den", UserID: outsider, BannedBy: owner}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AcceptInviteLink(ctx, l.Code, outsider); !errors.Is(err, ErrBanned) {
+		t.Errorf("AcceptInviteLink by a banned user = %v, want ErrBanned", err)
+	}
+	if l, _ := repo.GetInviteLink(ctx, l.Code); l.Uses != 0 {
+		t.Errorf("link used %d times by a banned user, want 0", l.Uses)
+	}
+}
@@ -0,0 +1,66 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"server/internal/apperr"
+	"time"
+)
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var (
+	ErrNotMember      = apperr.New(apperr.Forbidden, "not_a_member", "not a member of this room")
+	ErrAlreadyMember  = apperr.New(apperr.Conflict, "already_member", "already a member of this room")
+	ErrInviteExists   = apperr.New(apperr.Conflict, "invite_exists", "user already invited")
+	ErrInviteNotFound = apperr.New(apperr.NotFound, "invite_not_found", "invite not found")
+)
+
+type Member struct {
+	RoomID    string    `json:"room_id" db:"room_id"`
+	UserID    int64     `json:"user_id" db:"user_id"`
+	Username  string    `json:"username" db:"username"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Invite is a pending invitation, it is deleted once accepted or declined.
+type Invite struct {
+	RoomID    string    `json:"room_id" db:"room_id"`
+	RoomName  string    `json:"room_name" db:"room_name"`
+	UserID    int64     `json:"user_id" db:"user_id"`
+	InvitedBy int64     `json:"invited_by" db:"invited_by"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type CreateInviteRequest struct {
+	RoomID    string `json:"-"`
+	UserID    int64  `json:"user_id"`
+	Role      string `json:"role"`
+	InvitedBy int64  `json:"-"`
+}
+
+// CanRead reports whether the user may join the room and read its history.
+// Public rooms are open to everyone, private ones to their members and
+// direct rooms to their two participants.
+func CanRead(ctx context.Context, repository Repository, r *Room, userID int64) (bool, error) {
+	if r.Kind == KindDirect {
+		return IsParticipant(r.ID, userID), nil
+	}
+	if r.Visibility == VisibilityPublic {
+		return true, nil
+	}
+	_, err := repository.GetMember(ctx, r.ID, userID)
+	if errors.Is(err, ErrNotMember) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
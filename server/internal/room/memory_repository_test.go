@@ -0,0 +1,315 @@
+package room
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryRepository keeps rooms in maps the way the Postgres repository
+// stores them, for the service tests. Users are the participants it is
+// given. Conversations and listing rooms are left out.
+type memoryRepository struct {
+	Repository
+	mu       sync.Mutex
+	users    map[int64]string
+	rooms    map[string]*Room
+	members  map[string]map[int64]*Member
+	invites  map[string]map[int64]*Invite
+	links    map[string]*InviteLink
+	bans     map[string]map[int64]*Ban
+	mutes    map[string]map[int64]*Mute
+	audit    []*AuditEntry
+	auditSeq int64
+}
+
+func newMemoryRepository(users map[int64]string) *memoryRepository {
+	return &memoryRepository{
+		users:   users,
+		rooms:   make(map[string]*Room),
+		members: make(map[string]map[int64]*Member),
+		invites: make(map[string]map[int64]*Invite),
+		links:   make(map[string]*InviteLink),
+		bans:    make(map[string]map[int64]*Ban),
+		mutes:   make(map[string]map[int64]*Mute),
+	}
+}
+
+func (r *memoryRepository) CreateRoom(ctx context.Context, room *Room) (*Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rooms[room.ID]; ok {
+		return nil, ErrRoomExists
+	}
+	c := *room
+	r.rooms[c.ID] = &c
+	r.members[c.ID] = make(map[int64]*Member)
+	if c.OwnerID != 0 {
+		r.addMember(c.ID, c.OwnerID, RoleOwner)
+	}
+	created := c
+	return &created, nil
+}
+
+func (r *memoryRepository) GetRoomByID(ctx context.Context, id string) (*Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room, ok := r.rooms[id]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	c := *room
+	return &c, nil
+}
+
+func (r *memoryRepository) UpdateRoom(ctx context.Context, room *Room) (*Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.rooms[room.ID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	stored.Name, stored.Topic, stored.Visibility = room.Name, room.Topic, room.Visibility
+	c := *stored
+	return &c, nil
+}
+
+func (r *memoryRepository) DeleteRoom(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.rooms[id]; !ok {
+		return ErrRoomNotFound
+	}
+	delete(r.rooms, id)
+	delete(r.members, id)
+	delete(r.invites, id)
+	return nil
+}
+
+func (r *memoryRepository) GetParticipant(ctx context.Context, userID int64) (*Participant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name, ok := r.users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &Participant{ID: userID, Username: name}, nil
+}
+
+func (r *memoryRepository) GetMember(ctx context.Context, roomID string, userID int64) (*Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[roomID][userID]
+	if !ok {
+		return nil, ErrNotMember
+	}
+	c := *m
+	return &c, nil
+}
+
+func (r *memoryRepository) ListMembers(ctx context.Context, roomID string) ([]*Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members := []*Member{}
+	for _, m := range r.members[roomID] {
+		c := *m
+		members = append(members, &c)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members, nil
+}
+
+// addMember keeps the role of existing members, like the ON CONFLICT
+// clauses.
+func (r *memoryRepository) addMember(roomID string, userID int64, role string) *Member {
+	if m, ok := r.members[roomID][userID]; ok {
+		return m
+	}
+	if r.members[roomID] == nil {
+		r.members[roomID] = make(map[int64]*Member)
+	}
+	m := &Member{RoomID: roomID, UserID: userID, Username: r.users[userID], Role: role, CreatedAt: time.Now()}
+	r.members[roomID][userID] = m
+	return m
+}
+
+func (r *memoryRepository) CreateInvite(ctx context.Context, invite *Invite) (*Invite, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.users[invite.UserID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	if _, ok := r.invites[invite.RoomID][invite.UserID]; ok {
+		return nil, ErrInviteExists
+	}
+	if r.invites[invite.RoomID] == nil {
+		r.invites[invite.RoomID] = make(map[int64]*Invite)
+	}
+	c := *invite
+	r.invites[c.RoomID][c.UserID] = &c
+	return invite, nil
+}
+
+func (r *memoryRepository) AcceptInvite(ctx context.Context, roomID string, userID int64) (*Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	invite, ok := r.invites[roomID][userID]
+	if !ok {
+		return nil, ErrInviteNotFound
+	}
+	delete(r.invites[roomID], userID)
+	c := *r.addMember(roomID, userID, invite.Role)
+	return &c, nil
+}
+
+func (r *memoryRepository) DeleteInvite(ctx context.Context, roomID string, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.invites[roomID][userID]; !ok {
+		return ErrInviteNotFound
+	}
+	delete(r.invites[roomID], userID)
+	return nil
+}
+
+func (r *memoryRepository) CreateInviteLink(ctx context.Context, link *InviteLink) (*InviteLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := *link
+	r.links[c.Code] = &c
+	created := c
+	return &created, nil
+}
+
+func (r *memoryRepository) GetInviteLink(ctx context.Context, code string) (*InviteLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link, ok := r.links[code]
+	if !ok {
+		return nil, ErrInviteLinkNotFound
+	}
+	c := *link
+	return &c, nil
+}
+
+func (r *memoryRepository) DeleteInviteLink(ctx context.Context, roomID, code string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if link, ok := r.links[code]; !ok || link.RoomID != roomID {
+		return ErrInviteLinkNotFound
+	}
+	delete(r.links, code)
+	return nil
+}
+
+func (r *memoryRepository) UseInviteLink(ctx context.Context, code string, userID int64) (*Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link, ok := r.links[code]
+	if !ok || !link.Usable(time.Now()) {
+		return nil, ErrInviteLinkExpired
+	}
+	link.Uses++
+	c := *r.addMember(link.RoomID, userID, link.Role)
+	return &c, nil
+}
+
+func (r *memoryRepository) BanMember(ctx context.Context, ban *Ban) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.bans[ban.RoomID] == nil {
+		r.bans[ban.RoomID] = make(map[int64]*Ban)
+	}
+	c := *ban
+	r.bans[c.RoomID][c.UserID] = &c
+	delete(r.members[c.RoomID], c.UserID)
+	delete(r.invites[c.RoomID], c.UserID)
+	return nil
+}
+
+func (r *memoryRepository) DeleteBan(ctx context.Context, roomID string, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.bans[roomID][userID]; !ok {
+		return ErrBanNotFound
+	}
+	delete(r.bans[roomID], userID)
+	return nil
+}
+
+func (r *memoryRepository) IsBanned(ctx context.Context, roomID string, userID int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.bans[roomID][userID]
+	return ok, nil
+}
+
+func (r *memoryRepository) MuteMember(ctx context.Context, mute *Mute) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mutes[mute.RoomID] == nil {
+		r.mutes[mute.RoomID] = make(map[int64]*Mute)
+	}
+	c := *mute
+	r.mutes[c.RoomID][c.UserID] = &c
+	return nil
+}
+
+func (r *memoryRepository) DeleteMute(ctx context.Context, roomID string, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.mutes[roomID][userID]
+	if !ok || !m.MutedUntil.After(time.Now()) {
+		return ErrMuteNotFound
+	}
+	delete(r.mutes[roomID], userID)
+	return nil
+}
+
+func (r *memoryRepository) ListMutes(ctx context.Context, roomID string) ([]*Mute, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mutes := []*Mute{}
+	for _, m := range r.mutes[roomID] {
+		if m.MutedUntil.After(time.Now()) {
+			c := *m
+			mutes = append(mutes, &c)
+		}
+	}
+	return mutes, nil
+}
+
+func (r *memoryRepository) SetSlowMode(ctx context.Context, roomID string, seconds int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	room.SlowModeSeconds = seconds
+	return nil
+}
+
+func (r *memoryRepository) CreateAuditEntry(ctx context.Context, entry *AuditEntry) (*AuditEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auditSeq++
+	entry.ID = r.auditSeq
+	c := *entry
+	r.audit = append(r.audit, &c)
+	return entry, nil
+}
+
+func (r *memoryRepository) ListAuditLog(ctx context.Context, roomID string, limit int) ([]*AuditEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entries := []*AuditEntry{}
+	for i := len(r.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		if e := r.audit[i]; e.RoomID == roomID {
+			c := *e
+			entries = append(entries, &c)
+		}
+	}
+	return entries, nil
+}
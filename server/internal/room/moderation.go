@@ -0,0 +1,104 @@
+package room
+
+import (
+	"fmt"
+	"server/internal/apperr"
+	"time"
+)
+
+const (
+	ActionKick     = "kick"
+	ActionBan      = "ban"
+	ActionUnban    = "unban"
+	ActionMute     = "mute"
+	ActionUnmute   = "unmute"
+	ActionSlowMode = "slow_mode"
+)
+
+// AuditLogSize is how many entries of the audit log are returned at once.
+const AuditLogSize = 100
+
+var (
+	ErrBanned         = apperr.New(apperr.Forbidden, "banned", "banned from this room")
+	ErrBanNotFound    = apperr.New(apperr.NotFound, "ban_not_found", "user is not banned")
+	ErrMuteNotFound   = apperr.New(apperr.NotFound, "mute_not_found", "user is not muted")
+	ErrInvalidAction  = apperr.New(apperr.Validation, "invalid_action", "unknown moderation action")
+	ErrModerateSelf   = apperr.New(apperr.Validation, "moderate_self", "can't moderate yourself")
+	ErrNotModeratable = apperr.New(apperr.Forbidden, "not_moderatable", "not allowed to moderate this user")
+)
+
+type Ban struct {
+	RoomID    string    `json:"room_id" db:"room_id"`
+	UserID    int64     `json:"user_id" db:"user_id"`
+	Username  string    `json:"username" db:"username"`
+	BannedBy  int64     `json:"banned_by" db:"banned_by"`
+	Reason    string    `json:"reason" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type Mute struct {
+	RoomID     string    `json:"room_id" db:"room_id"`
+	UserID     int64     `json:"user_id" db:"user_id"`
+	MutedBy    int64     `json:"muted_by" db:"muted_by"`
+	MutedUntil time.Time `json:"muted_until" db:"muted_until"`
+}
+
+// ModerateRequest is one moderation action. Duration is how long a mute
+// lasts, or the slow mode interval where 0 turns it off. Slow mode has no
+// target user.
+type ModerateRequest struct {
+	RoomID   string `json:"-"`
+	ActorID  int64  `json:"-"`
+	Action   string `json:"-"`
+	UserID   int64  `json:"user_id"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+// AuditEntry records a moderation action. Names are empty for users that
+// were deleted since.
+type AuditEntry struct {
+	ID         int64     `json:"id" db:"id"`
+	RoomID     string    `json:"room_id" db:"room_id"`
+	Action     string    `json:"action" db:"action"`
+	ActorID    int64     `json:"actor_id" db:"actor_id"`
+	ActorName  string    `json:"actor_name"`
+	TargetID   int64     `json:"target_id,omitempty" db:"target_id"`
+	TargetName string    `json:"target_name,omitempty"`
+	Reason     string    `json:"reason" db:"reason"`
+	Seconds    int64     `json:"duration_seconds,omitempty" db:"duration_seconds"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+func (e *AuditEntry) Duration() time.Duration {
+	return time.Duration(e.Seconds) * time.Second
+}
+
+// Summary describes the action for the members of the room.
+func (e *AuditEntry) Summary() string {
+	var s string
+	switch e.Action {
+	case ActionKick:
+		s = fmt.Sprintf("%s was kicked by %s", e.TargetName, e.ActorName)
+	case ActionBan:
+		s = fmt.Sprintf("%s was banned by %s", e.TargetName, e.ActorName)
+	case ActionUnban:
+		s = fmt.Sprintf("%s was unbanned by %s", e.TargetName, e.ActorName)
+	case ActionMute:
+		s = fmt.Sprintf("%s was muted for %s by %s", e.TargetName, e.Duration(), e.ActorName)
+	case ActionUnmute:
+		s = fmt.Sprintf("%s was unmuted by %s", e.TargetName, e.ActorName)
+	case ActionSlowMode:
+		if e.Seconds == 0 {
+			s = fmt.Sprintf("%s turned slow mode off", e.ActorName)
+		} else {
+			s = fmt.Sprintf("%s turned slow mode on, one message every %s", e.ActorName, e.Duration())
+		}
+	default:
+		s = fmt.Sprintf("%s used %s", e.ActorName, e.Action)
+	}
+	if e.Reason != "" {
+		s += ": " + e.Reason
+	}
+	return s
+}
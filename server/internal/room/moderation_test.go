@@ -0,0 +1,118 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestModeratePermissions(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     ModerateRequest
+		wantErr error
+	}{
+		{name: "owner kicks an admin", req: ModerateRequest{ActorID: owner, UserID: admin}},
+		{name: "admin kicks a member", req: ModerateRequest{ActorID: admin, UserID: member}},
+		{name: "admin kicks an outsider", req: ModerateRequest{ActorID: admin, UserID: outsider}},
+		{name: "admin kicks the owner", req: ModerateRequest{ActorID: admin, UserID: owner}, wantErr: ErrNotModeratable},
+		{name: "admin kicks themselves", req: ModerateRequest{ActorID: admin, UserID: admin}, wantErr: ErrModerateSelf},
+		{name: "member kicks a member", req: ModerateRequest{ActorID: member, UserID: outsider}, wantErr: ErrForbidden},
+		{name: "outsider kicks a member", req: ModerateRequest{ActorID: outsider, UserID: member}, wantErr: ErrForbidden},
+		{name: "unknown user", req: ModerateRequest{ActorID: owner, UserID: 99}, wantErr: ErrUserNotFound},
+		{name: "unknown room", req: ModerateRequest{RoomID: "attic", ActorID: owner, UserID: member}, wantErr: ErrRoomNotFound},
+		{name: "unknown action", req: ModerateRequest{Action: "smite", ActorID: owner, UserID: member}, wantErr: ErrInvalidAction},
+		{name: "bad duration", req: ModerateRequest{Action: ActionMute, ActorID: owner, UserID: member, Duration: "soon"}, wantErr: ErrInvalidAction},
+	}
+	for _, tt := range tests {
+		s, repo, listener := newTestService(t)
+		if tt.req.RoomID == "" {
+			tt.req.RoomID = "lobby"
+		}
+		if tt.req.Action == "" {
+			tt.req.Action = ActionKick
+		}
+		_, err := s.Moderate(context.Background(), &tt.req)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Moderate = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		// Nothing refused is logged or announced
+		if logged := len(repo.audit) == 1 && len(listener.moderated) == 1; logged != (tt.wantErr == nil) {
+			t.Errorf("%s: %d audit entries, %d announced", tt.name, len(repo.audit), len(listener.moderated))
+		}
+	}
+}
+
+func TestModerate(t *testing.T) {
+	s, repo, listener := newTestService(t)
+	ctx := context.Background()
+	moderate := func(req *ModerateRequest) *AuditEntry {
+		t.Helper()
+		req.RoomID = "den"
+		entry, err := s.Moderate(ctx, req)
+		if err != nil {
+			t.Fatalf("Moderate(%s): %v", req.Action, err)
+		}
+		return entry
+	}
+
+	mute := moderate(&ModerateRequest{Action: ActionMute, ActorID: admin, UserID: member, Duration: "1500ms", Reason: "spam"})
+	if mute.Seconds != 2 || mute.TargetName != "mia" || mute.ActorName != "adam" {
+		t.Errorf("mute entry = %+v, want mia muted by adam for 2s", mute)
+	}
+	if mutes, _ := repo.ListMutes(ctx, "den"); len(mutes) != 1 || !mutes[0].MutedUntil.Equal(mute.CreatedAt.Add(mute.Duration())) {
+		t.Errorf("mutes = %+v, want mia until the end of the mute", mutes)
+	}
+	moderate(&ModerateRequest{Action: ActionUnmute, ActorID: admin, UserID: member})
+	if mutes, _ := repo.ListMutes(ctx, "den"); len(mutes) != 0 {
+		t.Errorf("mutes after unmuting = %+v, want none", mutes)
+	}
+	if _, err := s.Moderate(ctx, &ModerateRequest{RoomID: "den", Action: ActionUnmute, ActorID: admin, UserID: member}); !errors.Is(err, ErrMuteNotFound) {
+		t.Errorf("second unmute = %v, want ErrMuteNotFound", err)
+	}
+
+	slow := moderate(&ModerateRequest{Action: ActionSlowMode, ActorID: admin, Duration: "30s"})
+	if r, _ := repo.GetRoomByID(ctx, "den"); r.SlowModeSeconds != 30 || slow.TargetID != 0 {
+		t.Errorf("slow mode is %ds with entry %+v, want 30s without a target", r.SlowModeSeconds, slow)
+	}
+	moderate(&ModerateRequest{Action: ActionSlowMode, ActorID: admin, Duration: "0s"})
+	if r, _ := repo.GetRoomByID(ctx, "den"); r.SlowModeSeconds != 0 {
+		t.Errorf("slow mode is %ds after turning it off", r.SlowModeSeconds)
+	}
+
+	moderate(&ModerateRequest{Action: ActionBan, ActorID: owner, UserID: admin, Reason: "rude"})
+	if banned, _ := repo.IsBanned(ctx, "den", admin); !banned {
+		t.Error("admin is not banned")
+	}
+	if _, err := repo.GetMember(ctx, "den", admin); !errors.Is(err, ErrNotMember) {
+		t.Errorf("banned admin's membership = %v, want ErrNotMember", err)
+	}
+	moderate(&ModerateRequest{Action: ActionUnban, ActorID: owner, UserID: admin})
+	if banned, _ := repo.IsBanned(ctx, "den", admin); banned {
+		t.Error("admin is still banned")
+	}
+
+	// The log is newest first and only for owners and admins
+	if _, err := s.ListAuditLog(ctx, "den", member); !errors.Is(err, ErrForbidden) {
+		t.Errorf("ListAuditLog by a member = %v, want ErrForbidden", err)
+	}
+	log, err := s.ListAuditLog(ctx, "den", owner)
+	if err != nil {
+		t.Fatalf("ListAuditLog: %v", err)
+	}
+	want := []string{ActionUnban, ActionBan, ActionSlowMode, ActionSlowMode, ActionUnmute, ActionMute}
+	if len(log) != len(want) {
+		t.Fatalf("audit log has %d entries, want %d", len(log), len(want))
+	}
+	for i, e := range log {
+		if e.Action != want[i] || e.RoomID != "den" {
+			t.Errorf("audit entry %d = %s in %s, want %s in den", i, e.Action, e.RoomID, want[i])
+		}
+	}
+	if log[1].Reason != "rude" || log[1].TargetName != "adam" {
+		t.Errorf("ban entry %+v lost its target or reason", log[1])
+	}
+	if len(listener.moderated) != len(want) {
+		t.Errorf("listener was told about %d actions, want %d", len(listener.moderated), len(want))
+	}
+}
@@ -0,0 +1,108 @@
+package room
+
+import (
+	"context"
+	"server/internal/apperr"
+	"time"
+)
+
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+var (
+	ErrRoomNotFound = apperr.New(apperr.NotFound, "room_not_found", "room not found")
+	ErrRoomExists   = apperr.New(apperr.Conflict, "room_exists", "room already exists")
+	ErrForbidden    = apperr.New(apperr.Forbidden, "room_forbidden", "not allowed to manage this room")
+	ErrInvalidRoom  = apperr.New(apperr.Validation, "invalid_room", "invalid room")
+)
+
+type Room struct {
+	ID         string `json:"id" db:"id"`
+	OwnerID    int64  `json:"owner_id" db:"owner_id"`
+	Name       string `json:"name" db:"name"`
+	Topic      string `json:"topic" db:"topic"`
+	Visibility string `json:"visibility" db:"visibility"`
+	Kind       string `json:"kind" db:"kind"`
+	// SlowModeSeconds is how long members wait between two messages
+	SlowModeSeconds int       `json:"slow_mode_seconds" db:"slow_mode_seconds"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+}
+
+type CreateRoomRequest struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Topic      string `json:"topic"`
+	Visibility string `json:"visibility"`
+	OwnerID    int64  `json:"-"`
+}
+
+// UpdateRoomRequest only changes the fields that are set.
+type UpdateRoomRequest struct {
+	ID         string  `json:"-"`
+	Name       *string `json:"name"`
+	Topic      *string `json:"topic"`
+	Visibility *string `json:"visibility"`
+	UserID     int64   `json:"-"`
+}
+
+// Listener is told about room changes so live state can follow the database.
+type Listener interface {
+	RoomUpdated(room *Room)
+	RoomDeleted(id string)
+	Moderated(entry *AuditEntry)
+}
+
+type Repository interface {
+	CreateRoom(ctx context.Context, room *Room) (*Room, error)
+	GetRoomByID(ctx context.Context, id string) (*Room, error)
+	ListRooms(ctx context.Context, userID int64) ([]*Room, error)
+	UpdateRoom(ctx context.Context, room *Room) (*Room, error)
+	DeleteRoom(ctx context.Context, id string) error
+	GetParticipant(ctx context.Context, userID int64) (*Participant, error)
+	CreateDirectRoom(ctx context.Context, a, b int64, createdAt time.Time) error
+	ListConversations(ctx context.Context, userID int64) ([]*Conversation, error)
+	GetMember(ctx context.Context, roomID string, userID int64) (*Member, error)
+	ListMembers(ctx context.Context, roomID string) ([]*Member, error)
+	CreateInvite(ctx context.Context, invite *Invite) (*Invite, error)
+	ListInvites(ctx context.Context, userID int64) ([]*Invite, error)
+	AcceptInvite(ctx context.Context, roomID string, userID int64) (*Member, error)
+	DeleteInvite(ctx context.Context, roomID string, userID int64) error
+	CreateInviteLink(ctx context.Context, link *InviteLink) (*InviteLink, error)
+	GetInviteLink(ctx context.Context, code string) (*InviteLink, error)
+	ListInviteLinks(ctx context.Context, roomID string) ([]*InviteLink, error)
+	DeleteInviteLink(ctx context.Context, roomID, code string) error
+	UseInviteLink(ctx context.Context, code string, userID int64) (*Member, error)
+	BanMember(ctx context.Context, ban *Ban) error
+	DeleteBan(ctx context.Context, roomID string, userID int64) error
+	IsBanned(ctx context.Context, roomID string, userID int64) (bool, error)
+	ListBans(ctx context.Context, roomID string) ([]*Ban, error)
+	MuteMember(ctx context.Context, mute *Mute) error
+	DeleteMute(ctx context.Context, roomID string, userID int64) error
+	ListMutes(ctx context.Context, roomID string) ([]*Mute, error)
+	SetSlowMode(ctx context.Context, roomID string, seconds int) error
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) (*AuditEntry, error)
+	ListAuditLog(ctx context.Context, roomID string, limit int) ([]*AuditEntry, error)
+}
+
+type Service interface {
+	CreateRoom(c context.Context, req *CreateRoomRequest) (*Room, error)
+	GetRoom(c context.Context, id string, userID int64) (*Room, error)
+	ListRooms(c context.Context, userID int64) ([]*Room, error)
+	UpdateRoom(c context.Context, req *UpdateRoomRequest) (*Room, error)
+	DeleteRoom(c context.Context, id string, userID int64) error
+	ListConversations(c context.Context, userID int64) ([]*Conversation, error)
+	ListMembers(c context.Context, roomID string, userID int64) ([]*Member, error)
+	CreateInvite(c context.Context, req *CreateInviteRequest) (*Invite, error)
+	ListInvites(c context.Context, userID int64) ([]*Invite, error)
+	AcceptInvite(c context.Context, roomID string, userID int64) (*Member, error)
+	DeclineInvite(c context.Context, roomID string, userID int64) error
+	CreateInviteLink(c context.Context, req *CreateInviteLinkRequest) (*InviteLink, error)
+	ListInviteLinks(c context.Context, roomID string, userID int64) ([]*InviteLink, error)
+	RevokeInviteLink(c context.Context, roomID, code string, userID int64) error
+	AcceptInviteLink(c context.Context, code string, userID int64) (*Member, error)
+	Moderate(c context.Context, req *ModerateRequest) (*AuditEntry, error)
+	ListBans(c context.Context, roomID string, userID int64) ([]*Ban, error)
+	ListAuditLog(c context.Context, roomID string, userID int64) ([]*AuditEntry, error)
+}
@@ -0,0 +1,438 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"server/internal/auth"
+	"server/internal/utils"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type Handler struct {
+	Service
+}
+
+func NewHandler(s Service) *Handler {
+	return &Handler{
+		Service: s,
+	}
+}
+
+func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+	var req CreateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	req.OwnerID = identity.UserID
+
+	room, err := h.Service.CreateRoom(ctx, &req)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not create room", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(room)
+	log.Println("created a room")
+}
+
+func (h *Handler) ListRooms(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	rooms, err := h.Service.ListRooms(ctx, identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not list rooms", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rooms)
+}
+
+func (h *Handler) GetRoom(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	room, err := h.Service.GetRoom(ctx, chi.URLParam(r, "id"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not load room", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(room)
+}
+
+func (h *Handler) UpdateRoom(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+	var req UpdateRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	req.ID = chi.URLParam(r, "id")
+	req.UserID = identity.UserID
+
+	room, err := h.Service.UpdateRoom(ctx, &req)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not update room", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(room)
+	log.Println("updated a room")
+}
+
+func (h *Handler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	if err := h.Service.DeleteRoom(ctx, chi.URLParam(r, "id"), identity.UserID); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not delete room", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Println("deleted a room")
+}
+
+// ListConversations lists the direct conversations of the user, the most
+// recently active first.
+func (h *Handler) ListConversations(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	conversations, err := h.Service.ListConversations(ctx, identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not list conversations", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(conversations)
+}
+
+func (h *Handler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	members, err := h.Service.ListMembers(ctx, chi.URLParam(r, "id"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not list members", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	req.RoomID = chi.URLParam(r, "id")
+	req.InvitedBy = identity.UserID
+
+	invite, err := h.Service.CreateInvite(ctx, &req)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not invite user", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// ListInvites lists the invitations waiting for the user.
+func (h *Handler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	invites, err := h.Service.ListInvites(ctx, identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not list invites", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(invites)
+}
+
+func (h *Handler) AcceptInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	member, err := h.Service.AcceptInvite(ctx, chi.URLParam(r, "id"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not accept invite", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *Handler) DeclineInvite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	if err := h.Service.DeclineInvite(ctx, chi.URLParam(r, "id"), identity.UserID); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not decline invite", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) CreateInviteLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+	var req CreateInviteLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	req.RoomID = chi.URLParam(r, "id")
+	req.CreatedBy = identity.UserID
+
+	link, err := h.Service.CreateInviteLink(ctx, &req)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not create invite link", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// ListInviteLinks lists the links of a room that can still be used.
+func (h *Handler) ListInviteLinks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	links, err := h.Service.ListInviteLinks(ctx, chi.URLParam(r, "id"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not list invite links", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(links)
+}
+
+func (h *Handler) RevokeInviteLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	err := h.Service.RevokeInviteLink(ctx, chi.URLParam(r, "id"), chi.URLParam(r, "code"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not revoke invite link", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) AcceptInviteLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	member, err := h.Service.AcceptInviteLink(ctx, chi.URLParam(r, "code"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not accept invite link", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(member)
+}
+
+func (h *Handler) Kick(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, ActionKick)
+}
+
+func (h *Handler) Ban(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, ActionBan)
+}
+
+func (h *Handler) Unban(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, ActionUnban)
+}
+
+func (h *Handler) Mute(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, ActionMute)
+}
+
+func (h *Handler) Unmute(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, ActionUnmute)
+}
+
+func (h *Handler) SetSlowMode(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, ActionSlowMode)
+}
+
+// moderate runs action and replies with its audit entry. The target is
+// taken from the URL when it names one, else from the body.
+func (h *Handler) moderate(w http.ResponseWriter, r *http.Request, action string) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+	var req ModerateRequest
+	// Undoing an action needs no body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if userID := chi.URLParam(r, "userId"); userID != "" {
+		id, err := strconv.ParseInt(userID, 10, 64)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, "invalid user ID", err)
+			return
+		}
+		req.UserID = id
+	}
+	req.RoomID = chi.URLParam(r, "id")
+	req.ActorID = identity.UserID
+	req.Action = action
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+
+	entry, err := h.Service.Moderate(ctx, &req)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not moderate room", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entry)
+	log.Printf("moderated room %s: %s", entry.RoomID, entry.Action)
+}
+
+func (h *Handler) ListBans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	bans, err := h.Service.ListBans(ctx, chi.URLParam(r, "id"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not list bans", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(bans)
+}
+
+func (h *Handler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	entries, err := h.Service.ListAuditLog(ctx, chi.URLParam(r, "id"), identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not load audit log", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,641 @@
+package room
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
+type repository struct {
+	db DBTX
+}
+
+func NewRepository(db DBTX) Repository {
+	return &repository{db: db}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const roomColumns = `id, owner_id, name, topic, visibility, kind, slow_mode_seconds, created_at`
+
+func scanRoom(row scanner) (*Room, error) {
+	var r Room
+	var ownerID sql.NullInt64
+	err := row.Scan(&r.ID, &ownerID, &r.Name, &r.Topic, &r.Visibility, &r.Kind, &r.SlowModeSeconds, &r.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	r.OwnerID = ownerID.Int64
+	return &r, nil
+}
+
+func (r *repository) CreateRoom(ctx context.Context, room *Room) (*Room, error) {
+	// The owner becomes the first member in the same statement
+	query := `WITH room AS (
+				  INSERT INTO rooms (id, owner_id, name, topic, visibility, kind, created_at)
+				  VALUES ($1, $2, $3, $4, $5, $6, $7)
+				  RETURNING ` + roomColumns + `
+			  ), owner AS (
+				  INSERT INTO room_members (room_id, user_id, role, created_at)
+				  SELECT id, owner_id, $8, created_at FROM room WHERE owner_id IS NOT NULL
+			  )
+			  SELECT ` + roomColumns + ` FROM room`
+	created, err := scanRoom(r.db.QueryRowContext(ctx,
+		query,
+		room.ID,
+		sql.NullInt64{Int64: room.OwnerID, Valid: room.OwnerID != 0},
+		room.Name,
+		room.Topic,
+		room.Visibility,
+		room.Kind,
+		room.CreatedAt,
+		RoleOwner,
+	))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrRoomExists
+		}
+		return nil, fmt.Errorf("error inserting room: %w", err)
+	}
+	return created, nil
+}
+
+func (r *repository) GetRoomByID(ctx context.Context, id string) (*Room, error) {
+	query := `SELECT ` + roomColumns + ` FROM rooms WHERE id = $1`
+	room, err := scanRoom(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve room: %w", err)
+	}
+	return room, nil
+}
+
+// ListRooms returns the public rooms plus every room the user is a member of.
+func (r *repository) ListRooms(ctx context.Context, userID int64) ([]*Room, error) {
+	query := `SELECT ` + roomColumns + ` FROM rooms
+			  WHERE visibility = $1 OR id IN (SELECT room_id FROM room_members WHERE user_id = $2)
+			  ORDER BY created_at, id`
+	rows, err := r.db.QueryContext(ctx, query, VisibilityPublic, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve rooms: %w", err)
+	}
+	defer rows.Close()
+
+	rooms := []*Room{}
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error failed to retrieve rooms: %w", err)
+		}
+		rooms = append(rooms, room)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve rooms: %w", err)
+	}
+	return rooms, nil
+}
+
+func (r *repository) UpdateRoom(ctx context.Context, room *Room) (*Room, error) {
+	query := `UPDATE rooms SET name = $2, topic = $3, visibility = $4 WHERE id = $1
+			  RETURNING ` + roomColumns
+	updated, err := scanRoom(r.db.QueryRowContext(ctx, query, room.ID, room.Name, room.Topic, room.Visibility))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to update room: %w", err)
+	}
+	return updated, nil
+}
+
+func (r *repository) DeleteRoom(ctx context.Context, id string) error {
+	query := `DELETE FROM rooms WHERE id = $1`
+	res, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("error failed to delete room: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+func (r *repository) GetParticipant(ctx context.Context, userID int64) (*Participant, error) {
+	query := `SELECT id, username FROM users WHERE id = $1`
+	var p Participant
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(&p.ID, &p.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve user: %w", err)
+	}
+	return &p, nil
+}
+
+// CreateDirectRoom stores the conversation between two users unless it
+// already exists.
+func (r *repository) CreateDirectRoom(ctx context.Context, a, b int64, createdAt time.Time) error {
+	if a > b {
+		a, b = b, a
+	}
+	query := `WITH room AS (
+				  INSERT INTO rooms (id, name, visibility, kind, created_at) VALUES ($1, '', $4, $5, $6)
+				  ON CONFLICT (id) DO NOTHING
+			  )
+			  INSERT INTO direct_rooms (room_id, user_a, user_b) VALUES ($1, $2, $3)
+			  ON CONFLICT (room_id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query, DirectRoomID(a, b), a, b, VisibilityPrivate, KindDirect, createdAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("error inserting direct room: %w", err)
+	}
+	return nil
+}
+
+// ListConversations returns the direct conversations of the user, the
+// most recently active first.
+func (r *repository) ListConversations(ctx context.Context, userID int64) ([]*Conversation, error) {
+	query := `SELECT d.room_id, u.id, u.username, r.created_at, m.id, m.username, m.content, m.created_at
+			  FROM direct_rooms d
+			  JOIN rooms r ON r.id = d.room_id
+			  JOIN users u ON u.id = CASE WHEN d.user_a = $1 THEN d.user_b ELSE d.user_a END
+			  LEFT JOIN LATERAL (
+				  SELECT id, username, content, created_at FROM messages
+				  WHERE room_id = d.room_id ORDER BY id DESC LIMIT 1
+			  ) m ON true
+			  WHERE d.user_a = $1 OR d.user_b = $1
+			  ORDER BY COALESCE(m.created_at, r.created_at) DESC, d.room_id`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []*Conversation{}
+	for rows.Next() {
+		var c Conversation
+		var createdAt time.Time
+		var messageID sql.NullInt64
+		var username, content sql.NullString
+		var sentAt sql.NullTime
+		err := rows.Scan(&c.ID, &c.With.ID, &c.With.Username, &createdAt, &messageID, &username, &content, &sentAt)
+		if err != nil {
+			return nil, fmt.Errorf("error failed to retrieve conversations: %w", err)
+		}
+		c.LastActivityAt = createdAt
+		if messageID.Valid {
+			c.LastMessage = &LastMessage{
+				ID:        messageID.Int64,
+				Username:  username.String,
+				Content:   content.String,
+				CreatedAt: sentAt.Time,
+			}
+			c.LastActivityAt = sentAt.Time
+		}
+		conversations = append(conversations, &c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve conversations: %w", err)
+	}
+	return conversations, nil
+}
+
+const memberColumns = `m.room_id, m.user_id, u.username, m.role, m.created_at`
+
+func scanMember(row scanner) (*Member, error) {
+	var m Member
+	if err := row.Scan(&m.RoomID, &m.UserID, &m.Username, &m.Role, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *repository) GetMember(ctx context.Context, roomID string, userID int64) (*Member, error) {
+	query := `SELECT ` + memberColumns + ` FROM room_members m JOIN users u ON u.id = m.user_id
+			  WHERE m.room_id = $1 AND m.user_id = $2`
+	m, err := scanMember(r.db.QueryRowContext(ctx, query, roomID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve member: %w", err)
+	}
+	return m, nil
+}
+
+// ListMembers returns the members of a room in the order they joined.
+func (r *repository) ListMembers(ctx context.Context, roomID string) ([]*Member, error) {
+	query := `SELECT ` + memberColumns + ` FROM room_members m JOIN users u ON u.id = m.user_id
+			  WHERE m.room_id = $1
+			  ORDER BY m.created_at, m.user_id`
+	rows, err := r.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []*Member{}
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error failed to retrieve members: %w", err)
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve members: %w", err)
+	}
+	return members, nil
+}
+
+func (r *repository) CreateInvite(ctx context.Context, invite *Invite) (*Invite, error) {
+	query := `INSERT INTO room_invites (room_id, user_id, invited_by, role, created_at)
+			  VALUES ($1, $2, $3, $4, $5)`
+	_, err := r.db.ExecContext(ctx, query, invite.RoomID, invite.UserID, invite.InvitedBy, invite.Role, invite.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrInviteExists
+		}
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("error inserting invite: %w", err)
+	}
+	return invite, nil
+}
+
+// ListInvites returns the invitations waiting for the user, newest first.
+func (r *repository) ListInvites(ctx context.Context, userID int64) ([]*Invite, error) {
+	query := `SELECT i.room_id, r.name, i.user_id, i.invited_by, i.role, i.created_at
+			  FROM room_invites i JOIN rooms r ON r.id = i.room_id
+			  WHERE i.user_id = $1
+			  ORDER BY i.created_at DESC, i.room_id`
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := []*Invite{}
+	for rows.Next() {
+		var i Invite
+		var invitedBy sql.NullInt64
+		if err := rows.Scan(&i.RoomID, &i.RoomName, &i.UserID, &invitedBy, &i.Role, &i.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error failed to retrieve invites: %w", err)
+		}
+		i.InvitedBy = invitedBy.Int64
+		invites = append(invites, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve invites: %w", err)
+	}
+	return invites, nil
+}
+
+// AcceptInvite turns the invitation into a membership. A user who already
+// is a member keeps the role they have.
+func (r *repository) AcceptInvite(ctx context.Context, roomID string, userID int64) (*Member, error) {
+	query := `WITH invite AS (
+				  DELETE FROM room_invites WHERE room_id = $1 AND user_id = $2
+				  RETURNING room_id, user_id, role
+			  ), m AS (
+				  INSERT INTO room_members (room_id, user_id, role, created_at)
+				  SELECT room_id, user_id, role, $3 FROM invite
+				  ON CONFLICT (room_id, user_id) DO UPDATE SET role = room_members.role
+				  RETURNING room_id, user_id, role, created_at
+			  )
+			  SELECT ` + memberColumns + ` FROM m JOIN users u ON u.id = m.user_id`
+	m, err := scanMember(r.db.QueryRowContext(ctx, query, roomID, userID, time.Now()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to accept invite: %w", err)
+	}
+	return m, nil
+}
+
+func (r *repository) DeleteInvite(ctx context.Context, roomID string, userID int64) error {
+	query := `DELETE FROM room_invites WHERE room_id = $1 AND user_id = $2`
+	res, err := r.db.ExecContext(ctx, query, roomID, userID)
+	if err != nil {
+		return fmt.Errorf("error failed to delete invite: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+const inviteLinkColumns = `code, room_id, created_by, role, max_uses, uses, expires_at, created_at`
+
+func scanInviteLink(row scanner) (*InviteLink, error) {
+	var l InviteLink
+	var createdBy, maxUses sql.NullInt64
+	err := row.Scan(&l.Code, &l.RoomID, &createdBy, &l.Role, &maxUses, &l.Uses, &l.ExpiresAt, &l.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	l.CreatedBy = createdBy.Int64
+	l.MaxUses = int(maxUses.Int64)
+	return &l, nil
+}
+
+func (r *repository) CreateInviteLink(ctx context.Context, link *InviteLink) (*InviteLink, error) {
+	query := `INSERT INTO room_invite_links (code, room_id, created_by, role, max_uses, expires_at, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)
+			  RETURNING ` + inviteLinkColumns
+	created, err := scanInviteLink(r.db.QueryRowContext(ctx,
+		query,
+		link.Code,
+		link.RoomID,
+		sql.NullInt64{Int64: link.CreatedBy, Valid: link.CreatedBy != 0},
+		link.Role,
+		sql.NullInt64{Int64: int64(link.MaxUses), Valid: link.MaxUses != 0},
+		link.ExpiresAt,
+		link.CreatedAt,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error inserting invite link: %w", err)
+	}
+	return created, nil
+}
+
+func (r *repository) GetInviteLink(ctx context.Context, code string) (*InviteLink, error) {
+	query := `SELECT ` + inviteLinkColumns + ` FROM room_invite_links WHERE code = $1`
+	link, err := scanInviteLink(r.db.QueryRowContext(ctx, query, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteLinkNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve invite link: %w", err)
+	}
+	return link, nil
+}
+
+// ListInviteLinks returns the links of a room that can still be used,
+// newest first.
+func (r *repository) ListInviteLinks(ctx context.Context, roomID string) ([]*InviteLink, error) {
+	query := `SELECT ` + inviteLinkColumns + ` FROM room_invite_links
+			  WHERE room_id = $1 AND (max_uses IS NULL OR uses < max_uses) AND (expires_at IS NULL OR expires_at > $2)
+			  ORDER BY created_at DESC, code`
+	rows, err := r.db.QueryContext(ctx, query, roomID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve invite links: %w", err)
+	}
+	defer rows.Close()
+
+	links := []*InviteLink{}
+	for rows.Next() {
+		link, err := scanInviteLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error failed to retrieve invite links: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve invite links: %w", err)
+	}
+	return links, nil
+}
+
+func (r *repository) DeleteInviteLink(ctx context.Context, roomID, code string) error {
+	query := `DELETE FROM room_invite_links WHERE room_id = $1 AND code = $2`
+	res, err := r.db.ExecContext(ctx, query, roomID, code)
+	if err != nil {
+		return fmt.Errorf("error failed to delete invite link: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrInviteLinkNotFound
+	}
+	return nil
+}
+
+// UseInviteLink counts one use of the link and makes the user a member, in
+// one statement so concurrent uses can't go over the limit. It returns
+// ErrInviteLinkExpired when the link can't be used anymore.
+func (r *repository) UseInviteLink(ctx context.Context, code string, userID int64) (*Member, error) {
+	query := `WITH link AS (
+				  UPDATE room_invite_links SET uses = uses + 1
+				  WHERE code = $1 AND (max_uses IS NULL OR uses < max_uses) AND (expires_at IS NULL OR expires_at > $3)
+				  RETURNING room_id, role
+			  ), m AS (
+				  INSERT INTO room_members (room_id, user_id, role, created_at)
+				  SELECT room_id, $2, role, $3 FROM link
+				  ON CONFLICT (room_id, user_id) DO UPDATE SET role = room_members.role
+				  RETURNING room_id, user_id, role, created_at
+			  )
+			  SELECT ` + memberColumns + ` FROM m JOIN users u ON u.id = m.user_id`
+	m, err := scanMember(r.db.QueryRowContext(ctx, query, code, userID, time.Now()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteLinkExpired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to use invite link: %w", err)
+	}
+	return m, nil
+}
+
+// BanMember bans the user, who also loses their membership and pending
+// invitation.
+func (r *repository) BanMember(ctx context.Context, ban *Ban) error {
+	query := `WITH ban AS (
+				  INSERT INTO room_bans (room_id, user_id, banned_by, reason, created_at)
+				  VALUES ($1, $2, $3, $4, $5)
+				  ON CONFLICT (room_id, user_id) DO UPDATE SET banned_by = $3, reason = $4
+			  ), member AS (
+				  DELETE FROM room_members WHERE room_id = $1 AND user_id = $2
+			  )
+			  DELETE FROM room_invites WHERE room_id = $1 AND user_id = $2`
+	_, err := r.db.ExecContext(ctx, query, ban.RoomID, ban.UserID, ban.BannedBy, ban.Reason, ban.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error inserting ban: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) DeleteBan(ctx context.Context, roomID string, userID int64) error {
+	query := `DELETE FROM room_bans WHERE room_id = $1 AND user_id = $2`
+	res, err := r.db.ExecContext(ctx, query, roomID, userID)
+	if err != nil {
+		return fmt.Errorf("error failed to delete ban: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+func (r *repository) IsBanned(ctx context.Context, roomID string, userID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM room_bans WHERE room_id = $1 AND user_id = $2)`
+	var banned bool
+	if err := r.db.QueryRowContext(ctx, query, roomID, userID).Scan(&banned); err != nil {
+		return false, fmt.Errorf("error failed to retrieve ban: %w", err)
+	}
+	return banned, nil
+}
+
+// ListBans returns the bans of a room, newest first.
+func (r *repository) ListBans(ctx context.Context, roomID string) ([]*Ban, error) {
+	query := `SELECT b.room_id, b.user_id, u.username, b.banned_by, b.reason, b.created_at
+			  FROM room_bans b JOIN users u ON u.id = b.user_id
+			  WHERE b.room_id = $1
+			  ORDER BY b.created_at DESC, b.user_id`
+	rows, err := r.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve bans: %w", err)
+	}
+	defer rows.Close()
+
+	bans := []*Ban{}
+	for rows.Next() {
+		var b Ban
+		var bannedBy sql.NullInt64
+		if err := rows.Scan(&b.RoomID, &b.UserID, &b.Username, &bannedBy, &b.Reason, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error failed to retrieve bans: %w", err)
+		}
+		b.BannedBy = bannedBy.Int64
+		bans = append(bans, &b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve bans: %w", err)
+	}
+	return bans, nil
+}
+
+// MuteMember mutes the user until mute.MutedUntil, replacing any earlier
+// mute.
+func (r *repository) MuteMember(ctx context.Context, mute *Mute) error {
+	query := `INSERT INTO room_mutes (room_id, user_id, muted_by, muted_until) VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (room_id, user_id) DO UPDATE SET muted_by = $3, muted_until = $4`
+	_, err := r.db.ExecContext(ctx, query, mute.RoomID, mute.UserID, mute.MutedBy, mute.MutedUntil)
+	if err != nil {
+		return fmt.Errorf("error inserting mute: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) DeleteMute(ctx context.Context, roomID string, userID int64) error {
+	query := `DELETE FROM room_mutes WHERE room_id = $1 AND user_id = $2 AND muted_until > $3`
+	res, err := r.db.ExecContext(ctx, query, roomID, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("error failed to delete mute: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMuteNotFound
+	}
+	return nil
+}
+
+// ListMutes returns the mutes of a room that haven't run out.
+func (r *repository) ListMutes(ctx context.Context, roomID string) ([]*Mute, error) {
+	query := `SELECT room_id, user_id, muted_by, muted_until FROM room_mutes
+			  WHERE room_id = $1 AND muted_until > $2`
+	rows, err := r.db.QueryContext(ctx, query, roomID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve mutes: %w", err)
+	}
+	defer rows.Close()
+
+	mutes := []*Mute{}
+	for rows.Next() {
+		var m Mute
+		var mutedBy sql.NullInt64
+		if err := rows.Scan(&m.RoomID, &m.UserID, &mutedBy, &m.MutedUntil); err != nil {
+			return nil, fmt.Errorf("error failed to retrieve mutes: %w", err)
+		}
+		m.MutedBy = mutedBy.Int64
+		mutes = append(mutes, &m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve mutes: %w", err)
+	}
+	return mutes, nil
+}
+
+func (r *repository) SetSlowMode(ctx context.Context, roomID string, seconds int) error {
+	query := `UPDATE rooms SET slow_mode_seconds = $2 WHERE id = $1`
+	res, err := r.db.ExecContext(ctx, query, roomID, seconds)
+	if err != nil {
+		return fmt.Errorf("error failed to set slow mode: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrRoomNotFound
+	}
+	return nil
+}
+
+func (r *repository) CreateAuditEntry(ctx context.Context, entry *AuditEntry) (*AuditEntry, error) {
+	query := `INSERT INTO room_audit_log (room_id, action, actor_id, target_id, reason, duration_seconds, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := r.db.QueryRowContext(ctx,
+		query,
+		entry.RoomID,
+		entry.Action,
+		sql.NullInt64{Int64: entry.ActorID, Valid: entry.ActorID != 0},
+		sql.NullInt64{Int64: entry.TargetID, Valid: entry.TargetID != 0},
+		entry.Reason,
+		entry.Seconds,
+		entry.CreatedAt,
+	).Scan(&entry.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting audit entry: %w", err)
+	}
+	return entry, nil
+}
+
+// ListAuditLog returns the latest entries of a room, newest first.
+func (r *repository) ListAuditLog(ctx context.Context, roomID string, limit int) ([]*AuditEntry, error) {
+	query := `SELECT l.id, l.room_id, l.action, l.actor_id, COALESCE(a.username, ''), l.target_id, COALESCE(t.username, ''),
+			  l.reason, l.duration_seconds, l.created_at
+			  FROM room_audit_log l
+			  LEFT JOIN users a ON a.id = l.actor_id
+			  LEFT JOIN users t ON t.id = l.target_id
+			  WHERE l.room_id = $1
+			  ORDER BY l.id DESC
+			  LIMIT $2`
+	rows, err := r.db.QueryContext(ctx, query, roomID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var actorID, targetID sql.NullInt64
+		err := rows.Scan(&e.ID, &e.RoomID, &e.Action, &actorID, &e.ActorName, &targetID, &e.TargetName,
+			&e.Reason, &e.Seconds, &e.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error failed to retrieve audit log: %w", err)
+		}
+		e.ActorID = actorID.Int64
+		e.TargetID = targetID.Int64
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve audit log: %w", err)
+	}
+	return entries, nil
+}
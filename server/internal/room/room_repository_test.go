@@ -0,0 +1,227 @@
+package room_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"server/db/dbtest"
+	"server/internal/room"
+)
+
+// newRepository returns a repository on the TEST_DATABASE_URL database in a
+// transaction of its own, with the users named.
+func newRepository(t *testing.T, usernames ...string) (room.Repository, *sql.Tx, []int64) {
+	t.Helper()
+	tx := dbtest.Tx(t, dbtest.Open(t))
+	var ids []int64
+	for _, name := range usernames {
+		var id int64
+		query := `INSERT INTO users (username, email, password) VALUES ($1, $1 || '@example.com', 'hash') RETURNING id`
+		if err := tx.QueryRow(query, name).Scan(&id); err != nil {
+			t.Fatalf("could not create user %s: %v", name, err)
+		}
+		ids = append(ids, id)
+	}
+	return room.NewRepository(tx), tx, ids
+}
+
+// failing runs fn, which is expected to fail, in a savepoint so the
+// transaction can go on after it.
+func failing(t *testing.T, tx *sql.Tx, fn func() error) error {
+	t.Helper()
+	if _, err := tx.Exec(`SAVEPOINT expected_failure`); err != nil {
+		t.Fatal(err)
+	}
+	err := fn()
+	if _, rbErr := tx.Exec(`ROLLBACK TO SAVEPOINT expected_failure`); rbErr != nil {
+		t.Fatal(rbErr)
+	}
+	return err
+}
+
+func newRoom(id string, ownerID int64) *room.Room {
+	return &room.Room{
+		ID:         id,
+		OwnerID:    ownerID,
+		Name:       id,
+		Visibility: room.VisibilityPrivate,
+		Kind:       room.KindRoom,
+		CreatedAt:  time.Now(),
+	}
+}
+
+func TestCreateRoom(t *testing.T) {
+	r, tx, users := newRepository(t, "olivia", "mia")
+	ctx := context.Background()
+	owner, other := users[0], users[1]
+
+	created, err := r.CreateRoom(ctx, newRoom("den", owner))
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if created.OwnerID != owner || created.Visibility != room.VisibilityPrivate {
+		t.Errorf("CreateRoom = %+v, want the private room of %d", created, owner)
+	}
+	m, err := r.GetMember(ctx, "den", owner)
+	if err != nil || m.Role != room.RoleOwner || m.Username != "olivia" {
+		t.Errorf("owner's membership = %+v, %v, want olivia as owner", m, err)
+	}
+	if _, err := r.GetMember(ctx, "den", other); !errors.Is(err, room.ErrNotMember) {
+		t.Errorf("GetMember of a non-member = %v, want ErrNotMember", err)
+	}
+
+	err = failing(t, tx, func() error {
+		_, err := r.CreateRoom(ctx, newRoom("den", other))
+		return err
+	})
+	if !errors.Is(err, room.ErrRoomExists) {
+		t.Errorf("CreateRoom with a taken ID = %v, want ErrRoomExists", err)
+	}
+	if _, err := r.GetRoomByID(ctx, "attic"); !errors.Is(err, room.ErrRoomNotFound) {
+		t.Errorf("GetRoomByID of an unknown room = %v, want ErrRoomNotFound", err)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	r, tx, users := newRepository(t, "olivia", "oscar")
+	ctx := context.Background()
+	owner, guest := users[0], users[1]
+	if _, err := r.CreateRoom(ctx, newRoom("den", owner)); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	invite := &room.Invite{RoomID: "den", UserID: guest, InvitedBy: owner, Role: room.RoleAdmin, CreatedAt: time.Now()}
+	if _, err := r.CreateInvite(ctx, invite); err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	err := failing(t, tx, func() error {
+		_, err := r.CreateInvite(ctx, invite)
+		return err
+	})
+	if !errors.Is(err, room.ErrInviteExists) {
+		t.Errorf("second CreateInvite = %v, want ErrInviteExists", err)
+	}
+	err = failing(t, tx, func() error {
+		_, err := r.CreateInvite(ctx, &room.Invite{RoomID: "den", UserID: guest + 1000, InvitedBy: owner, Role: room.RoleMember})
+		return err
+	})
+	if !errors.Is(err, room.ErrUserNotFound) {
+		t.Errorf("CreateInvite of an unknown user = %v, want ErrUserNotFound", err)
+	}
+
+	invites, err := r.ListInvites(ctx, guest)
+	if err != nil || len(invites) != 1 || invites[0].RoomName != "den" || invites[0].Role != room.RoleAdmin {
+		t.Fatalf("ListInvites = %+v, %v, want the admin invite into den", invites, err)
+	}
+
+	m, err := r.AcceptInvite(ctx, "den", guest)
+	if err != nil || m.Role != room.RoleAdmin || m.Username != "oscar" {
+		t.Fatalf("AcceptInvite = %+v, %v, want oscar as admin", m, err)
+	}
+	if _, err := r.AcceptInvite(ctx, "den", guest); !errors.Is(err, room.ErrInviteNotFound) {
+		t.Errorf("second AcceptInvite = %v, want ErrInviteNotFound", err)
+	}
+	if err := r.DeleteInvite(ctx, "den", guest); !errors.Is(err, room.ErrInviteNotFound) {
+		t.Errorf("DeleteInvite after accepting = %v, want ErrInviteNotFound", err)
+	}
+
+	// Accepting an invite keeps the role a member already has
+	if _, err := r.CreateInvite(ctx, &room.Invite{RoomID: "den", UserID: owner, Role: room.RoleMember, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	if m, err := r.AcceptInvite(ctx, "den", owner); err != nil || m.Role != room.RoleOwner {
+		t.Errorf("AcceptInvite by the owner = %+v, %v, want the owner role kept", m, err)
+	}
+}
+
+func TestCreateDirectRoom(t *testing.T) {
+	r, tx, users := newRepository(t, "alice", "bob")
+	ctx := context.Background()
+	a, b := users[0], users[1]
+
+	// Either order stores the same room, once
+	for _, pair := range [][2]int64{{b, a}, {a, b}} {
+		if err := r.CreateDirectRoom(ctx, pair[0], pair[1], time.Now()); err != nil {
+			t.Fatalf("CreateDirectRoom: %v", err)
+		}
+	}
+	stored, err := r.GetRoomByID(ctx, room.DirectRoomID(a, b))
+	if err != nil || stored.Kind != room.KindDirect || stored.Visibility != room.VisibilityPrivate {
+		t.Errorf("GetRoomByID of the direct room = %+v, %v, want a private direct room", stored, err)
+	}
+
+	err = failing(t, tx, func() error {
+		return r.CreateDirectRoom(ctx, a, b+1000, time.Now())
+	})
+	if !errors.Is(err, room.ErrUserNotFound) {
+		t.Errorf("CreateDirectRoom with an unknown user = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUseInviteLink(t *testing.T) {
+	r, _, users := newRepository(t, "olivia", "mia", "oscar", "uma")
+	ctx := context.Background()
+	owner, first, second, third := users[0], users[1], users[2], users[3]
+	if _, err := r.CreateRoom(ctx, newRoom("den", owner)); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	links := map[string]*room.InviteLink{
+		"once":    {Code: "once", RoomID: "den", CreatedBy: owner, Role: room.RoleMember, MaxUses: 1},
+		"expired": {Code: "expired", RoomID: "den", CreatedBy: owner, Role: room.RoleMember, ExpiresAt: &past},
+		"revoked": {Code: "revoked", RoomID: "den", CreatedBy: owner, Role: room.RoleMember},
+		"admins":  {Code: "admins", RoomID: "den", Role: room.RoleAdmin},
+	}
+	for _, l := range links {
+		l.CreatedAt = time.Now()
+		if _, err := r.CreateInviteLink(ctx, l); err != nil {
+			t.Fatalf("CreateInviteLink(%s): %v", l.Code, err)
+		}
+	}
+	if err := r.DeleteInviteLink(ctx, "den", "revoked"); err != nil {
+		t.Fatalf("DeleteInviteLink: %v", err)
+	}
+	if err := r.DeleteInviteLink(ctx, "attic", "once"); !errors.Is(err, room.ErrInviteLinkNotFound) {
+		t.Errorf("DeleteInviteLink from another room = %v, want ErrInviteLinkNotFound", err)
+	}
+
+	tests := []struct {
+		code     string
+		userID   int64
+		wantRole string
+		wantErr  error
+	}{
+		{code: "once", userID: first, wantRole: room.RoleMember},
+		{code: "once", userID: second, wantErr: room.ErrInviteLinkExpired},
+		{code: "expired", userID: second, wantErr: room.ErrInviteLinkExpired},
+		{code: "revoked", userID: second, wantErr: room.ErrInviteLinkExpired},
+		{code: "admins", userID: third, wantRole: room.RoleAdmin},
+		// Members keep their role
+		{code: "admins", userID: owner, wantRole: room.RoleOwner},
+	}
+	for _, tt := range tests {
+		m, err := r.UseInviteLink(ctx, tt.code, tt.userID)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("UseInviteLink(%s) by %d = %v, want %v", tt.code, tt.userID, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (m.UserID != tt.userID || m.Role != tt.wantRole || m.RoomID != "den") {
+			t.Errorf("UseInviteLink(%s) by %d = %+v, want them in den as %s", tt.code, tt.userID, m, tt.wantRole)
+		}
+	}
+	if _, err := r.GetMember(ctx, "den", second); !errors.Is(err, room.ErrNotMember) {
+		t.Errorf("GetMember of a user turned away = %v, want ErrNotMember", err)
+	}
+
+	once, err := r.GetInviteLink(ctx, "once")
+	if err != nil || once.Uses != 1 || once.MaxUses != 1 || once.CreatedBy != owner {
+		t.Errorf("GetInviteLink(once) = %+v, %v, want one use out of one", once, err)
+	}
+	usable, err := r.ListInviteLinks(ctx, "den")
+	if err != nil || len(usable) != 1 || usable[0].Code != "admins" || usable[0].CreatedBy != 0 {
+		t.Errorf("ListInviteLinks = %+v, %v, want only the admin link", usable, err)
+	}
+}
@@ -0,0 +1,446 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type service struct {
+	Repository
+	listener Listener
+	timeout  time.Duration
+}
+
+func NewService(repository Repository, listener Listener) Service {
+	return &service{
+		Repository: repository,
+		listener:   listener,
+		timeout:    time.Duration(2) * time.Second,
+	}
+}
+
+func (s *service) CreateRoom(c context.Context, req *CreateRoomRequest) (*Room, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r := &Room{
+		ID:         req.ID,
+		OwnerID:    req.OwnerID,
+		Name:       req.Name,
+		Topic:      req.Topic,
+		Visibility: req.Visibility,
+		Kind:       KindRoom,
+		CreatedAt:  time.Now(),
+	}
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	if r.Visibility == "" {
+		r.Visibility = VisibilityPublic
+	}
+	if err := validateVisibility(r.Visibility); err != nil {
+		return nil, err
+	}
+
+	return s.Repository.CreateRoom(ctx, r)
+}
+
+func (s *service) GetRoom(c context.Context, id string, userID int64) (*Room, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r, err := s.Repository.GetRoomByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Private rooms are hidden from everyone who can't join them
+	ok, err := CanRead(ctx, s.Repository, r, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return r, nil
+}
+
+func (s *service) ListRooms(c context.Context, userID int64) ([]*Room, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.Repository.ListRooms(ctx, userID)
+}
+
+func (s *service) UpdateRoom(c context.Context, req *UpdateRoomRequest) (*Room, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r, _, err := s.managed(ctx, req.ID, req.UserID, RoleOwner, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != nil {
+		r.Name = *req.Name
+	}
+	if req.Topic != nil {
+		r.Topic = *req.Topic
+	}
+	if req.Visibility != nil {
+		if err := validateVisibility(*req.Visibility); err != nil {
+			return nil, err
+		}
+		r.Visibility = *req.Visibility
+	}
+
+	updated, err := s.Repository.UpdateRoom(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if s.listener != nil {
+		s.listener.RoomUpdated(updated)
+	}
+	return updated, nil
+}
+
+func (s *service) DeleteRoom(c context.Context, id string, userID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if _, _, err := s.managed(ctx, id, userID, RoleOwner); err != nil {
+		return err
+	}
+	if err := s.Repository.DeleteRoom(ctx, id); err != nil {
+		return err
+	}
+	if s.listener != nil {
+		s.listener.RoomDeleted(id)
+	}
+	return nil
+}
+
+func (s *service) ListConversations(c context.Context, userID int64) ([]*Conversation, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.Repository.ListConversations(ctx, userID)
+}
+
+func (s *service) ListMembers(c context.Context, roomID string, userID int64) ([]*Member, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r, err := s.Repository.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := CanRead(ctx, s.Repository, r, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return s.Repository.ListMembers(ctx, roomID)
+}
+
+// CreateInvite invites a user into a room. Owners and admins may invite
+// members, only owners may invite admins.
+func (s *service) CreateInvite(c context.Context, req *CreateInviteRequest) (*Invite, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r, inviter, err := s.managed(ctx, req.RoomID, req.InvitedBy, RoleOwner, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	role := req.Role
+	if role == "" {
+		role = RoleMember
+	}
+	if role == RoleAdmin && inviter.Role != RoleOwner {
+		return nil, ErrForbidden
+	}
+
+	if err := s.notBanned(ctx, r.ID, req.UserID); err != nil {
+		return nil, err
+	}
+	_, err = s.Repository.GetMember(ctx, r.ID, req.UserID)
+	if err == nil {
+		return nil, ErrAlreadyMember
+	}
+	if !errors.Is(err, ErrNotMember) {
+		return nil, err
+	}
+
+	invite, err := s.Repository.CreateInvite(ctx, &Invite{
+		RoomID:    r.ID,
+		UserID:    req.UserID,
+		InvitedBy: inviter.UserID,
+		Role:      role,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	invite.RoomName = r.Name
+	return invite, nil
+}
+
+func (s *service) ListInvites(c context.Context, userID int64) ([]*Invite, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.Repository.ListInvites(ctx, userID)
+}
+
+func (s *service) AcceptInvite(c context.Context, roomID string, userID int64) (*Member, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if err := s.notBanned(ctx, roomID, userID); err != nil {
+		return nil, err
+	}
+	return s.Repository.AcceptInvite(ctx, roomID, userID)
+}
+
+func (s *service) DeclineInvite(c context.Context, roomID string, userID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.Repository.DeleteInvite(ctx, roomID, userID)
+}
+
+// CreateInviteLink creates a link into the room. Like invitations, only
+// owners may hand out links that make admins.
+func (s *service) CreateInviteLink(c context.Context, req *CreateInviteLinkRequest) (*InviteLink, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r, creator, err := s.managed(ctx, req.RoomID, req.CreatedBy, RoleOwner, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	role := req.Role
+	if role == "" {
+		role = RoleMember
+	}
+	if role == RoleAdmin && creator.Role != RoleOwner {
+		return nil, ErrForbidden
+	}
+
+	code, err := newInviteLinkCode()
+	if err != nil {
+		return nil, err
+	}
+	return s.Repository.CreateInviteLink(ctx, &InviteLink{
+		Code:      code,
+		RoomID:    r.ID,
+		CreatedBy: creator.UserID,
+		Role:      role,
+		MaxUses:   req.MaxUses,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	})
+}
+
+func (s *service) ListInviteLinks(c context.Context, roomID string, userID int64) ([]*InviteLink, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if _, _, err := s.managed(ctx, roomID, userID, RoleOwner, RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.Repository.ListInviteLinks(ctx, roomID)
+}
+
+func (s *service) RevokeInviteLink(c context.Context, roomID, code string, userID int64) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if _, _, err := s.managed(ctx, roomID, userID, RoleOwner, RoleAdmin); err != nil {
+		return err
+	}
+	return s.Repository.DeleteInviteLink(ctx, roomID, code)
+}
+
+// AcceptInviteLink makes the user a member of the link's room. Members
+// following a link again keep their role and don't use it up.
+func (s *service) AcceptInviteLink(c context.Context, code string, userID int64) (*Member, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	link, err := s.Repository.GetInviteLink(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.notBanned(ctx, link.RoomID, userID); err != nil {
+		return nil, err
+	}
+	m, err := s.Repository.GetMember(ctx, link.RoomID, userID)
+	if err == nil {
+		return m, nil
+	}
+	if !errors.Is(err, ErrNotMember) {
+		return nil, err
+	}
+	if !link.Usable(time.Now()) {
+		return nil, ErrInviteLinkExpired
+	}
+	return s.Repository.UseInviteLink(ctx, code, userID)
+}
+
+// Moderate runs a moderation action, records it in the audit log and tells
+// the listener so connected clients follow. Owners and admins moderate,
+// owners can't be moderated and admins only by owners.
+func (s *service) Moderate(c context.Context, req *ModerateRequest) (*AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	r, actor, err := s.managed(ctx, req.RoomID, req.ActorID, RoleOwner, RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	entry := &AuditEntry{
+		RoomID:    r.ID,
+		Action:    req.Action,
+		ActorID:   actor.UserID,
+		ActorName: actor.Username,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return nil, ErrInvalidAction.Wrap(err)
+		}
+		// Round up so a short interval never turns into none
+		entry.Seconds = int64((d + time.Second - 1) / time.Second)
+	}
+
+	if req.Action != ActionSlowMode {
+		if req.UserID == actor.UserID {
+			return nil, ErrModerateSelf
+		}
+		target, err := s.Repository.GetParticipant(ctx, req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		m, err := s.Repository.GetMember(ctx, r.ID, target.ID)
+		if err != nil && !errors.Is(err, ErrNotMember) {
+			return nil, err
+		}
+		if m != nil && (m.Role == RoleOwner || m.Role == RoleAdmin && actor.Role != RoleOwner) {
+			return nil, ErrNotModeratable
+		}
+		entry.TargetID = target.ID
+		entry.TargetName = target.Username
+	}
+
+	switch req.Action {
+	case ActionKick:
+		// Kicks only disconnect, the listener does that
+	case ActionBan:
+		err = s.Repository.BanMember(ctx, &Ban{
+			RoomID:    r.ID,
+			UserID:    entry.TargetID,
+			BannedBy:  actor.UserID,
+			Reason:    req.Reason,
+			CreatedAt: entry.CreatedAt,
+		})
+	case ActionUnban:
+		err = s.Repository.DeleteBan(ctx, r.ID, entry.TargetID)
+	case ActionMute:
+		err = s.Repository.MuteMember(ctx, &Mute{
+			RoomID:     r.ID,
+			UserID:     entry.TargetID,
+			MutedBy:    actor.UserID,
+			MutedUntil: entry.CreatedAt.Add(entry.Duration()),
+		})
+	case ActionUnmute:
+		err = s.Repository.DeleteMute(ctx, r.ID, entry.TargetID)
+	case ActionSlowMode:
+		err = s.Repository.SetSlowMode(ctx, r.ID, int(entry.Seconds))
+	default:
+		return nil, ErrInvalidAction
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err = s.Repository.CreateAuditEntry(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if s.listener != nil {
+		s.listener.Moderated(entry)
+	}
+	return entry, nil
+}
+
+func (s *service) ListBans(c context.Context, roomID string, userID int64) ([]*Ban, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if _, _, err := s.managed(ctx, roomID, userID, RoleOwner, RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.Repository.ListBans(ctx, roomID)
+}
+
+// ListAuditLog returns the latest AuditLogSize entries, newest first.
+func (s *service) ListAuditLog(c context.Context, roomID string, userID int64) ([]*AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if _, _, err := s.managed(ctx, roomID, userID, RoleOwner, RoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.Repository.ListAuditLog(ctx, roomID, AuditLogSize)
+}
+
+// notBanned returns ErrBanned if the user is banned from the room.
+func (s *service) notBanned(ctx context.Context, roomID string, userID int64) error {
+	banned, err := s.Repository.IsBanned(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+	return nil
+}
+
+// managed loads a room and the membership of a user allowed to manage it,
+// roles lists the roles that may.
+func (s *service) managed(ctx context.Context, id string, userID int64, roles ...string) (*Room, *Member, error) {
+	r, err := s.Repository.GetRoomByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := s.Repository.GetMember(ctx, id, userID)
+	if errors.Is(err, ErrNotMember) {
+		if r.Visibility == VisibilityPrivate {
+			return nil, nil, ErrRoomNotFound
+		}
+		return nil, nil, ErrForbidden
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(roles, m.Role) {
+		return nil, nil, ErrForbidden
+	}
+	return r, m, nil
+}
+
+func validateVisibility(visibility string) error {
+	if visibility != VisibilityPublic && visibility != VisibilityPrivate {
+		return ErrInvalidRoom.Wrap(fmt.Errorf("unknown visibility %q", visibility))
+	}
+	return nil
+}
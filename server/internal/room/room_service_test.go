@@ -0,0 +1,222 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Users of the test rooms, by their role in them.
+const (
+	owner    int64 = 1
+	admin    int64 = 2
+	member   int64 = 3
+	outsider int64 = 4
+)
+
+// recorder is a Listener remembering what it was told.
+type recorder struct {
+	updated   []string
+	deleted   []string
+	moderated []*AuditEntry
+}
+
+func (r *recorder) RoomUpdated(room *Room)      { r.updated = append(r.updated, room.ID) }
+func (r *recorder) RoomDeleted(id string)       { r.deleted = append(r.deleted, id) }
+func (r *recorder) Moderated(entry *AuditEntry) { r.moderated = append(r.moderated, entry) }
+
+// newTestService returns a service with the public room "lobby" and the
+// private room "den", both with an owner, an admin and a member.
+func newTestService(t *testing.T) (Service, *memoryRepository, *recorder) {
+	t.Helper()
+	repo := newMemoryRepository(map[int64]string{owner: "olivia", admin: "adam", member: "mia", outsider: "oscar"})
+	listener := &recorder{}
+	s := NewService(repo, listener)
+	for _, req := range []*CreateRoomRequest{
+		{ID: "lobby", Name: "Lobby", OwnerID: owner},
+		{ID: "den", Name: "Den", Visibility: VisibilityPrivate, OwnerID: owner},
+	} {
+		if _, err := s.CreateRoom(context.Background(), req); err != nil {
+			t.Fatalf("CreateRoom(%s): %v", req.ID, err)
+		}
+		repo.addMember(req.ID, admin, RoleAdmin)
+		repo.addMember(req.ID, member, RoleMember)
+	}
+	return s, repo, listener
+}
+
+func TestCreateRoom(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	r, err := s.CreateRoom(ctx, &CreateRoomRequest{Name: "Garden", OwnerID: member})
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if r.ID == "" || r.Visibility != VisibilityPublic || r.Kind != KindRoom {
+		t.Errorf("CreateRoom = %+v, want a public room with a generated ID", r)
+	}
+	if m, err := repo.GetMember(ctx, r.ID, member); err != nil || m.Role != RoleOwner {
+		t.Errorf("creator's membership = %+v, %v, want owner", m, err)
+	}
+
+	if _, err := s.CreateRoom(ctx, &CreateRoomRequest{ID: "lobby", Name: "Lobby", OwnerID: member}); !errors.Is(err, ErrRoomExists) {
+		t.Errorf("CreateRoom with a taken ID = %v, want ErrRoomExists", err)
+	}
+	if _, err := s.CreateRoom(ctx, &CreateRoomRequest{Name: "Attic", Visibility: "secret", OwnerID: member}); !errors.Is(err, ErrInvalidRoom) {
+		t.Errorf("CreateRoom with an unknown visibility = %v, want ErrInvalidRoom", err)
+	}
+}
+
+func TestGetRoom(t *testing.T) {
+	s, _, _ := newTestService(t)
+	tests := []struct {
+		room    string
+		userID  int64
+		wantErr error
+	}{
+		{room: "lobby", userID: outsider},
+		{room: "den", userID: member},
+		// Private rooms don't exist for outsiders
+		{room: "den", userID: outsider, wantErr: ErrRoomNotFound},
+		{room: "attic", userID: owner, wantErr: ErrRoomNotFound},
+	}
+	for _, tt := range tests {
+		_, err := s.GetRoom(context.Background(), tt.room, tt.userID)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("GetRoom(%s) as user %d = %v, want %v", tt.room, tt.userID, err, tt.wantErr)
+		}
+		_, err = s.ListMembers(context.Background(), tt.room, tt.userID)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("ListMembers(%s) as user %d = %v, want %v", tt.room, tt.userID, err, tt.wantErr)
+		}
+	}
+}
+
+// Owners and admins manage a room, only owners delete it. Outsiders can't
+// tell a private room exists.
+func TestManageRoom(t *testing.T) {
+	tests := []struct {
+		room       string
+		userID     int64
+		wantUpdate error
+		wantDelete error
+	}{
+		{room: "lobby", userID: owner},
+		{room: "lobby", userID: admin, wantDelete: ErrForbidden},
+		{room: "lobby", userID: member, wantUpdate: ErrForbidden, wantDelete: ErrForbidden},
+		{room: "lobby", userID: outsider, wantUpdate: ErrForbidden, wantDelete: ErrForbidden},
+		{room: "den", userID: admin, wantDelete: ErrForbidden},
+		{room: "den", userID: member, wantUpdate: ErrForbidden, wantDelete: ErrForbidden},
+		{room: "den", userID: outsider, wantUpdate: ErrRoomNotFound, wantDelete: ErrRoomNotFound},
+		{room: "attic", userID: owner, wantUpdate: ErrRoomNotFound, wantDelete: ErrRoomNotFound},
+	}
+	for _, tt := range tests {
+		s, _, listener := newTestService(t)
+		ctx := context.Background()
+
+		topic := "updated"
+		_, err := s.UpdateRoom(ctx, &UpdateRoomRequest{ID: tt.room, Topic: &topic, UserID: tt.userID})
+		if !errors.Is(err, tt.wantUpdate) {
+			t.Errorf("UpdateRoom(%s) as user %d = %v, want %v", tt.room, tt.userID, err, tt.wantUpdate)
+		}
+		if updated := len(listener.updated) == 1; updated != (err == nil) {
+			t.Errorf("UpdateRoom(%s) as user %d told the listener %v", tt.room, tt.userID, listener.updated)
+		}
+
+		err = s.DeleteRoom(ctx, tt.room, tt.userID)
+		if !errors.Is(err, tt.wantDelete) {
+			t.Errorf("DeleteRoom(%s) as user %d = %v, want %v", tt.room, tt.userID, err, tt.wantDelete)
+		}
+		if deleted := len(listener.deleted) == 1; deleted != (err == nil) {
+			t.Errorf("DeleteRoom(%s) as user %d told the listener %v", tt.room, tt.userID, listener.deleted)
+		}
+	}
+}
+
+func TestCreateInvite(t *testing.T) {
+	tests := []struct {
+		name      string
+		room      string
+		invitedBy int64
+		userID    int64
+		role      string
+		wantErr   error
+	}{
+		{name: "admin invites member", room: "den", invitedBy: admin, userID: outsider},
+		{name: "owner invites admin", room: "den", invitedBy: owner, userID: outsider, role: RoleAdmin},
+		{name: "admin invites admin", room: "den", invitedBy: admin, userID: outsider, role: RoleAdmin, wantErr: ErrForbidden},
+		{name: "member invites", room: "lobby", invitedBy: member, userID: outsider, wantErr: ErrForbidden},
+		{name: "outsider invites to private room", room: "den", invitedBy: outsider, userID: outsider, wantErr: ErrRoomNotFound},
+		{name: "invite a member", room: "den", invitedBy: owner, userID: member, wantErr: ErrAlreadyMember},
+		{name: "invite an unknown user", room: "den", invitedBy: owner, userID: 99, wantErr: ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestService(t)
+			invite, err := s.CreateInvite(context.Background(), &CreateInviteRequest{
+				RoomID: tt.room, UserID: tt.userID, Role: tt.role, InvitedBy: tt.invitedBy,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateInvite = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			wantRole := tt.role
+			if wantRole == "" {
+				wantRole = RoleMember
+			}
+			if invite.Role != wantRole || invite.InvitedBy != tt.invitedBy || invite.RoomName != "Den" {
+				t.Errorf("CreateInvite = %+v, want a %s invite by %d into Den", invite, wantRole, tt.invitedBy)
+			}
+		})
+	}
+}
+
+func TestAcceptInvite(t *testing.T) {
+	s, repo, _ := newTestService(t)
+	ctx := context.Background()
+
+	req := &CreateInviteRequest{RoomID: "den", UserID: outsider, Role: RoleAdmin, InvitedBy: owner}
+	if _, err := s.CreateInvite(ctx, req); err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	if _, err := s.CreateInvite(ctx, req); !errors.Is(err, ErrInviteExists) {
+		t.Errorf("second CreateInvite = %v, want ErrInviteExists", err)
+	}
+
+	m, err := s.AcceptInvite(ctx, "den", outsider)
+	if err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	if m.Role != RoleAdmin || m.Username != "oscar" {
+		t.Errorf("AcceptInvite = %+v, want oscar as admin", m)
+	}
+	if _, err := s.AcceptInvite(ctx, "den", outsider); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("second AcceptInvite = %v, want ErrInviteNotFound", err)
+	}
+	if _, err := s.GetRoom(ctx, "den", outsider); err != nil {
+		t.Errorf("GetRoom after joining: %v", err)
+	}
+
+	if err := s.DeclineInvite(ctx, "lobby", outsider); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("DeclineInvite without an invite = %v, want ErrInviteNotFound", err)
+	}
+
+	// Banned users can't be invited nor accept an earlier invite
+	if _, err := s.CreateInvite(ctx, &CreateInviteRequest{RoomID: "lobby", UserID: outsider, InvitedBy: owner}); err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+	repo.bans["lobby"] = map[int64]*Ban{outsider: {RoomID: "lobby", UserID: outsider, CreatedAt: time.Now()}}
+	if _, err := s.AcceptInvite(ctx, "lobby", outsider); !errors.Is(err, ErrBanned) {
+		t.Errorf("AcceptInvite while banned = %v, want ErrBanned", err)
+	}
+	if err := s.DeclineInvite(ctx, "lobby", outsider); err != nil {
+		t.Fatalf("DeclineInvite: %v", err)
+	}
+	if _, err := s.CreateInvite(ctx, &CreateInviteRequest{RoomID: "lobby", UserID: outsider, InvitedBy: owner}); !errors.Is(err, ErrBanned) {
+		t.Errorf("CreateInvite of a banned user = %v, want ErrBanned", err)
+	}
+}
@@ -0,0 +1,102 @@
+package room
+
+import (
+	"server/internal/validate"
+	"strings"
+	"time"
+)
+
+var (
+	// Room IDs end up in URLs, so they are kept to a URL-safe slug
+	idRules = []validate.Rule{
+		validate.MinLength(3),
+		validate.MaxLength(64),
+		validate.Charset("lowercase letters, digits, '-' and '_'", validate.Slug),
+	}
+	nameRules = []validate.Rule{
+		validate.Required(),
+		validate.MaxLength(100),
+	}
+	topicRules = []validate.Rule{
+		validate.MaxLength(500),
+	}
+	visibilityRules = []validate.Rule{
+		validate.OneOf(VisibilityPublic, VisibilityPrivate),
+	}
+	reasonRules = []validate.Rule{
+		validate.MaxLength(500),
+	}
+	// Owners are only made by creating a room
+	inviteRoleRules = []validate.Rule{
+		validate.OneOf(RoleAdmin, RoleMember),
+	}
+)
+
+// Validate normalizes the request in place and checks every field. An empty
+// ID or visibility is filled in by the service.
+func (req *CreateRoomRequest) Validate() error {
+	req.ID = strings.TrimSpace(req.ID)
+	req.Name = strings.TrimSpace(req.Name)
+	req.Topic = strings.TrimSpace(req.Topic)
+
+	var v validate.Validator
+	v.Optional("id", req.ID, idRules...)
+	v.Field("name", req.Name, nameRules...)
+	v.Field("topic", req.Topic, topicRules...)
+	v.Optional("visibility", req.Visibility, visibilityRules...)
+	return v.Err()
+}
+
+// Validate only checks the fields present in the request.
+func (req *UpdateRoomRequest) Validate() error {
+	var v validate.Validator
+	if req.Name != nil {
+		*req.Name = strings.TrimSpace(*req.Name)
+		v.Field("name", *req.Name, nameRules...)
+	}
+	if req.Topic != nil {
+		*req.Topic = strings.TrimSpace(*req.Topic)
+		v.Field("topic", *req.Topic, topicRules...)
+	}
+	if req.Visibility != nil {
+		v.Field("visibility", *req.Visibility, visibilityRules...)
+	}
+	return v.Err()
+}
+
+// Validate checks the invitee and the role, an empty role means member.
+func (req *CreateInviteRequest) Validate() error {
+	var v validate.Validator
+	v.Check("user_id", req.UserID > 0, "is required")
+	v.Optional("role", req.Role, inviteRoleRules...)
+	return v.Err()
+}
+
+// Validate checks the limits of the link, an empty role means member.
+func (req *CreateInviteLinkRequest) Validate() error {
+	var v validate.Validator
+	v.Optional("role", req.Role, inviteRoleRules...)
+	v.Check("max_uses", req.MaxUses >= 0, "can't be negative")
+	v.Check("expires_at", req.ExpiresAt == nil || req.ExpiresAt.After(time.Now()), "must be in the future")
+	return v.Err()
+}
+
+// Validate checks the fields the action needs. The action itself is
+// checked by the service.
+func (req *ModerateRequest) Validate() error {
+	req.Reason = strings.TrimSpace(req.Reason)
+	req.Duration = strings.TrimSpace(req.Duration)
+
+	var v validate.Validator
+	if req.Action != ActionSlowMode {
+		v.Check("user_id", req.UserID > 0, "is required")
+	}
+	switch req.Action {
+	case ActionMute:
+		v.Field("duration", req.Duration, validate.Required(), validate.Duration(time.Second))
+	case ActionSlowMode:
+		v.Field("duration", req.Duration, validate.Required(), validate.Duration(0))
+	}
+	v.Field("reason", req.Reason, reasonRules...)
+	return v.Err()
+}
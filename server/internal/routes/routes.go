@@ -1,21 +1,93 @@
 package routes
 
 import (
+	"net/http"
+	"server/internal/auth"
+	"server/internal/message"
+	"server/internal/room"
+	"server/internal/token"
 	"server/internal/user"
 	"server/internal/websocket"
 
 	"github.com/go-chi/chi/v5"
 )
 
-func InitRouter(userHandler *user.Handler, websocketHandler *websocket.Handler) *chi.Mux {
+// InitRouter mounts every endpoint. The room, message and websocket
+// handlers are nil when chat is off, and their endpoints left out.
+func InitRouter(jwtMaker *token.JWTMaker, adminKey string, requireVerified bool, tokenHandler *token.Handler, userHandler *user.Handler, roomHandler *room.Handler, messageHandler *message.Handler, websocketHandler *websocket.Handler) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Post("/signup", userHandler.CreateUser)
 	r.Post("/login", userHandler.Login)
 	r.Post("/refresh", userHandler.RefreshToken)
 	r.Post("/logout", userHandler.Logout)
+	r.Post("/verify-email", userHandler.VerifyEmail)
+	r.Post("/verify-email/resend", userHandler.ResendVerification)
+	r.Post("/password/forgot", userHandler.ForgotPassword)
+	r.Post("/password/reset", userHandler.ResetPassword)
+	r.Get("/.well-known/jwks.json", tokenHandler.JWKS)
 
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AdminKey(adminKey))
 
-	r.Post("/websocket/createRoom", websocketHandler.CreateRoom)
+		r.Post("/admin/accounts/unlock", userHandler.UnlockAccount)
+		if websocketHandler != nil {
+			r.Get("/admin/websocket/stats", websocketHandler.Stats)
+		}
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(jwtMaker))
+
+		r.Post("/me/password", userHandler.ChangePassword)
+		r.Get("/me/sessions", userHandler.ListSessions)
+		r.Delete("/me/sessions/{id}", userHandler.RevokeSession)
+		r.Post("/me/sessions/revoke-all", userHandler.RevokeOtherSessions)
+	})
+	if roomHandler == nil {
+		return r
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(jwtMaker))
+
+		r.Get("/me/conversations", roomHandler.ListConversations)
+		r.Get("/me/invites", roomHandler.ListInvites)
+
+		r.Get("/rooms", roomHandler.ListRooms)
+		r.Post("/rooms", roomHandler.CreateRoom)
+		r.Get("/rooms/{id}", roomHandler.GetRoom)
+		r.Patch("/rooms/{id}", roomHandler.UpdateRoom)
+		r.Delete("/rooms/{id}", roomHandler.DeleteRoom)
+		r.Get("/rooms/{id}/members", roomHandler.ListMembers)
+		r.Post("/rooms/{id}/invites", roomHandler.CreateInvite)
+		r.Post("/rooms/{id}/invites/accept", roomHandler.AcceptInvite)
+		r.Post("/rooms/{id}/invites/decline", roomHandler.DeclineInvite)
+		r.Get("/rooms/{id}/invite-links", roomHandler.ListInviteLinks)
+		r.Post("/rooms/{id}/invite-links", roomHandler.CreateInviteLink)
+		r.Delete("/rooms/{id}/invite-links/{code}", roomHandler.RevokeInviteLink)
+		r.Post("/invites/{code}/accept", roomHandler.AcceptInviteLink)
+		r.Post("/rooms/{id}/kick", roomHandler.Kick)
+		r.Get("/rooms/{id}/bans", roomHandler.ListBans)
+		r.Post("/rooms/{id}/bans", roomHandler.Ban)
+		r.Delete("/rooms/{id}/bans/{userId}", roomHandler.Unban)
+		r.Post("/rooms/{id}/mutes", roomHandler.Mute)
+		r.Delete("/rooms/{id}/mutes/{userId}", roomHandler.Unmute)
+		r.Put("/rooms/{id}/slow-mode", roomHandler.SetSlowMode)
+		r.Get("/rooms/{id}/audit-log", roomHandler.ListAuditLog)
+		r.Get("/rooms/{roomId}/messages", messageHandler.GetMessages)
+
+		r.Post("/websocket/createRoom", roomHandler.CreateRoom)
+		r.With(verified(requireVerified)).Get("/websocket/joinRoom/{roomId}", websocketHandler.JoinRoom)
+		r.With(verified(requireVerified)).Get("/websocket/direct/{userId}", websocketHandler.JoinDirect)
+	})
 	return r
 }
+
+// verified only lets users with a verified email through when required.
+func verified(required bool) func(http.Handler) http.Handler {
+	if !required {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return auth.RequireVerified
+}
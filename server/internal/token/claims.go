@@ -9,24 +9,28 @@ import (
 )
 
 type UserClaims struct {
-	ID    int    `json:"id"`
-	Email string `json:"email"`
+	ID       int    `json:"id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Verified bool   `json:"verified"`
 	jwt.RegisteredClaims
 }
 
-func NewUserClaims(id int, email string, duration time.Duration)(*UserClaims, error){
+func NewUserClaims(id int, email, username string, verified bool, duration time.Duration) (*UserClaims, error) {
 	tokenID, err := uuid.NewRandom()
 	if err != nil {
 		return nil, fmt.Errorf("error generating token ID: %w", err)
 	}
 	return &UserClaims{
-		Email: email,
-		ID: id,
+		Email:    email,
+		ID:       id,
+		Username: username,
+		Verified: verified,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ID: tokenID.String(),
-			Subject: email,
-			IssuedAt: jwt.NewNumericDate(time.Now()),
+			ID:        tokenID.String(),
+			Subject:   email,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
 		},
 	}, nil
-}
\ No newline at end of file
+}
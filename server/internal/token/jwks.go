@@ -0,0 +1,76 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+// JWK is the public half of a signing key as described by RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys that verify tokens. HMAC keys are secret and
+// are left out.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys() {
+		switch public := key.verifyKey.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm(),
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		case *ecdsa.PublicKey:
+			ecdh, err := public.ECDH()
+			if err != nil {
+				continue
+			}
+			// Uncompressed point: 0x04 || X || Y
+			point := ecdh.Bytes()
+			size := (len(point) - 1) / 2
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "EC",
+				KeyID:     key.ID,
+				Algorithm: key.Algorithm(),
+				Use:       "sig",
+				Curve:     public.Curve.Params().Name,
+				X:         base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+				Y:         base64.RawURLEncoding.EncodeToString(point[1+size:]),
+			})
+		}
+	}
+	return set
+}
+
+type Handler struct {
+	keys *KeySet
+}
+
+func NewHandler(keys *KeySet) *Handler {
+	return &Handler{keys: keys}
+}
+
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.keys.JWKS())
+}
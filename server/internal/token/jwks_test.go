@@ -0,0 +1,81 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", s, err)
+	}
+	return b
+}
+
+func TestJWKS(t *testing.T) {
+	edKey, ecKey, hmacKey := generateKey(t, AlgorithmEdDSA), generateKey(t, AlgorithmES256), generateKey(t, AlgorithmHS256)
+	ks := NewKeySet(hmacKey, time.Hour)
+	ks.Use([]*Key{edKey, ecKey, hmacKey})
+
+	set := ks.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want the EdDSA and ES256 ones without the HMAC secret", len(set.Keys))
+	}
+	for _, jwk := range set.Keys {
+		switch jwk.KeyID {
+		case edKey.ID:
+			want := edKey.verifyKey.(ed25519.PublicKey)
+			if jwk.KeyType != "OKP" || jwk.Algorithm != AlgorithmEdDSA || jwk.Curve != "Ed25519" || jwk.Use != "sig" || jwk.Y != "" {
+				t.Errorf("EdDSA JWK = %+v", jwk)
+			}
+			if x := decode(t, jwk.X); !want.Equal(ed25519.PublicKey(x)) {
+				t.Errorf("EdDSA JWK x = %x, want %x", x, want)
+			}
+		case ecKey.ID:
+			public := ecKey.verifyKey.(*ecdsa.PublicKey)
+			if jwk.KeyType != "EC" || jwk.Algorithm != AlgorithmES256 || jwk.Curve != "P-256" || jwk.Use != "sig" {
+				t.Errorf("ES256 JWK = %+v", jwk)
+			}
+			ecdh, err := public.ECDH()
+			if err != nil {
+				t.Fatal(err)
+			}
+			x, y := decode(t, jwk.X), decode(t, jwk.Y)
+			point := append(append([]byte{4}, x...), y...)
+			if len(x) != 32 || len(y) != 32 || string(point) != string(ecdh.Bytes()) {
+				t.Errorf("ES256 JWK point = %x, want %x", point, ecdh.Bytes())
+			}
+		default:
+			t.Errorf("unexpected JWK %+v", jwk)
+		}
+	}
+}
+
+func TestJWKSHandler(t *testing.T) {
+	key := generateKey(t, AlgorithmEdDSA)
+	rec := httptest.NewRecorder()
+	NewHandler(NewKeySet(key, time.Hour)).JWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" || rec.Header().Get("Cache-Control") == "" {
+		t.Errorf("got %d with %v, want a cacheable JSON 200", rec.Code, rec.Header())
+	}
+	var set JWKS
+	if err := json.NewDecoder(rec.Body).Decode(&set); err != nil || len(set.Keys) != 1 || set.Keys[0].KeyID != key.ID {
+		t.Errorf("body = %+v, %v, want the one key", set, err)
+	}
+
+	// Only HMAC keys leaves an empty list, not null
+	rec = httptest.NewRecorder()
+	NewHandler(NewKeySet(generateKey(t, AlgorithmHS256), time.Hour)).JWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if body := rec.Body.String(); body != "{\"keys\":[]}\n" {
+		t.Errorf("body = %q, want an empty key list", body)
+	}
+}
@@ -8,21 +8,31 @@ import (
 )
 
 type JWTMaker struct {
-	secretKey string
+	keys *KeySet
 }
 
-
+// NewJwtMaker signs with a single shared HS256 secret.
 func NewJwtMaker(secretKey string) *JWTMaker {
-	return &JWTMaker{secretKey: secretKey}
+	return NewJwtMakerWithKeys(NewKeySet(NewHMACKey([]byte(secretKey)), 0))
+}
+
+func NewJwtMakerWithKeys(keys *KeySet) *JWTMaker {
+	return &JWTMaker{keys: keys}
+}
+
+func (maker *JWTMaker) Keys() *KeySet {
+	return maker.keys
 }
 
-func (maker *JWTMaker) CreateToken(id int, email string, duration time.Duration) (string, *UserClaims, error){
-	claims, err := NewUserClaims(id, email, duration)
+func (maker *JWTMaker) CreateToken(id int, email, username string, verified bool, duration time.Duration) (string, *UserClaims, error) {
+	claims, err := NewUserClaims(id, email, username, verified, duration)
 	if err != nil {
 		return "", nil, err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenStr, err := token.SignedString([]byte(maker.secretKey))
+	key := maker.keys.signingKey()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	tokenStr, err := token.SignedString(key.signKey)
 	if err != nil {
 		return "", nil, fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -30,14 +40,18 @@ func (maker *JWTMaker) CreateToken(id int, email string, duration time.Duration)
 }
 
 func (maker *JWTMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, *&UserClaims{}, func(t *jwt.Token) (interface{}, error) {
-		//Verify the signing method
-		_, ok := t.Method.(*jwt.SigningMethodHMAC)
+	token, err := jwt.ParseWithClaims(tokenStr, &UserClaims{}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := maker.keys.verificationKey(kid)
 		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		//Verify the signing method
+		if t.Method.Alg() != key.Algorithm() {
 			return nil, fmt.Errorf("invalid token signing method")
 		}
-		return []byte(maker.secretKey), nil
-	})
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmEdDSA, AlgorithmES256}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
@@ -47,4 +61,4 @@ func (maker *JWTMaker) VerifyToken(tokenStr string) (*UserClaims, error) {
 	}
 
 	return claims, nil
-}
\ No newline at end of file
+}
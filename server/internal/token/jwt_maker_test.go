@@ -0,0 +1,106 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func createToken(t *testing.T, maker *JWTMaker) string {
+	t.Helper()
+	tokenStr, _, err := maker.CreateToken(7, "ada@example.com", "ada", true, time.Minute)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	return tokenStr
+}
+
+// sign signs the claims of a token with method and key, under kid.
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+	claims, err := NewUserClaims(7, "ada@example.com", "ada", true, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	tokenStr, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing a %s token: %v", method.Alg(), err)
+	}
+	return tokenStr
+}
+
+func TestVerifyToken(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmHS256, AlgorithmEdDSA, AlgorithmES256} {
+		maker := NewJwtMakerWithKeys(NewKeySet(generateKey(t, algorithm), time.Hour))
+		claims, err := maker.VerifyToken(createToken(t, maker))
+		if err != nil {
+			t.Errorf("%s: VerifyToken: %v", algorithm, err)
+			continue
+		}
+		if claims.ID != 7 || claims.Username != "ada" || !claims.Verified {
+			t.Errorf("%s: VerifyToken = %+v, want the claims of ada", algorithm, claims)
+		}
+	}
+}
+
+func TestVerifyTokenAfterRotation(t *testing.T) {
+	old, next := generateKey(t, AlgorithmEdDSA), generateKey(t, AlgorithmEdDSA)
+	maker := NewJwtMakerWithKeys(NewKeySet(old, time.Hour))
+	before := createToken(t, maker)
+
+	maker.Keys().Rotate(next)
+	after := createToken(t, maker)
+	for name, tokenStr := range map[string]string{"retired key": before, "new key": after} {
+		if _, err := maker.VerifyToken(tokenStr); err != nil {
+			t.Errorf("VerifyToken of a token signed with the %s: %v", name, err)
+		}
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(after, &UserClaims{})
+	if err != nil || parsed.Header["kid"] != next.ID {
+		t.Errorf("new token has kid %v, want %s", parsed.Header["kid"], next.ID)
+	}
+
+	// Once retired keys expire their tokens stop verifying
+	expiring := NewJwtMakerWithKeys(NewKeySet(old, time.Millisecond))
+	tokenStr := createToken(t, expiring)
+	expiring.Keys().Rotate(next)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := expiring.VerifyToken(tokenStr); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Errorf("VerifyToken with an expired key = %v, want an unknown key error", err)
+	}
+}
+
+func TestVerifyTokenRejected(t *testing.T) {
+	edKey := generateKey(t, AlgorithmEdDSA)
+	maker := NewJwtMakerWithKeys(NewKeySet(edKey, time.Hour))
+	stranger := NewJwtMakerWithKeys(NewKeySet(generateKey(t, AlgorithmEdDSA), time.Hour))
+	public := edKey.verifyKey.(ed25519.PublicKey)
+
+	valid := createToken(t, maker)
+	parts := strings.Split(valid, ".")
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", createToken(t, stranger)},
+		{"no kid", sign(t, jwt.SigningMethodEdDSA, "", edKey.signKey)},
+		// The public key used as an HMAC secret, the classic algorithm
+		// confusion attack
+		{"HS256 under an EdDSA kid", sign(t, jwt.SigningMethodHS256, edKey.ID, []byte(public))},
+		{"HS384 under an EdDSA kid", sign(t, jwt.SigningMethodHS384, edKey.ID, []byte(public))},
+		{"unsigned", sign(t, jwt.SigningMethodNone, edKey.ID, jwt.UnsafeAllowNoneSignatureType)},
+		{"tampered claims", parts[0] + "." + strings.TrimRight(parts[1], "=") + "x." + parts[2]},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + parts[2][:len(parts[2])-4] + "AAAA"},
+		{"garbage", "not.a.token"},
+	}
+	for _, tt := range tests {
+		if claims, err := maker.VerifyToken(tt.token); err == nil {
+			t.Errorf("%s: VerifyToken = %+v, want an error", tt.name, claims)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmES256 = "ES256"
+)
+
+// Key is one signing key, identified in token headers by its kid.
+type Key struct {
+	ID        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// NewHMACKey wraps a shared secret. HMAC keys never appear in the JWKS.
+func NewHMACKey(secret []byte) *Key {
+	return &Key{
+		ID:        keyID(AlgorithmHS256, secret),
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+func NewEd25519Key(private ed25519.PrivateKey) *Key {
+	public := private.Public().(ed25519.PublicKey)
+	return &Key{
+		ID:        keyID(AlgorithmEdDSA, public),
+		method:    jwt.SigningMethodEdDSA,
+		signKey:   private,
+		verifyKey: public,
+	}
+}
+
+func NewES256Key(private *ecdsa.PrivateKey) (*Key, error) {
+	if private.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("ES256 requires a P-256 key")
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	return &Key{
+		ID:        keyID(AlgorithmES256, public),
+		method:    jwt.SigningMethodES256,
+		signKey:   private,
+		verifyKey: &private.PublicKey,
+	}, nil
+}
+
+// GenerateKey creates a fresh random key for the algorithm.
+func GenerateKey(algorithm string) (*Key, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return NewHMACKey(secret), nil
+	case AlgorithmEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return NewEd25519Key(private), nil
+	case AlgorithmES256:
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		return NewES256Key(private)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// ParsePrivateKeyPEM reads a PKCS#8 Ed25519 or P-256 private key.
+func ParsePrivateKeyPEM(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	return parsePrivateKeyBlock(block)
+}
+
+// ParsePrivateKeysPEM reads every private key of a PEM file, in order.
+func ParsePrivateKeysPEM(data []byte) ([]*Key, error) {
+	var keys []*Key
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := parsePrivateKeyBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	return keys, nil
+}
+
+// LoadKeyFile reads the keys of a PEM file that must all be for algorithm.
+// The first one signs tokens, the others only verify them, so a key can be
+// rotated in every replica sharing the file without logging anybody out.
+func LoadKeyFile(path, algorithm string) ([]*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParsePrivateKeysPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, key := range keys {
+		if key.Algorithm() != algorithm {
+			return nil, fmt.Errorf("%s holds a %s key, not %s", path, key.Algorithm(), algorithm)
+		}
+	}
+	return keys, nil
+}
+
+func parsePrivateKeyBlock(block *pem.Block) (*Key, error) {
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		// openssl writes P-256 keys in SEC 1 form by default
+		ecKey, ecErr := x509.ParseECPrivateKey(block.Bytes)
+		if ecErr != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		private = ecKey
+	}
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		return NewEd25519Key(private), nil
+	case *ecdsa.PrivateKey:
+		return NewES256Key(private)
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", private)
+	}
+}
+
+// keyID derives a stable kid from the key material so every replica
+// configured with the same key agrees on it.
+func keyID(algorithm string, material []byte) string {
+	sum := sha256.Sum256(append([]byte(algorithm+":"), material...))
+	return hex.EncodeToString(sum[:8])
+}
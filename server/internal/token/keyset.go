@@ -0,0 +1,129 @@
+package token
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// KeySet holds the key tokens are signed with plus the keys it replaced.
+// Replaced keys keep verifying tokens for verifyFor, which should be at least
+// the lifetime of the tokens they signed.
+type KeySet struct {
+	mu        sync.RWMutex
+	active    *Key
+	retired   map[string]retiredKey
+	verifyFor time.Duration
+}
+
+type retiredKey struct {
+	key       *Key
+	expiresAt time.Time
+}
+
+func NewKeySet(active *Key, verifyFor time.Duration) *KeySet {
+	return &KeySet{
+		active:    active,
+		retired:   make(map[string]retiredKey),
+		verifyFor: verifyFor,
+	}
+}
+
+// Rotate makes next the signing key. The previous key still verifies tokens
+// until they have all expired.
+func (ks *KeySet) Rotate(next *Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.rotate(next, time.Now())
+}
+
+// Use makes the first key the signing key and lets the others verify
+// tokens, see LoadKeyFile. Keys dropped from the list keep verifying tokens
+// for verifyFor like rotated ones.
+func (ks *KeySet) Use(keys []*Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := time.Now()
+	ks.rotate(keys[0], now)
+	for _, key := range keys[1:] {
+		if key.ID != ks.active.ID {
+			ks.retired[key.ID] = retiredKey{key: key, expiresAt: now.Add(ks.verifyFor)}
+		}
+	}
+}
+
+func (ks *KeySet) rotate(next *Key, now time.Time) {
+	for id, r := range ks.retired {
+		if now.After(r.expiresAt) {
+			delete(ks.retired, id)
+		}
+	}
+	if ks.active.ID != next.ID {
+		ks.retired[ks.active.ID] = retiredKey{key: ks.active, expiresAt: now.Add(ks.verifyFor)}
+	}
+	delete(ks.retired, next.ID)
+	ks.active = next
+}
+
+// RunRotation reloads the keys with load every interval until the context
+// is cancelled. Keys are rotated by changing what load reads, such as a key
+// file every replica shares, so all of them sign with the same key.
+func (ks *KeySet) RunRotation(ctx context.Context, interval time.Duration, load func() ([]*Key, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			keys, err := load()
+			if err != nil {
+				log.Printf("error reloading signing keys: %v", err)
+				continue
+			}
+			previous := ks.signingKey().ID
+			ks.Use(keys)
+			if keys[0].ID != previous {
+				log.Printf("rotated signing key to %s", keys[0].ID)
+			}
+		}
+	}
+}
+
+func (ks *KeySet) signingKey() *Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.active
+}
+
+func (ks *KeySet) verificationKey(id string) (*Key, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if ks.active.ID == id {
+		return ks.active, true
+	}
+	r, ok := ks.retired[id]
+	if !ok || time.Now().After(r.expiresAt) {
+		return nil, false
+	}
+	return r.key, true
+}
+
+// keys returns every key that currently verifies tokens.
+func (ks *KeySet) keys() []*Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now()
+	keys := []*Key{ks.active}
+	for _, r := range ks.retired {
+		if now.Before(r.expiresAt) {
+			keys = append(keys, r.key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,84 @@
+package token
+
+import (
+	"testing"
+	"time"
+)
+
+func generateKey(t *testing.T, algorithm string) *Key {
+	t.Helper()
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		t.Fatalf("GenerateKey(%s): %v", algorithm, err)
+	}
+	return key
+}
+
+// verifies reports which of the keys the set verifies tokens with.
+func verifies(ks *KeySet, keys ...*Key) []bool {
+	var got []bool
+	for _, key := range keys {
+		_, ok := ks.verificationKey(key.ID)
+		got = append(got, ok)
+	}
+	return got
+}
+
+func TestKeySetRotate(t *testing.T) {
+	first, second, third := generateKey(t, AlgorithmEdDSA), generateKey(t, AlgorithmEdDSA), generateKey(t, AlgorithmEdDSA)
+	ks := NewKeySet(first, time.Hour)
+
+	ks.Rotate(second)
+	if ks.signingKey() != second {
+		t.Errorf("signing with %s, want %s", ks.signingKey().ID, second.ID)
+	}
+	if got := verifies(ks, first, second, third); !got[0] || !got[1] || got[2] {
+		t.Errorf("verifies first, second, third = %v, want the first two", got)
+	}
+
+	// Rotating back makes a retired key active again
+	ks.Rotate(first)
+	if ks.signingKey() != first || len(ks.keys()) != 2 {
+		t.Errorf("signing with %s and %d keys, want %s and 2", ks.signingKey().ID, len(ks.keys()), first.ID)
+	}
+}
+
+func TestKeySetRetiredKeyExpires(t *testing.T) {
+	first, second := generateKey(t, AlgorithmEdDSA), generateKey(t, AlgorithmEdDSA)
+	ks := NewKeySet(first, time.Millisecond)
+
+	ks.Rotate(second)
+	time.Sleep(5 * time.Millisecond)
+	if got := verifies(ks, first, second); got[0] || !got[1] {
+		t.Errorf("verifies first, second = %v, want only the second once the first expired", got)
+	}
+	if keys := ks.keys(); len(keys) != 1 || keys[0] != second {
+		t.Errorf("keys = %d keys, want only the second", len(keys))
+	}
+}
+
+func TestKeySetUse(t *testing.T) {
+	first, second, third := generateKey(t, AlgorithmES256), generateKey(t, AlgorithmES256), generateKey(t, AlgorithmES256)
+	ks := NewKeySet(first, time.Hour)
+
+	// The key file lists the next key first and keeps the old one
+	ks.Use([]*Key{second, first})
+	if ks.signingKey() != second {
+		t.Errorf("signing with %s, want %s", ks.signingKey().ID, second.ID)
+	}
+	if got := verifies(ks, first, second); !got[0] || !got[1] {
+		t.Errorf("verifies first, second = %v, want both", got)
+	}
+
+	// Keys dropped from the file keep verifying for a while
+	ks.Use([]*Key{third})
+	if got := verifies(ks, first, second, third); !got[0] || !got[1] || !got[2] {
+		t.Errorf("verifies first, second, third = %v, want all of them", got)
+	}
+
+	// Loading the same keys again changes nothing
+	ks.Use([]*Key{third})
+	if ks.signingKey() != third || len(ks.keys()) != 3 {
+		t.Errorf("signing with %s and %d keys, want %s and 3", ks.signingKey().ID, len(ks.keys()), third.ID)
+	}
+}
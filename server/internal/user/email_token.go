@@ -0,0 +1,56 @@
+package user
+
+import (
+	"server/internal/apperr"
+	"server/internal/utils"
+	"time"
+)
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+)
+
+var ErrInvalidEmailToken = apperr.New(apperr.Validation, "invalid_token", "invalid or expired token")
+
+// EmailToken is a single-use token mailed to a user. Only its ID is stored,
+// clients get the ID signed with the service secret.
+type EmailToken struct {
+	ID        string     `db:"id"`
+	Email     string     `db:"email"`
+	Purpose   string     `db:"purpose"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+func newEmailToken(email, purpose string, ttl time.Duration) (*EmailToken, error) {
+	id, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &EmailToken{
+		ID:        id,
+		Email:     email,
+		Purpose:   purpose,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// VerificationPolicy decides what accounts with an unverified email may do.
+type VerificationPolicy string
+
+const (
+	// VerificationOff lets unverified accounts do everything.
+	VerificationOff VerificationPolicy = "off"
+	// VerificationBeforeJoin lets unverified accounts log in but not join rooms.
+	VerificationBeforeJoin VerificationPolicy = "join"
+	// VerificationBeforeLogin refuses to log unverified accounts in.
+	VerificationBeforeLogin VerificationPolicy = "login"
+)
+
+func (p VerificationPolicy) Valid() bool {
+	return p == VerificationOff || p == VerificationBeforeJoin || p == VerificationBeforeLogin
+}
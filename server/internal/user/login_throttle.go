@@ -0,0 +1,113 @@
+package user
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	ThrottleScopeAccount = "account"
+	ThrottleScopeIP      = "ip"
+	// The mail scopes count emails asked for rather than failed logins
+	ThrottleScopeMailAccount = "mail_account"
+	ThrottleScopeMailIP      = "mail_ip"
+)
+
+// LoginAttempts counts recent failed logins for an account or an IP.
+type LoginAttempts struct {
+	Scope         string    `db:"scope"`
+	Key           string    `db:"key"`
+	Failures      int       `db:"failures"`
+	LastFailureAt time.Time `db:"last_failure_at"`
+}
+
+// ThrottlePolicy slows down repeated failed logins. The first FreeAttempts
+// failures cost nothing, after that every failure doubles the wait starting
+// at BaseDelay up to MaxDelay, and LockoutThreshold failures lock the key
+// for LockoutDuration. Counters reset after ResetAfter without failures.
+type ThrottlePolicy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+	ResetAfter       time.Duration
+}
+
+type LoginThrottle struct {
+	Account ThrottlePolicy
+	IP      ThrottlePolicy
+}
+
+func DefaultLoginThrottle() LoginThrottle {
+	return LoginThrottle{
+		Account: ThrottlePolicy{
+			FreeAttempts:     3,
+			BaseDelay:        time.Second,
+			MaxDelay:         5 * time.Minute,
+			LockoutThreshold: 10,
+			LockoutDuration:  30 * time.Minute,
+			ResetAfter:       time.Hour,
+		},
+		// One address may be shared by many legitimate users
+		IP: ThrottlePolicy{
+			FreeAttempts:     20,
+			BaseDelay:        time.Second,
+			MaxDelay:         5 * time.Minute,
+			LockoutThreshold: 100,
+			LockoutDuration:  30 * time.Minute,
+			ResetAfter:       time.Hour,
+		},
+	}
+}
+
+// DefaultMailThrottle limits the verification and password reset emails an
+// address or an IP can ask for, every request counting as a failure.
+func DefaultMailThrottle() LoginThrottle {
+	return LoginThrottle{
+		Account: ThrottlePolicy{
+			FreeAttempts:     3,
+			BaseDelay:        time.Minute,
+			MaxDelay:         time.Hour,
+			LockoutThreshold: 20,
+			LockoutDuration:  24 * time.Hour,
+			ResetAfter:       24 * time.Hour,
+		},
+		IP: ThrottlePolicy{
+			FreeAttempts:     20,
+			BaseDelay:        time.Minute,
+			MaxDelay:         time.Hour,
+			LockoutThreshold: 200,
+			LockoutDuration:  24 * time.Hour,
+			ResetAfter:       24 * time.Hour,
+		},
+	}
+}
+
+// RetryAfter returns how long the key must wait before its next attempt.
+func (p ThrottlePolicy) RetryAfter(attempts *LoginAttempts, now time.Time) time.Duration {
+	if attempts == nil || attempts.Failures <= p.FreeAttempts {
+		return 0
+	}
+	if now.Sub(attempts.LastFailureAt) >= p.ResetAfter {
+		return 0
+	}
+
+	var delay time.Duration
+	if attempts.Failures >= p.LockoutThreshold {
+		delay = p.LockoutDuration
+	} else {
+		delay = p.BaseDelay
+		for i := p.FreeAttempts + 1; i < attempts.Failures && delay < p.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, p.MaxDelay)
+	}
+
+	wait := attempts.LastFailureAt.Add(delay).Sub(now)
+	return max(wait, 0)
+}
+
+func throttleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
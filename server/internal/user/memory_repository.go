@@ -0,0 +1,285 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type loginAttemptsKey struct {
+	scope, key string
+}
+
+// memoryRepository keeps users and sessions in maps. Nothing survives a
+// restart, it is meant for tests and local development. It hands out
+// copies so callers can't change stored records behind its back.
+type memoryRepository struct {
+	mu       sync.Mutex
+	nextID   int64
+	users    map[string]*User // by lowercase email
+	sessions map[string]*Session
+	attempts map[loginAttemptsKey]*LoginAttempts
+	tokens   map[string]*EmailToken
+}
+
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		users:    make(map[string]*User),
+		sessions: make(map[string]*Session),
+		attempts: make(map[loginAttemptsKey]*LoginAttempts),
+		tokens:   make(map[string]*EmailToken),
+	}
+}
+
+func (r *memoryRepository) CreateUser(ctx context.Context, user *User) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(user.Email)
+	if _, ok := r.users[key]; ok {
+		return nil, ErrEmailTaken
+	}
+	r.nextID++
+	user.ID = r.nextID
+	u := *user
+	r.users[key] = &u
+	return user, nil
+}
+
+// user matches emails exactly, like the email column does.
+func (r *memoryRepository) user(email string) *User {
+	u, ok := r.users[strings.ToLower(email)]
+	if !ok || u.Email != email {
+		return nil
+	}
+	return u
+}
+
+func (r *memoryRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u := r.user(email)
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	c := *u
+	return &c, nil
+}
+
+func (r *memoryRepository) CreateSession(ctx context.Context, session *Session) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.sessions[session.ID]; ok {
+		return nil, fmt.Errorf("error inserting sessions: duplicate id %s", session.ID)
+	}
+	s := *session
+	r.sessions[s.ID] = &s
+	c := s
+	return &c, nil
+}
+
+func (r *memoryRepository) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.RefreshToken == refreshToken {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, ErrSessionNotFound
+}
+
+func (r *memoryRepository) RevokeSession(ctx context.Context, refreshToken string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.RefreshToken == refreshToken {
+			s.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) RotateSession(ctx context.Context, id string, next *Session) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	if !ok || s.IsRevoked {
+		return false, nil
+	}
+	if _, ok := r.sessions[next.ID]; ok {
+		return false, fmt.Errorf("error failed to rotate session: duplicate id %s", next.ID)
+	}
+	s.IsRevoked = true
+	s.ReplacedBy = next.ID
+	c := *next
+	r.sessions[c.ID] = &c
+	return true, nil
+}
+
+func (r *memoryRepository) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.FamilyID == familyID {
+			s.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) ListActiveSessions(ctx context.Context, email string) ([]*Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	sessions := []*Session{}
+	for _, s := range r.sessions {
+		if s.Email == email && !s.IsRevoked && s.ExpiresAt.After(now) {
+			c := *s
+			sessions = append(sessions, &c)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+func (r *memoryRepository) RevokeSessionByID(ctx context.Context, email, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	target, ok := r.sessions[id]
+	if !ok || target.Email != email {
+		return false, nil
+	}
+	for _, s := range r.sessions {
+		if s.Email == email && s.FamilyID == target.FamilyID {
+			s.IsRevoked = true
+		}
+	}
+	return true, nil
+}
+
+func (r *memoryRepository) RevokeOtherSessions(ctx context.Context, email, keepFamilyID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.Email == email && s.FamilyID != keepFamilyID {
+			s.IsRevoked = true
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) DeleteSession(ctx context.Context, refreshToken string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, s := range r.sessions {
+		if s.RefreshToken == refreshToken {
+			delete(r.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepository) GetLoginAttempts(ctx context.Context, scope, key string) (*LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, ok := r.attempts[loginAttemptsKey{scope, key}]
+	if !ok {
+		return &LoginAttempts{Scope: scope, Key: key}, nil
+	}
+	c := *a
+	return &c, nil
+}
+
+func (r *memoryRepository) RecordLoginFailure(ctx context.Context, scope, key string, resetAfter time.Duration) (*LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	a, ok := r.attempts[loginAttemptsKey{scope, key}]
+	if !ok {
+		a = &LoginAttempts{Scope: scope, Key: key}
+		r.attempts[loginAttemptsKey{scope, key}] = a
+	}
+	if a.LastFailureAt.Before(now.Add(-resetAfter)) {
+		a.Failures = 1
+	} else {
+		a.Failures++
+	}
+	a.LastFailureAt = now
+	c := *a
+	return &c, nil
+}
+
+func (r *memoryRepository) ClearLoginAttempts(ctx context.Context, scope, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, loginAttemptsKey{scope, key})
+	return nil
+}
+
+func (r *memoryRepository) MarkEmailVerified(ctx context.Context, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.user(email); u != nil && u.VerifiedAt == nil {
+		now := time.Now()
+		u.VerifiedAt = &now
+	}
+	return nil
+}
+
+func (r *memoryRepository) UpdatePassword(ctx context.Context, email, hashedPassword string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u := r.user(email); u != nil {
+		u.Password = hashedPassword
+	}
+	return nil
+}
+
+func (r *memoryRepository) CreateEmailToken(ctx context.Context, token *EmailToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tokens[token.ID]; ok {
+		return fmt.Errorf("error inserting email token: duplicate id")
+	}
+	t := *token
+	t.UsedAt = nil
+	r.tokens[t.ID] = &t
+	return nil
+}
+
+func (r *memoryRepository) UseEmailToken(ctx context.Context, id, purpose string) (*EmailToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	t, ok := r.tokens[id]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil || !t.ExpiresAt.After(now) {
+		return nil, ErrInvalidEmailToken
+	}
+	t.UsedAt = &now
+	c := *t
+	return &c, nil
+}
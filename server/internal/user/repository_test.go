@@ -0,0 +1,22 @@
+package user_test
+
+import (
+	"testing"
+
+	"server/db/dbtest"
+	"server/internal/user/usertest"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	usertest.Run(t, usertest.Memory)
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	usertest.Run(t, usertest.SQLite)
+}
+
+// TestPostgresRepository runs on the database named by TEST_DATABASE_URL,
+// migrating it first. Its user tables must be empty.
+func TestPostgresRepository(t *testing.T) {
+	usertest.Run(t, usertest.Postgres(dbtest.Open(t)))
+}
@@ -0,0 +1,146 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteSchema mirrors the users, sessions, login_attempts and email_tokens
+// tables built by the Postgres migrations. Keep them in sync.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username varchar NOT NULL,
+    email varchar NOT NULL UNIQUE COLLATE NOCASE,
+    password varchar NOT NULL,
+    verified_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id varchar(255) PRIMARY KEY NOT NULL,
+    family_id varchar(255) NOT NULL,
+    email varchar(255) NOT NULL,
+    refresh_token varchar(512) NOT NULL,
+    is_revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    user_agent varchar(512) NOT NULL DEFAULT '',
+    ip_address varchar(64) NOT NULL DEFAULT '',
+    replaced_by varchar(255) NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS sessions_family_id_idx ON sessions (family_id);
+CREATE INDEX IF NOT EXISTS sessions_refresh_token_idx ON sessions (refresh_token);
+CREATE INDEX IF NOT EXISTS sessions_email_idx ON sessions (email);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    scope varchar(16) NOT NULL,
+    key varchar(255) NOT NULL,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE IF NOT EXISTS email_tokens (
+    id varchar(255) PRIMARY KEY NOT NULL,
+    email varchar(255) NOT NULL,
+    purpose varchar(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS email_tokens_email_idx ON email_tokens (email);
+`
+
+// CreateSQLiteSchema creates the tables used by the SQLite repository if
+// they don't exist yet.
+func CreateSQLiteSchema(ctx context.Context, db DBTX) error {
+	_, err := db.ExecContext(ctx, sqliteSchema)
+	return err
+}
+
+// sqliteRepository runs the Postgres queries, which SQLite understands, on
+// a connection that stores every time in UTC.
+type sqliteRepository struct {
+	*repository
+	conn *sql.DB
+}
+
+func NewSQLiteRepository(db *sql.DB) Repository {
+	return &sqliteRepository{
+		repository: &repository{db: utcDB{db}},
+		conn:       db,
+	}
+}
+
+func (r *sqliteRepository) CreateUser(ctx context.Context, user *User) (*User, error) {
+	u, err := r.repository.CreateUser(ctx, user)
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return nil, ErrEmailTaken
+	}
+	return u, err
+}
+
+// RotateSession does in a transaction what the Postgres query does in one
+// statement, SQLite can't update in a WITH clause.
+func (r *sqliteRepository) RotateSession(ctx context.Context, id string, next *Session) (bool, error) {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error failed to rotate session: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE sessions SET is_revoked = true, replaced_by = $2 WHERE id = $1 AND is_revoked = false`
+	res, err := tx.ExecContext(ctx, query, id, next.ID)
+	if err != nil {
+		return false, fmt.Errorf("error failed to rotate session: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error failed to rotate session: %w", err)
+	}
+	if n == 0 {
+		return false, nil
+	}
+	txRepo := &repository{db: utcDB{tx}}
+	if _, err := txRepo.CreateSession(ctx, next); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error failed to rotate session: %w", err)
+	}
+	return true, nil
+}
+
+// utcDB converts time arguments to UTC. SQLite compares times as text,
+// which only matches their order when they share a time zone.
+type utcDB struct {
+	DBTX
+}
+
+func (d utcDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.DBTX.ExecContext(ctx, query, utc(args)...)
+}
+
+func (d utcDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return d.DBTX.QueryContext(ctx, query, utc(args)...)
+}
+
+func (d utcDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return d.DBTX.QueryRowContext(ctx, query, utc(args)...)
+}
+
+func utc(args []interface{}) []interface{} {
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			args[i] = t.UTC()
+		}
+	}
+	return args
+}
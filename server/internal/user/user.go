@@ -2,14 +2,29 @@ package user
 
 import (
 	"context"
+	"server/internal/apperr"
 	"time"
 )
 
+var (
+	ErrUserNotFound         = apperr.New(apperr.NotFound, "user_not_found", "user not found")
+	ErrEmailTaken           = apperr.New(apperr.Conflict, "email_taken", "email already registered")
+	ErrRefreshTokenReused   = apperr.New(apperr.Unauthorized, "refresh_token_reused", "refresh token reused")
+	ErrInvalidRefreshToken  = apperr.New(apperr.Unauthorized, "invalid_refresh_token", "invalid or expired refresh token")
+	ErrSessionNotFound      = apperr.New(apperr.NotFound, "session_not_found", "session not found")
+	ErrInvalidCredentials   = apperr.New(apperr.Unauthorized, "invalid_credentials", "invalid credentials")
+	ErrWrongPassword        = apperr.New(apperr.Forbidden, "wrong_password", "current password is incorrect")
+	ErrEmailNotVerified     = apperr.New(apperr.Forbidden, "email_not_verified", "email not verified")
+	ErrTooManyLoginAttempts = apperr.New(apperr.RateLimited, "too_many_login_attempts", "too many failed login attempts")
+	ErrTooManyEmails        = apperr.New(apperr.RateLimited, "too_many_emails", "too many emails requested")
+)
+
 type User struct {
-	ID       int64  `json:"id" db:"id"`
-	Username string `json:"username" db:"username"`
-	Email    string `json:"email" db:"email"`
-	Password string `json:"password" db:"password"`
+	ID         int64      `json:"id" db:"id"`
+	Username   string     `json:"username" db:"username"`
+	Email      string     `json:"email" db:"email"`
+	Password   string     `json:"password" db:"password"`
+	VerifiedAt *time.Time `json:"verified_at" db:"verified_at"`
 }
 
 type CreateUserRequest struct {
@@ -25,8 +40,10 @@ type CreateUserResponse struct {
 }
 
 type LoginUserRequest struct {
-	Email    string `json:"email" db:"email"`
-	Password string `json:"password" db:"password"`
+	Email     string `json:"email" db:"email"`
+	Password  string `json:"password" db:"password"`
+	UserAgent string `json:"-"`
+	IPAddress string `json:"-"`
 }
 
 type LoginUserResponse struct {
@@ -35,6 +52,9 @@ type LoginUserResponse struct {
 	Email        string `json:"email" db:"email"`
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+
+	AccessTokenExpiresAt  time.Time `json:"-"`
+	RefreshTokenExpiresAt time.Time `json:"-"`
 }
 
 type RefreshTokenRequest struct {
@@ -42,21 +62,72 @@ type RefreshTokenRequest struct {
 }
 
 type RefreshTokenResponse struct {
-	AccessToken string `json:"access_token"`
-	Message     string `json:"message"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Message      string `json:"message"`
+
+	AccessTokenExpiresAt  time.Time `json:"-"`
+	RefreshTokenExpiresAt time.Time `json:"-"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ResendVerificationRequest struct {
+	Email     string `json:"email"`
+	IPAddress string `json:"-"`
+}
+
+type ForgotPasswordRequest struct {
+	Email     string `json:"email"`
+	IPAddress string `json:"-"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordRequest struct {
+	Email        string `json:"-"`
+	OldPassword  string `json:"old_password"`
+	NewPassword  string `json:"new_password"`
+	RefreshToken string `json:"-"`
+}
+
+type UnlockAccountRequest struct {
+	Email string `json:"email"`
 }
 
 type LogoutRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+// Session is one refresh token. Every refresh rotates the token into a new
+// session of the same family, so a family is one login on one device.
+// ReplacedBy is the ID of the session a rotated one was replaced by, it is
+// empty for sessions revoked otherwise.
 type Session struct {
 	ID           string    `db:"id"`
+	FamilyID     string    `db:"family_id"`
 	Email        string    `db:"email"`
 	RefreshToken string    `db:"refresh_token"`
 	IsRevoked    bool      `db:"is_revoked"`
 	CreatedAt    time.Time `db:"created_at"`
 	ExpiresAt    time.Time `db:"expires_at"`
+	UserAgent    string    `db:"user_agent"`
+	IPAddress    string    `db:"ip_address"`
+	ReplacedBy   string    `db:"replaced_by"`
+}
+
+type SessionResponse struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
 }
 
 type Repository interface {
@@ -65,7 +136,19 @@ type Repository interface {
 	CreateSession(ctx context.Context, session *Session) (*Session, error)
 	GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*Session, error)
 	RevokeSession(ctx context.Context, refreshToken string) error
+	RotateSession(ctx context.Context, id string, next *Session) (bool, error)
+	RevokeSessionFamily(ctx context.Context, familyID string) error
+	ListActiveSessions(ctx context.Context, email string) ([]*Session, error)
+	RevokeSessionByID(ctx context.Context, email, id string) (bool, error)
+	RevokeOtherSessions(ctx context.Context, email, keepFamilyID string) error
 	DeleteSession(ctx context.Context, refreshToken string) error
+	GetLoginAttempts(ctx context.Context, scope, key string) (*LoginAttempts, error)
+	RecordLoginFailure(ctx context.Context, scope, key string, resetAfter time.Duration) (*LoginAttempts, error)
+	ClearLoginAttempts(ctx context.Context, scope, key string) error
+	MarkEmailVerified(ctx context.Context, email string) error
+	UpdatePassword(ctx context.Context, email, hashedPassword string) error
+	CreateEmailToken(ctx context.Context, token *EmailToken) error
+	UseEmailToken(ctx context.Context, id, purpose string) (*EmailToken, error)
 }
 
 type Service interface {
@@ -73,4 +156,13 @@ type Service interface {
 	Login(c context.Context, req *LoginUserRequest) (*LoginUserResponse, error)
 	RefreshToken(c context.Context, req *RefreshTokenRequest) (*RefreshTokenResponse, error)
 	Logout(c context.Context, req *LogoutRequest) error
+	ListSessions(c context.Context, email, refreshToken string) ([]*SessionResponse, error)
+	RevokeUserSession(c context.Context, email, id string) error
+	RevokeOtherSessions(c context.Context, email, refreshToken string) error
+	UnlockAccount(c context.Context, req *UnlockAccountRequest) error
+	VerifyEmail(c context.Context, req *VerifyEmailRequest) error
+	ResendVerification(c context.Context, req *ResendVerificationRequest) error
+	ForgotPassword(c context.Context, req *ForgotPasswordRequest) error
+	ResetPassword(c context.Context, req *ResetPasswordRequest) error
+	ChangePassword(c context.Context, req *ChangePasswordRequest) error
 }
@@ -2,9 +2,15 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"net"
 	"net/http"
+	"server/internal/auth"
 	"server/internal/utils"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type Handler struct {
@@ -24,6 +30,10 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
 		return
 	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
 	user, err := h.Service.CreateUser(ctx, &req)
 	if err != nil {
 		utils.WriteError(w, r, http.StatusInternalServerError, "could not create user", err)
@@ -42,16 +52,22 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
 		return
 	}
+	if err := request.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	request.UserAgent = r.UserAgent()
+	request.IPAddress = clientIP(r)
 
 	// Login and get user response with tokens
 	user, err := h.Service.Login(ctx, &request)
 	if err != nil {
-		utils.WriteError(w, r, http.StatusUnauthorized, "invalid credentials", err)
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not log in", err)
 		return
 	}
 
 	// Set HTTP-only cookies
-	h.setTokenCookies(w, user.AccessToken, user.RefreshToken)
+	h.setTokenCookies(w, user.AccessToken, user.AccessTokenExpiresAt, user.RefreshToken, user.RefreshTokenExpiresAt)
 
 	response := map[string]interface{}{
 		"id":       user.ID,
@@ -82,20 +98,14 @@ func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 
 	response, err := h.Service.RefreshToken(ctx, req)
 	if err != nil {
-		utils.WriteError(w, r, http.StatusUnauthorized, "failed to refresh token", err)
+		if errors.Is(err, ErrRefreshTokenReused) {
+			h.clearTokenCookies(w)
+		}
+		utils.WriteError(w, r, http.StatusInternalServerError, "failed to refresh token", err)
 		return
 	}
 
-	accessCookie := &http.Cookie{
-		Name:     "access_token",
-		Value:    response.AccessToken,
-		Path:     "/",
-		MaxAge:   15 * 60,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteStrictMode,
-	}
-	http.SetCookie(w, accessCookie)
+	h.setTokenCookies(w, response.AccessToken, response.AccessTokenExpiresAt, response.RefreshToken, response.RefreshTokenExpiresAt)
 
 	responseBody := map[string]string{
 		"message": response.Message,
@@ -136,12 +146,239 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 	log.Println("user logged out")
 }
 
-func (h *Handler) setTokenCookies(w http.ResponseWriter, accessToken, refreshToken string) {
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	sessions, err := h.Service.ListSessions(ctx, identity.Email, refreshTokenFromCookie(r))
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not list sessions", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	err := h.Service.RevokeUserSession(ctx, identity.Email, chi.URLParam(r, "id"))
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not revoke session", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Println("session revoked")
+}
+
+func (h *Handler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+
+	err := h.Service.RevokeOtherSessions(ctx, identity.Email, refreshTokenFromCookie(r))
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not revoke sessions", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "other sessions revoked",
+	})
+	log.Println("other sessions revoked")
+}
+
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+
+	if err := h.Service.VerifyEmail(ctx, &req); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not verify email", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "email verified",
+	})
+	log.Println("email verified")
+}
+
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	req.IPAddress = clientIP(r)
+
+	if err := h.Service.ResendVerification(ctx, &req); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not send verification email", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "if the account exists and is unverified, an email is on its way",
+	})
+}
+
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	req.IPAddress = clientIP(r)
+
+	if err := h.Service.ForgotPassword(ctx, &req); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not send password reset email", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "if the account exists, an email is on its way",
+	})
+}
+
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+
+	if err := h.Service.ResetPassword(ctx, &req); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not reset password", err)
+		return
+	}
+	h.clearTokenCookies(w)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "password reset",
+	})
+	log.Println("password reset")
+}
+
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	identity, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
+		return
+	}
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	req.Email = identity.Email
+	req.RefreshToken = refreshTokenFromCookie(r)
+
+	if err := h.Service.ChangePassword(ctx, &req); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not change password", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "password changed",
+	})
+	log.Println("password changed")
+}
+
+// UnlockAccount clears the failed login counter of an account. It is meant
+// for operators and is mounted behind the admin key.
+func (h *Handler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req UnlockAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
+		return
+	}
+
+	if err := h.Service.UnlockAccount(ctx, &req); err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not unlock account", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "account unlocked",
+	})
+	log.Println("account unlocked")
+}
+
+func refreshTokenFromCookie(r *http.Request) string {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (h *Handler) setTokenCookies(w http.ResponseWriter, accessToken string, accessExpiresAt time.Time, refreshToken string, refreshExpiresAt time.Time) {
 	accessCookie := &http.Cookie{
 		Name:     "access_token",
 		Value:    accessToken,
 		Path:     "/",
-		MaxAge:   15 * 60,
+		MaxAge:   int(time.Until(accessExpiresAt).Seconds()),
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
@@ -151,7 +388,7 @@ func (h *Handler) setTokenCookies(w http.ResponseWriter, accessToken, refreshTok
 		Name:     "refresh_token",
 		Value:    refreshToken,
 		Path:     "/",
-		MaxAge:   7 * 24 * 60 * 60,
+		MaxAge:   int(time.Until(refreshExpiresAt).Seconds()),
 		HttpOnly: true,
 		Secure:   true,
 		SameSite: http.SameSiteStrictMode,
@@ -3,7 +3,11 @@ package user
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 type DBTX interface {
@@ -26,7 +30,11 @@ func (r *repository) CreateUser(ctx context.Context, user *User) (*User, error)
 	query := "INSERT INTO users(username, password, email) VALUES ($1, $2, $3) returning id"
 	err := r.db.QueryRowContext(ctx, query, user.Username, user.Password, user.Email).Scan(&insertId)
 	if err != nil {
-		return nil, err
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrEmailTaken
+		}
+		return nil, fmt.Errorf("error failed to create user: %w", err)
 	}
 
 	user.ID = int64(insertId)
@@ -35,38 +43,58 @@ func (r *repository) CreateUser(ctx context.Context, user *User) (*User, error)
 
 func (r *repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	u := User{}
-	query := "SELECT id, email, username, password FROM users WHERE email = $1"
-	err := r.db.QueryRowContext(ctx, query, email).Scan(&u.ID, &u.Email, &u.Username, &u.Password)
+	query := "SELECT id, email, username, password, verified_at FROM users WHERE email = $1"
+	err := r.db.QueryRowContext(ctx, query, email).Scan(&u.ID, &u.Email, &u.Username, &u.Password, &u.VerifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error failed to retrieve user: %w", err)
 	}
 	return &u, nil
 }
 
+const sessionColumns = `id, family_id, email, refresh_token, is_revoked, created_at, expires_at, user_agent, ip_address, replaced_by`
+
+// fields returns scan destinations in sessionColumns order.
+func (s *Session) fields() []interface{} {
+	return []interface{}{
+		&s.ID, &s.FamilyID, &s.Email, &s.RefreshToken, &s.IsRevoked, &s.CreatedAt, &s.ExpiresAt, &s.UserAgent, &s.IPAddress, &s.ReplacedBy,
+	}
+}
+
 func (r *repository) CreateSession(ctx context.Context, session *Session) (*Session, error) {
-	query := `INSERT INTO sessions (id, email, refresh_token, is_revoked, created_at, expires_at)
-			  VALUES($1, $2, $3, $4, $5, $6) RETURNING *`
+	query := `INSERT INTO sessions (id, family_id, email, refresh_token, is_revoked, created_at, expires_at, user_agent, ip_address)
+			  VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			  RETURNING ` + sessionColumns
 	var s Session
 	err := r.db.QueryRowContext(ctx,
 		query,
 		session.ID,
+		session.FamilyID,
 		session.Email,
 		session.RefreshToken,
 		session.IsRevoked,
 		session.CreatedAt,
 		session.ExpiresAt,
-	).Scan(&s.ID, &s.Email, &s.RefreshToken, &s.IsRevoked, &s.CreatedAt, &s.ExpiresAt)
+		session.UserAgent,
+		session.IPAddress,
+	).Scan(s.fields()...)
 	if err != nil {
 		return nil, fmt.Errorf("error inserting sessions: %w", err)
 	}
 	return &s, nil
 }
+
+// GetSessionByRefreshToken also returns revoked sessions so that reuse of a
+// rotated token can be detected.
 func (r *repository) GetSessionByRefreshToken(ctx context.Context, refreshToken string) (*Session, error) {
-	query := `SELECT id, email, refresh_token, is_revoked, created_at, expires_at 
-              FROM sessions WHERE refresh_token = $1 AND is_revoked = false`
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE refresh_token = $1`
 	var s Session
-	err := r.db.QueryRowContext(ctx, query, refreshToken).Scan(
-		&s.ID, &s.Email, &s.RefreshToken, &s.IsRevoked, &s.CreatedAt, &s.ExpiresAt)
+	err := r.db.QueryRowContext(ctx, query, refreshToken).Scan(s.fields()...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error failed to retrieve sessions: %w", err)
 	}
@@ -83,6 +111,187 @@ func (r *repository) RevokeSession(ctx context.Context, refreshToken string) err
 	return nil
 }
 
+// RotateSession replaces a session by next, revoking it and storing next in
+// one statement so a failed rotation leaves the session as it was. It
+// reports false if the session was already revoked, rotated or not.
+func (r *repository) RotateSession(ctx context.Context, id string, next *Session) (bool, error) {
+	query := `WITH rotated AS (
+				  UPDATE sessions SET is_revoked = true, replaced_by = $2 WHERE id = $1 AND is_revoked = false
+				  RETURNING id
+			  )
+			  INSERT INTO sessions (id, family_id, email, refresh_token, is_revoked, created_at, expires_at, user_agent, ip_address)
+			  SELECT $2, $3, $4, $5, $6, $7, $8, $9, $10 FROM rotated`
+	res, err := r.db.ExecContext(ctx,
+		query,
+		id,
+		next.ID,
+		next.FamilyID,
+		next.Email,
+		next.RefreshToken,
+		next.IsRevoked,
+		next.CreatedAt,
+		next.ExpiresAt,
+		next.UserAgent,
+		next.IPAddress,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error failed to rotate session: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error failed to rotate session: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (r *repository) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	query := `UPDATE sessions SET is_revoked = true WHERE family_id = $1`
+	_, err := r.db.ExecContext(ctx, query, familyID)
+	if err != nil {
+		return fmt.Errorf("error failed to revoke session family: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) ListActiveSessions(ctx context.Context, email string) ([]*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions
+			  WHERE email = $1 AND is_revoked = false AND expires_at > $2
+			  ORDER BY created_at DESC`
+	rows, err := r.db.QueryContext(ctx, query, email, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(s.fields()...); err != nil {
+			return nil, fmt.Errorf("error failed to retrieve sessions: %w", err)
+		}
+		sessions = append(sessions, &s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error failed to retrieve sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSessionByID logs one device out by revoking the session's family. It
+// reports false if the user has no such session.
+func (r *repository) RevokeSessionByID(ctx context.Context, email, id string) (bool, error) {
+	query := `UPDATE sessions SET is_revoked = true
+			  WHERE email = $1 AND family_id = (SELECT family_id FROM sessions WHERE id = $2 AND email = $1)`
+	res, err := r.db.ExecContext(ctx, query, email, id)
+	if err != nil {
+		return false, fmt.Errorf("error failed to revoke sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error failed to revoke sessions: %w", err)
+	}
+	return n > 0, nil
+}
+
+// RevokeOtherSessions revokes every session of the user outside of the given
+// family. An empty family revokes them all.
+func (r *repository) RevokeOtherSessions(ctx context.Context, email, keepFamilyID string) error {
+	query := `UPDATE sessions SET is_revoked = true
+			  WHERE email = $1 AND family_id <> $2 AND is_revoked = false`
+	_, err := r.db.ExecContext(ctx, query, email, keepFamilyID)
+	if err != nil {
+		return fmt.Errorf("error failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// GetLoginAttempts returns an empty record when the key never failed.
+func (r *repository) GetLoginAttempts(ctx context.Context, scope, key string) (*LoginAttempts, error) {
+	query := `SELECT scope, key, failures, last_failure_at FROM login_attempts WHERE scope = $1 AND key = $2`
+	a := LoginAttempts{Scope: scope, Key: key}
+	err := r.db.QueryRowContext(ctx, query, scope, key).Scan(&a.Scope, &a.Key, &a.Failures, &a.LastFailureAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to retrieve login attempts: %w", err)
+	}
+	return &a, nil
+}
+
+// RecordLoginFailure atomically counts one more failure, starting over if the
+// previous one is older than resetAfter.
+func (r *repository) RecordLoginFailure(ctx context.Context, scope, key string, resetAfter time.Duration) (*LoginAttempts, error) {
+	now := time.Now()
+	query := `INSERT INTO login_attempts (scope, key, failures, last_failure_at) VALUES ($1, $2, 1, $3)
+			  ON CONFLICT (scope, key) DO UPDATE SET
+			  failures = CASE WHEN login_attempts.last_failure_at < $4 THEN 1 ELSE login_attempts.failures + 1 END,
+			  last_failure_at = $3
+			  RETURNING scope, key, failures, last_failure_at`
+	var a LoginAttempts
+	err := r.db.QueryRowContext(ctx, query, scope, key, now, now.Add(-resetAfter)).
+		Scan(&a.Scope, &a.Key, &a.Failures, &a.LastFailureAt)
+	if err != nil {
+		return nil, fmt.Errorf("error failed to record login failure: %w", err)
+	}
+	return &a, nil
+}
+
+func (r *repository) ClearLoginAttempts(ctx context.Context, scope, key string) error {
+	query := `DELETE FROM login_attempts WHERE scope = $1 AND key = $2`
+	_, err := r.db.ExecContext(ctx, query, scope, key)
+	if err != nil {
+		return fmt.Errorf("error failed to clear login attempts: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) MarkEmailVerified(ctx context.Context, email string) error {
+	query := `UPDATE users SET verified_at = $2 WHERE email = $1 AND verified_at IS NULL`
+	_, err := r.db.ExecContext(ctx, query, email, time.Now())
+	if err != nil {
+		return fmt.Errorf("error failed to verify email: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) UpdatePassword(ctx context.Context, email, hashedPassword string) error {
+	query := `UPDATE users SET password = $2 WHERE email = $1`
+	_, err := r.db.ExecContext(ctx, query, email, hashedPassword)
+	if err != nil {
+		return fmt.Errorf("error failed to update password: %w", err)
+	}
+	return nil
+}
+
+func (r *repository) CreateEmailToken(ctx context.Context, token *EmailToken) error {
+	query := `INSERT INTO email_tokens (id, email, purpose, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := r.db.ExecContext(ctx, query, token.ID, token.Email, token.Purpose, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("error inserting email token: %w", err)
+	}
+	return nil
+}
+
+// UseEmailToken consumes a token, so a second use or an expired token
+// returns ErrInvalidEmailToken.
+func (r *repository) UseEmailToken(ctx context.Context, id, purpose string) (*EmailToken, error) {
+	now := time.Now()
+	query := `UPDATE email_tokens SET used_at = $3
+			  WHERE id = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+			  RETURNING id, email, purpose, created_at, expires_at, used_at`
+	var t EmailToken
+	err := r.db.QueryRowContext(ctx, query, id, purpose, now).
+		Scan(&t.ID, &t.Email, &t.Purpose, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidEmailToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error failed to use email token: %w", err)
+	}
+	return &t, nil
+}
+
 func (r *repository) DeleteSession(ctx context.Context, refreshToken string) error {
 	query := `DELETE FROM sessions WHERE refresh_token = $1`
 	_, err := r.db.ExecContext(ctx, query, refreshToken)
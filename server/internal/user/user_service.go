@@ -2,7 +2,11 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"server/internal/mail"
 	"server/internal/token"
 	"server/internal/utils"
 	"strconv"
@@ -11,17 +15,57 @@ import (
 	"github.com/google/uuid"
 )
 
+// Config tunes the user service.
+type Config struct {
+	// AccessTokenTTL is how long an access token is valid.
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is how long a login lasts. Refreshing rotates the
+	// token but keeps its expiry, so a stolen family dies with it.
+	RefreshTokenTTL time.Duration
+	Throttle        LoginThrottle
+	// MailThrottle limits the emails one address or IP can ask for.
+	MailThrottle LoginThrottle
+	Verification VerificationPolicy
+	// EmailTokenTTL is how long verification links sent by email stay valid.
+	EmailTokenTTL time.Duration
+	// PasswordResetTTL is how long password reset links stay valid.
+	PasswordResetTTL time.Duration
+	// AppURL is the address of the web app that links in emails point to.
+	AppURL string
+	// TokenSecret signs the tokens sent by email.
+	TokenSecret []byte
+}
+
+func DefaultConfig() Config {
+	return Config{
+		AccessTokenTTL:   15 * time.Minute,
+		RefreshTokenTTL:  7 * 24 * time.Hour,
+		Throttle:         DefaultLoginThrottle(),
+		MailThrottle:     DefaultMailThrottle(),
+		Verification:     VerificationBeforeLogin,
+		EmailTokenTTL:    24 * time.Hour,
+		PasswordResetTTL: time.Hour,
+		AppURL:           "http://localhost:3000",
+	}
+}
+
 type service struct {
 	Repository
-	timeout   time.Duration
-	secretKey string
+	timeout     time.Duration
+	mailTimeout time.Duration
+	jwtMaker    *token.JWTMaker
+	mailer      mail.Mailer
+	config      Config
 }
 
-func NewService(repository Repository, secretKey string) Service {
+func NewService(repository Repository, jwtMaker *token.JWTMaker, mailer mail.Mailer, config Config) Service {
 	return &service{
-		Repository: repository,
-		timeout:    time.Duration(2) * time.Second,
-		secretKey:  secretKey,
+		Repository:  repository,
+		timeout:     time.Duration(2) * time.Second,
+		mailTimeout: time.Duration(10) * time.Second,
+		jwtMaker:    jwtMaker,
+		mailer:      mailer,
+		config:      config,
 	}
 }
 
@@ -44,6 +88,10 @@ func (s *service) CreateUser(c context.Context, req *CreateUserRequest) (*Create
 	if err != nil {
 		return nil, err
 	}
+	// The account exists either way, a lost email can be sent again
+	s.sendLater("verification", func(ctx context.Context) error {
+		return s.sendVerification(ctx, user.Email)
+	})
 	res := &CreateUserResponse{
 		ID:       strconv.Itoa(int(user.ID)),
 		Email:    user.Email,
@@ -52,21 +100,40 @@ func (s *service) CreateUser(c context.Context, req *CreateUserRequest) (*Create
 	return res, nil
 }
 
+// dummyPasswordHash is a bcrypt hash at the default cost that no password
+// matches, it is compared against when logging in with an unknown email.
+const dummyPasswordHash = "$2a$10$e4/l05ufHnFCgyhb/ZPqTexjri2wZYh6VXYlBIj4Zz.3TEqmAbO.a"
+
 func (s *service) Login(c context.Context, req *LoginUserRequest) (*LoginUserResponse, error) {
 	ctx, cancel := context.WithTimeout(c, s.timeout)
 	defer cancel()
 
+	if err := s.checkThrottle(ctx, req); err != nil {
+		return nil, err
+	}
+
 	u, err := s.Repository.GetUserByEmail(ctx, req.Email)
+	if errors.Is(err, ErrUserNotFound) {
+		// Unknown emails take as long as wrong passwords, so the time
+		// taken doesn't tell whether an account exists
+		utils.CheckPassword(req.Password, dummyPasswordHash)
+		return nil, s.recordLoginFailure(ctx, req, err)
+	}
 	if err != nil {
 		return nil, err
 	}
 	err = utils.CheckPassword(req.Password, u.Password)
 	if err != nil {
+		return nil, s.recordLoginFailure(ctx, req, err)
+	}
+	if err := s.Repository.ClearLoginAttempts(ctx, ThrottleScopeAccount, throttleKey(req.Email)); err != nil {
 		return nil, err
 	}
+	if u.VerifiedAt == nil && s.config.Verification == VerificationBeforeLogin {
+		return nil, ErrEmailNotVerified
+	}
 
-	jwtMaker := token.NewJwtMaker(s.secretKey)
-	accessToken, _, err := jwtMaker.CreateToken(int(u.ID), u.Email, time.Minute*15)
+	accessToken, accessClaims, err := s.jwtMaker.CreateToken(int(u.ID), u.Email, u.Username, u.VerifiedAt != nil, s.config.AccessTokenTTL)
 	if err != nil {
 		return nil, err
 	}
@@ -81,11 +148,14 @@ func (s *service) Login(c context.Context, req *LoginUserRequest) (*LoginUserRes
 	sessionID := uuid.New().String()
 	session := &Session{
 		ID:           sessionID,
+		FamilyID:     sessionID,
 		Email:        u.Email,
 		RefreshToken: refreshToken,
 		IsRevoked:    false,
 		CreatedAt:    time.Now(),
-		ExpiresAt:    time.Now().Add(time.Hour * 24 * 7), // 7 days
+		ExpiresAt:    time.Now().Add(s.config.RefreshTokenTTL),
+		UserAgent:    req.UserAgent,
+		IPAddress:    req.IPAddress,
 	}
 
 	_, err = s.Repository.CreateSession(ctx, session)
@@ -100,21 +170,74 @@ func (s *service) Login(c context.Context, req *LoginUserRequest) (*LoginUserRes
 		Email:        u.Email,
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+
+		AccessTokenExpiresAt:  accessClaims.ExpiresAt.Time,
+		RefreshTokenExpiresAt: session.ExpiresAt,
 	}, nil
 }
 
+// checkThrottle refuses the attempt while the account or the IP is backing
+// off from earlier failures.
+func (s *service) checkThrottle(ctx context.Context, req *LoginUserRequest) error {
+	now := time.Now()
+	var retryAfter time.Duration
+
+	account, err := s.Repository.GetLoginAttempts(ctx, ThrottleScopeAccount, throttleKey(req.Email))
+	if err != nil {
+		return err
+	}
+	retryAfter = max(retryAfter, s.config.Throttle.Account.RetryAfter(account, now))
+
+	if req.IPAddress != "" {
+		ip, err := s.Repository.GetLoginAttempts(ctx, ThrottleScopeIP, req.IPAddress)
+		if err != nil {
+			return err
+		}
+		retryAfter = max(retryAfter, s.config.Throttle.IP.RetryAfter(ip, now))
+	}
+
+	if retryAfter > 0 {
+		return ErrTooManyLoginAttempts.WithRetryAfter(retryAfter)
+	}
+	return nil
+}
+
+func (s *service) recordLoginFailure(ctx context.Context, req *LoginUserRequest, cause error) error {
+	attempts, err := s.Repository.RecordLoginFailure(ctx, ThrottleScopeAccount, throttleKey(req.Email), s.config.Throttle.Account.ResetAfter)
+	if err != nil {
+		return err
+	}
+	if attempts.Failures == s.config.Throttle.Account.LockoutThreshold {
+		log.Printf("account %s locked after %d failed logins", attempts.Key, attempts.Failures)
+	}
+	if req.IPAddress != "" {
+		_, err := s.Repository.RecordLoginFailure(ctx, ThrottleScopeIP, req.IPAddress, s.config.Throttle.IP.ResetAfter)
+		if err != nil {
+			return err
+		}
+	}
+	return ErrInvalidCredentials.Wrap(cause)
+}
+
 func (s *service) RefreshToken(c context.Context, req *RefreshTokenRequest) (*RefreshTokenResponse, error) {
 	ctx, cancel := context.WithTimeout(c, s.timeout)
 	defer cancel()
 
 	// Get session by refresh token
 	session, err := s.Repository.GetSessionByRefreshToken(ctx, req.RefreshToken)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, ErrInvalidRefreshToken.Wrap(err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("invalid refresh token: %w", err)
+		return nil, err
+	}
+
+	if session.IsRevoked {
+		return nil, s.refused(ctx, session)
 	}
 
 	if time.Now().After(session.ExpiresAt) {
-		return nil, fmt.Errorf("refresh token expired")
+		return nil, ErrInvalidRefreshToken
 	}
 
 	user, err := s.Repository.GetUserByEmail(ctx, session.Email)
@@ -122,18 +245,68 @@ func (s *service) RefreshToken(c context.Context, req *RefreshTokenRequest) (*Re
 		return nil, err
 	}
 
-	jwtMaker := token.NewJwtMaker(s.secretKey)
-	accessToken, _, err := jwtMaker.CreateToken(int(user.ID), user.Email, time.Minute*15)
+	accessToken, accessClaims, err := s.jwtMaker.CreateToken(int(user.ID), user.Email, user.Username, user.VerifiedAt != nil, s.config.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateSecureToken(32)
 	if err != nil {
 		return nil, err
 	}
 
+	next := &Session{
+		ID:           uuid.New().String(),
+		FamilyID:     session.FamilyID,
+		Email:        session.Email,
+		RefreshToken: refreshToken,
+		IsRevoked:    false,
+		CreatedAt:    time.Now(),
+		ExpiresAt:    session.ExpiresAt,
+		UserAgent:    session.UserAgent,
+		IPAddress:    session.IPAddress,
+	}
+	rotated, err := s.Repository.RotateSession(ctx, session.ID, next)
+	if err != nil {
+		return nil, err
+	}
+	// Another request rotated or revoked the same token first
+	if !rotated {
+		current, err := s.Repository.GetSessionByRefreshToken(ctx, req.RefreshToken)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.refused(ctx, current)
+	}
+
 	return &RefreshTokenResponse{
-		AccessToken: accessToken,
-		Message:     "Token refreshed successfully",
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Message:      "Token refreshed successfully",
+
+		AccessTokenExpiresAt:  accessClaims.ExpiresAt.Time,
+		RefreshTokenExpiresAt: next.ExpiresAt,
 	}, nil
 }
 
+// refused answers a refresh with a revoked session. A rotated token coming
+// back means it was copied, which logs its whole family out. Tokens revoked
+// by logging out are merely invalid.
+func (s *service) refused(ctx context.Context, session *Session) error {
+	if session.ReplacedBy == "" {
+		return ErrInvalidRefreshToken
+	}
+	return s.revokeReusedFamily(ctx, session)
+}
+
+func (s *service) revokeReusedFamily(ctx context.Context, session *Session) error {
+	log.Printf("refresh token reuse detected for session family %s", session.FamilyID)
+	if err := s.Repository.RevokeSessionFamily(ctx, session.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
 func (s *service) Logout(c context.Context, req *LogoutRequest) error {
 	ctx, cancel := context.WithTimeout(c, s.timeout)
 	defer cancel()
@@ -145,3 +318,283 @@ func (s *service) Logout(c context.Context, req *LogoutRequest) error {
 
 	return nil
 }
+
+func (s *service) ListSessions(c context.Context, email, refreshToken string) ([]*SessionResponse, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	sessions, err := s.Repository.ListActiveSessions(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		res = append(res, &SessionResponse{
+			ID:        session.ID,
+			UserAgent: session.UserAgent,
+			IPAddress: session.IPAddress,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			Current:   refreshToken != "" && session.RefreshToken == refreshToken,
+		})
+	}
+	return res, nil
+}
+
+func (s *service) RevokeUserSession(c context.Context, email, id string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	revoked, err := s.Repository.RevokeSessionByID(ctx, email, id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions logs the user out of every device but the one holding
+// refreshToken.
+func (s *service) RevokeOtherSessions(c context.Context, email, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	var keepFamilyID string
+	if refreshToken != "" {
+		current, err := s.Repository.GetSessionByRefreshToken(ctx, refreshToken)
+		if err == nil && current.Email == email && !current.IsRevoked {
+			keepFamilyID = current.FamilyID
+		}
+	}
+
+	return s.Repository.RevokeOtherSessions(ctx, email, keepFamilyID)
+}
+
+func (s *service) UnlockAccount(c context.Context, req *UnlockAccountRequest) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	return s.Repository.ClearLoginAttempts(ctx, ThrottleScopeAccount, throttleKey(req.Email))
+}
+
+func (s *service) VerifyEmail(c context.Context, req *VerifyEmailRequest) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	id, ok := utils.VerifySignedValue(s.config.TokenSecret, req.Token)
+	if !ok {
+		return ErrInvalidEmailToken
+	}
+	t, err := s.Repository.UseEmailToken(ctx, id, PurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	return s.Repository.MarkEmailVerified(ctx, t.Email)
+}
+
+// ResendVerification mails a new link. It stays silent about unknown or
+// already verified addresses so it can't be used to probe accounts, the
+// lookup and the mail happen after it returns.
+func (s *service) ResendVerification(c context.Context, req *ResendVerificationRequest) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if err := s.throttleMail(ctx, req.Email, req.IPAddress); err != nil {
+		return err
+	}
+	s.sendLater("verification", func(ctx context.Context) error {
+		lookupCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+
+		u, err := s.Repository.GetUserByEmail(lookupCtx, req.Email)
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if u.VerifiedAt != nil {
+			return nil
+		}
+		return s.sendVerification(ctx, u.Email)
+	})
+	return nil
+}
+
+// throttleMail refuses a request for an email once the address or the IP
+// asked for too many, and counts it otherwise. Unknown addresses count too
+// so the answer says nothing about the account.
+func (s *service) throttleMail(ctx context.Context, email, ip string) error {
+	now := time.Now()
+	policy := s.config.MailThrottle
+
+	account, err := s.Repository.GetLoginAttempts(ctx, ThrottleScopeMailAccount, throttleKey(email))
+	if err != nil {
+		return err
+	}
+	retryAfter := policy.Account.RetryAfter(account, now)
+	if ip != "" {
+		attempts, err := s.Repository.GetLoginAttempts(ctx, ThrottleScopeMailIP, ip)
+		if err != nil {
+			return err
+		}
+		retryAfter = max(retryAfter, policy.IP.RetryAfter(attempts, now))
+	}
+	if retryAfter > 0 {
+		return ErrTooManyEmails.WithRetryAfter(retryAfter)
+	}
+
+	if _, err := s.Repository.RecordLoginFailure(ctx, ThrottleScopeMailAccount, throttleKey(email), policy.Account.ResetAfter); err != nil {
+		return err
+	}
+	if ip != "" {
+		if _, err := s.Repository.RecordLoginFailure(ctx, ThrottleScopeMailIP, ip, policy.IP.ResetAfter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendLater runs send in the background, so a request takes as long
+// whether or not an email goes out.
+func (s *service) sendLater(kind string, send func(ctx context.Context) error) {
+	go func() {
+		if err := send(context.Background()); err != nil {
+			log.Printf("error sending %s email: %v", kind, err)
+		}
+	}()
+}
+
+func (s *service) sendVerification(c context.Context, email string) error {
+	link, err := s.emailLink(c, email, PurposeVerifyEmail, "/verify-email", s.config.EmailTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c, s.mailTimeout)
+	defer cancel()
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Welcome! Confirm your email address by opening the link below.\n\n%s\n\nThe link expires in %s.\n",
+			link, s.config.EmailTokenTTL),
+	})
+}
+
+// ForgotPassword mails a reset link. Like ResendVerification it is
+// throttled and does not reveal whether the account exists.
+func (s *service) ForgotPassword(c context.Context, req *ForgotPasswordRequest) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	if err := s.throttleMail(ctx, req.Email, req.IPAddress); err != nil {
+		return err
+	}
+	s.sendLater("password reset", func(ctx context.Context) error {
+		return s.sendPasswordReset(ctx, req.Email)
+	})
+	return nil
+}
+
+func (s *service) sendPasswordReset(c context.Context, email string) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	u, err := s.Repository.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	link, err := s.emailLink(c, u.Email, PurposeResetPassword, "/reset-password", s.config.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	mailCtx, mailCancel := context.WithTimeout(c, s.mailTimeout)
+	defer mailCancel()
+	return s.mailer.Send(mailCtx, &mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account. If it was you, open the link below.\n\n%s\n\nThe link expires in %s. If you did not ask for this you can ignore this email.\n",
+			link, s.config.PasswordResetTTL),
+	})
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// logs the account out everywhere.
+func (s *service) ResetPassword(c context.Context, req *ResetPasswordRequest) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	id, ok := utils.VerifySignedValue(s.config.TokenSecret, req.Token)
+	if !ok {
+		return ErrInvalidEmailToken
+	}
+	t, err := s.Repository.UseEmailToken(ctx, id, PurposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.UpdatePassword(ctx, t.Email, hashedPassword); err != nil {
+		return err
+	}
+	if err := s.Repository.RevokeOtherSessions(ctx, t.Email, ""); err != nil {
+		return err
+	}
+	// Reading the reset email proves the address is theirs, and a locked out
+	// owner should be able to log in with the new password right away
+	if err := s.Repository.MarkEmailVerified(ctx, t.Email); err != nil {
+		return err
+	}
+	return s.Repository.ClearLoginAttempts(ctx, ThrottleScopeAccount, throttleKey(t.Email))
+}
+
+// ChangePassword replaces the password of a logged in user and revokes every
+// session but the one making the request.
+func (s *service) ChangePassword(c context.Context, req *ChangePasswordRequest) error {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	u, err := s.Repository.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		return err
+	}
+	if err := utils.CheckPassword(req.OldPassword, u.Password); err != nil {
+		return ErrWrongPassword.Wrap(err)
+	}
+
+	hashedPassword, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.Repository.UpdatePassword(ctx, u.Email, hashedPassword); err != nil {
+		return err
+	}
+	return s.RevokeOtherSessions(ctx, u.Email, req.RefreshToken)
+}
+
+// emailLink stores a new single-use token and returns the app link carrying
+// it.
+func (s *service) emailLink(c context.Context, email, purpose, path string, ttl time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(c, s.timeout)
+	defer cancel()
+
+	t, err := newEmailToken(email, purpose, ttl)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Repository.CreateEmailToken(ctx, t); err != nil {
+		return "", err
+	}
+	return s.config.AppURL + path + "?token=" + url.QueryEscape(utils.SignValue(s.config.TokenSecret, t.ID)), nil
+}
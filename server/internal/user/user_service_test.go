@@ -0,0 +1,333 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"server/internal/apperr"
+	"server/internal/mail"
+	"server/internal/token"
+)
+
+const testPassword = "Sup3r-secret!"
+
+func newTestService(t *testing.T, config Config) (Service, *mail.MemoryMailer) {
+	t.Helper()
+	config.TokenSecret = []byte("test-token-secret")
+	mailer := mail.NewMemoryMailer()
+	jwtMaker := token.NewJwtMaker("0123456789012345678901234567890123456789")
+	return NewService(NewMemoryRepository(), jwtMaker, mailer, config), mailer
+}
+
+func signUp(t *testing.T, s Service, email string) {
+	t.Helper()
+	_, err := s.CreateUser(context.Background(), &CreateUserRequest{Username: "alice", Email: email, Password: testPassword})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+}
+
+// waitForMail returns the sent messages once there are n of them. Some are
+// sent in the background.
+func waitForMail(t *testing.T, mailer *mail.MemoryMailer, n int) []*mail.Message {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		sent := mailer.Sent()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d emails, want %d", len(sent), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// mailToken returns the token of the link in an email.
+func mailToken(t *testing.T, m *mail.Message) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(m.Body, "?token=")
+	if !ok {
+		t.Fatalf("no link in %q", m.Body)
+	}
+	escaped, _, _ := strings.Cut(rest, "\n")
+	tok, err := url.QueryUnescape(escaped)
+	if err != nil {
+		t.Fatalf("bad token in link: %v", err)
+	}
+	return tok
+}
+
+func login(s Service, email, password string) (*LoginUserResponse, error) {
+	return s.Login(context.Background(), &LoginUserRequest{Email: email, Password: password, IPAddress: "192.0.2.1"})
+}
+
+func TestSignUpVerifyAndLogin(t *testing.T) {
+	s, mailer := newTestService(t, DefaultConfig())
+	signUp(t, s, "alice@example.com")
+
+	if _, err := login(s, "alice@example.com", testPassword); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("Login before verifying = %v, want ErrEmailNotVerified", err)
+	}
+
+	sent := waitForMail(t, mailer, 1)
+	if err := s.VerifyEmail(context.Background(), &VerifyEmailRequest{Token: mailToken(t, sent[0])}); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	res, err := login(s, "alice@example.com", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if res.AccessToken == "" || res.RefreshToken == "" {
+		t.Errorf("Login returned no tokens: %+v", res)
+	}
+}
+
+func TestVerifyEmailHandler(t *testing.T) {
+	s, _ := newTestService(t, DefaultConfig())
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"token":""}`, http.StatusUnprocessableEntity},
+		{`{"token":"   "}`, http.StatusUnprocessableEntity},
+		{`{}`, http.StatusUnprocessableEntity},
+		{`{"token":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		NewHandler(s).VerifyEmail(w, httptest.NewRequest(http.MethodPost, "/verify-email", strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("verifying with %s answered %d, want %d", tt.body, w.Code, tt.status)
+		}
+		if tt.status == http.StatusUnprocessableEntity && !strings.Contains(w.Body.String(), `"field":"token"`) {
+			t.Errorf("verifying with %s answered %s, want the token field reported", tt.body, w.Body)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	config := DefaultConfig()
+	config.Verification = VerificationOff
+	s, _ := newTestService(t, config)
+	signUp(t, s, "alice@example.com")
+
+	for i := 0; i <= config.Throttle.Account.FreeAttempts; i++ {
+		if _, err := login(s, "alice@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login %d with a wrong password = %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+	_, err := login(s, "Alice@example.com", testPassword)
+	var appErr *apperr.Error
+	if !errors.Is(err, ErrTooManyLoginAttempts) || !errors.As(err, &appErr) {
+		t.Fatalf("Login after too many failures = %v, want ErrTooManyLoginAttempts", err)
+	}
+	if delay := config.Throttle.Account.BaseDelay; appErr.RetryAfter <= 0 || appErr.RetryAfter > delay {
+		t.Errorf("RetryAfter = %v, want up to %v", appErr.RetryAfter, delay)
+	}
+
+	// Clients are told when to come back
+	body := strings.NewReader(`{"email":"alice@example.com","password":"` + testPassword + `"}`)
+	r := httptest.NewRequest(http.MethodPost, "/login", body)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	NewHandler(s).Login(w, r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("login answered %d with Retry-After %q, want 429 with 1", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// Unknown emails are throttled like wrong passwords, so they don't stand out.
+func TestLoginUnknownEmail(t *testing.T) {
+	config := DefaultConfig()
+	s, _ := newTestService(t, config)
+
+	for i := 0; i <= config.Throttle.Account.FreeAttempts; i++ {
+		if _, err := login(s, "nobody@example.com", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login %d with an unknown email = %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+	if _, err := login(s, "nobody@example.com", testPassword); !errors.Is(err, ErrTooManyLoginAttempts) {
+		t.Errorf("Login after too many failures = %v, want ErrTooManyLoginAttempts", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	config := DefaultConfig()
+	config.Verification = VerificationOff
+	config.Throttle.Account = ThrottlePolicy{
+		FreeAttempts:     1,
+		BaseDelay:        time.Millisecond,
+		MaxDelay:         time.Millisecond,
+		LockoutThreshold: 3,
+		LockoutDuration:  time.Hour,
+		ResetAfter:       2 * time.Hour,
+	}
+	s, _ := newTestService(t, config)
+	signUp(t, s, "alice@example.com")
+
+	for i := 0; i < config.Throttle.Account.LockoutThreshold; i++ {
+		if _, err := login(s, "alice@example.com", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Login %d with a wrong password = %v, want ErrInvalidCredentials", i+1, err)
+		}
+		// Wait out the delay, only the lockout is left
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	_, err := login(s, "alice@example.com", testPassword)
+	var appErr *apperr.Error
+	if !errors.Is(err, ErrTooManyLoginAttempts) || !errors.As(err, &appErr) {
+		t.Fatalf("Login of a locked account = %v, want ErrTooManyLoginAttempts", err)
+	}
+	if appErr.RetryAfter < 59*time.Minute || appErr.RetryAfter > time.Hour {
+		t.Errorf("RetryAfter = %v, want the lockout of an hour", appErr.RetryAfter)
+	}
+
+	if err := s.UnlockAccount(context.Background(), &UnlockAccountRequest{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("UnlockAccount: %v", err)
+	}
+	if _, err := login(s, "alice@example.com", testPassword); err != nil {
+		t.Errorf("Login after UnlockAccount: %v", err)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	config := DefaultConfig()
+	config.Verification = VerificationOff
+	s, _ := newTestService(t, config)
+	signUp(t, s, "alice@example.com")
+	ctx := context.Background()
+
+	first, err := login(s, "alice@example.com", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	second, err := s.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: first.RefreshToken})
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("RefreshToken did not rotate the token")
+	}
+	if !second.RefreshTokenExpiresAt.Equal(first.RefreshTokenExpiresAt) {
+		t.Errorf("rotated token expires at %v, want the login's %v", second.RefreshTokenExpiresAt, first.RefreshTokenExpiresAt)
+	}
+
+	// Replaying the rotated token logs the whole family out
+	if _, err := s.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: first.RefreshToken}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("RefreshToken with a rotated token = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := s.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: second.RefreshToken}); err == nil {
+		t.Fatal("RefreshToken succeeded after its family was revoked")
+	}
+}
+
+// Tokens revoked by logging out are just invalid, they don't log out the
+// user's other devices.
+func TestRefreshAfterLogout(t *testing.T) {
+	config := DefaultConfig()
+	config.Verification = VerificationOff
+	s, _ := newTestService(t, config)
+	signUp(t, s, "alice@example.com")
+	ctx := context.Background()
+
+	phone, err := login(s, "alice@example.com", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	laptop, err := login(s, "alice@example.com", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	tablet, err := login(s, "alice@example.com", testPassword)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if err := s.Logout(ctx, &LogoutRequest{RefreshToken: phone.RefreshToken}); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, err := s.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: phone.RefreshToken}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("RefreshToken after Logout = %v, want ErrInvalidRefreshToken", err)
+	}
+
+	if err := s.RevokeOtherSessions(ctx, "alice@example.com", laptop.RefreshToken); err != nil {
+		t.Fatalf("RevokeOtherSessions: %v", err)
+	}
+	if _, err := s.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: tablet.RefreshToken}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("RefreshToken of a device logged out = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := s.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: laptop.RefreshToken}); err != nil {
+		t.Errorf("RefreshToken of the remaining device: %v", err)
+	}
+}
+
+func TestResendVerificationThrottle(t *testing.T) {
+	config := DefaultConfig()
+	s, mailer := newTestService(t, config)
+	signUp(t, s, "alice@example.com")
+	ctx := context.Background()
+
+	resends := config.MailThrottle.Account.FreeAttempts + 1
+	for i := 0; i < resends; i++ {
+		if err := s.ResendVerification(ctx, &ResendVerificationRequest{Email: "alice@example.com"}); err != nil {
+			t.Fatalf("ResendVerification %d: %v", i+1, err)
+		}
+	}
+	err := s.ResendVerification(ctx, &ResendVerificationRequest{Email: "alice@example.com"})
+	if !errors.Is(err, ErrTooManyEmails) {
+		t.Fatalf("ResendVerification after too many = %v, want ErrTooManyEmails", err)
+	}
+	// Unknown addresses are answered the same and get nothing
+	if err := s.ResendVerification(ctx, &ResendVerificationRequest{Email: "nobody@example.com"}); err != nil {
+		t.Fatalf("ResendVerification of an unknown address: %v", err)
+	}
+
+	sent := waitForMail(t, mailer, 1+resends)
+	for _, m := range sent {
+		if m.To != "alice@example.com" {
+			t.Errorf("email sent to %s", m.To)
+		}
+	}
+}
+
+func TestForgotAndResetPassword(t *testing.T) {
+	config := DefaultConfig()
+	config.Verification = VerificationOff
+	s, mailer := newTestService(t, config)
+	signUp(t, s, "alice@example.com")
+	ctx := context.Background()
+
+	if err := s.ForgotPassword(ctx, &ForgotPasswordRequest{Email: "alice@example.com"}); err != nil {
+		t.Fatalf("ForgotPassword: %v", err)
+	}
+	var reset *mail.Message
+	for _, m := range waitForMail(t, mailer, 2) {
+		if m.Subject == "Reset your password" {
+			reset = m
+		}
+	}
+	if reset == nil {
+		t.Fatal("no password reset email")
+	}
+
+	req := &ResetPasswordRequest{Token: mailToken(t, reset), Password: "N3w-password!"}
+	if err := s.ResetPassword(ctx, req); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	if err := s.ResetPassword(ctx, req); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("ResetPassword with a used token = %v, want ErrInvalidEmailToken", err)
+	}
+	if _, err := login(s, "alice@example.com", testPassword); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login with the old password = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := login(s, "alice@example.com", "N3w-password!"); err != nil {
+		t.Errorf("Login with the new password: %v", err)
+	}
+}
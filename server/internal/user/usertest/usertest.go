@@ -0,0 +1,408 @@
+// Package usertest checks that a user.Repository behaves like the Postgres
+// one, so every store can be run against the same expectations.
+package usertest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"server/db"
+	"server/db/dbtest"
+	"server/internal/user"
+)
+
+// Run runs the conformance suite. newRepository must return an empty
+// repository for every call, see Memory, SQLite and Postgres.
+func Run(t *testing.T, newRepository func(t *testing.T) user.Repository) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r user.Repository)
+	}{
+		{"Users", testUsers},
+		{"Sessions", testSessions},
+		{"ListActiveSessions", testListActiveSessions},
+		{"RevokeSessions", testRevokeSessions},
+		{"LoginAttempts", testLoginAttempts},
+		{"EmailTokens", testEmailTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepository(t))
+		})
+	}
+}
+
+func Memory(t *testing.T) user.Repository {
+	return user.NewMemoryRepository()
+}
+
+// SQLite returns a repository on a new database in a temporary directory.
+func SQLite(t *testing.T) user.Repository {
+	conn, err := db.NewSQLiteDatabase(t.TempDir() + "/users.db")
+	if err != nil {
+		t.Fatalf("could not open sqlite: %v", err)
+	}
+	t.Cleanup(conn.Close)
+	if err := user.CreateSQLiteSchema(context.Background(), conn.GetDB()); err != nil {
+		t.Fatalf("could not create sqlite schema: %v", err)
+	}
+	return user.NewSQLiteRepository(conn.GetDB())
+}
+
+// Postgres returns repositories on pg, which must be migrated. Each one
+// works in a transaction that is rolled back when its test ends, so they
+// start empty as long as the tables do.
+func Postgres(pg *sql.DB) func(t *testing.T) user.Repository {
+	return func(t *testing.T) user.Repository {
+		return user.NewRepository(dbtest.Tx(t, pg))
+	}
+}
+
+// now is truncated to what Postgres timestamps keep.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func testUsers(t *testing.T, r user.Repository) {
+	ctx := context.Background()
+
+	a, err := r.CreateUser(ctx, &user.User{Username: "alice", Email: "alice@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	b, err := r.CreateUser(ctx, &user.User{Username: "bob", Email: "bob@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if a.ID == 0 || a.ID == b.ID {
+		t.Errorf("CreateUser ids = %d, %d, want distinct non-zero ids", a.ID, b.ID)
+	}
+
+	_, err = r.CreateUser(ctx, &user.User{Username: "alice2", Email: "Alice@Example.com", Password: "hash"})
+	if !errors.Is(err, user.ErrEmailTaken) {
+		t.Errorf("CreateUser with a taken email: err = %v, want ErrEmailTaken", err)
+	}
+
+	got, err := r.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if got.ID != a.ID || got.Username != "alice" || got.Password != "hash" || got.VerifiedAt != nil {
+		t.Errorf("GetUserByEmail = %+v, want alice unverified", got)
+	}
+	if _, err := r.GetUserByEmail(ctx, "nobody@example.com"); !errors.Is(err, user.ErrUserNotFound) {
+		t.Errorf("GetUserByEmail of an unknown email: err = %v, want ErrUserNotFound", err)
+	}
+
+	if err := r.MarkEmailVerified(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+	got, _ = r.GetUserByEmail(ctx, "alice@example.com")
+	if got.VerifiedAt == nil {
+		t.Fatal("MarkEmailVerified did not set verified_at")
+	}
+	verifiedAt := *got.VerifiedAt
+	if err := r.MarkEmailVerified(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+	got, _ = r.GetUserByEmail(ctx, "alice@example.com")
+	if !got.VerifiedAt.Equal(verifiedAt) {
+		t.Errorf("second MarkEmailVerified moved verified_at from %v to %v", verifiedAt, got.VerifiedAt)
+	}
+
+	if err := r.UpdatePassword(ctx, "alice@example.com", "new hash"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	got, _ = r.GetUserByEmail(ctx, "alice@example.com")
+	if got.Password != "new hash" {
+		t.Errorf("UpdatePassword: password = %q, want %q", got.Password, "new hash")
+	}
+	got, _ = r.GetUserByEmail(ctx, "bob@example.com")
+	if got.Password != "hash" || got.VerifiedAt != nil {
+		t.Errorf("updating alice changed bob: %+v", got)
+	}
+}
+
+func session(id, family, email string, created time.Time) *user.Session {
+	return &user.Session{
+		ID:           id,
+		FamilyID:     family,
+		Email:        email,
+		RefreshToken: "token-" + id,
+		CreatedAt:    created,
+		ExpiresAt:    created.Add(time.Hour),
+		UserAgent:    "agent",
+		IPAddress:    "127.0.0.1",
+	}
+}
+
+func createSessions(t *testing.T, r user.Repository, sessions ...*user.Session) {
+	t.Helper()
+	for _, s := range sessions {
+		if _, err := r.CreateSession(context.Background(), s); err != nil {
+			t.Fatalf("CreateSession(%s): %v", s.ID, err)
+		}
+	}
+}
+
+func revoked(t *testing.T, r user.Repository, id string) bool {
+	t.Helper()
+	s, err := r.GetSessionByRefreshToken(context.Background(), "token-"+id)
+	if err != nil {
+		t.Fatalf("GetSessionByRefreshToken(%s): %v", id, err)
+	}
+	return s.IsRevoked
+}
+
+func testSessions(t *testing.T, r user.Repository) {
+	ctx := context.Background()
+	created := now()
+
+	want := session("s1", "f1", "alice@example.com", created)
+	got, err := r.CreateSession(ctx, want)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if got.ID != want.ID || got.FamilyID != want.FamilyID || got.RefreshToken != want.RefreshToken ||
+		got.UserAgent != want.UserAgent || got.IPAddress != want.IPAddress || got.IsRevoked ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("CreateSession = %+v, want %+v", got, want)
+	}
+
+	got, err = r.GetSessionByRefreshToken(ctx, "token-s1")
+	if err != nil {
+		t.Fatalf("GetSessionByRefreshToken: %v", err)
+	}
+	if got.ID != "s1" || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Errorf("GetSessionByRefreshToken = %+v, want %+v", got, want)
+	}
+	if _, err := r.GetSessionByRefreshToken(ctx, "unknown"); !errors.Is(err, user.ErrSessionNotFound) {
+		t.Errorf("GetSessionByRefreshToken of an unknown token: err = %v, want ErrSessionNotFound", err)
+	}
+
+	ok, err := r.RotateSession(ctx, "s1", session("s1b", "f1", "alice@example.com", created))
+	if err != nil || !ok {
+		t.Fatalf("RotateSession = %v, %v, want true", ok, err)
+	}
+	ok, err = r.RotateSession(ctx, "s1", session("s1c", "f1", "alice@example.com", created))
+	if err != nil || ok {
+		t.Errorf("second RotateSession = %v, %v, want false", ok, err)
+	}
+	got, err = r.GetSessionByRefreshToken(ctx, "token-s1")
+	if err != nil {
+		t.Fatalf("GetSessionByRefreshToken: %v", err)
+	}
+	if !got.IsRevoked || got.ReplacedBy != "s1b" {
+		t.Errorf("rotated session = %+v, want it revoked and replaced by s1b", got)
+	}
+	if revoked(t, r, "s1b") {
+		t.Error("session rotated into is revoked")
+	}
+	if _, err := r.GetSessionByRefreshToken(ctx, "token-s1c"); !errors.Is(err, user.ErrSessionNotFound) {
+		t.Errorf("failed rotation stored its session: err = %v, want ErrSessionNotFound", err)
+	}
+
+	createSessions(t, r, session("s2", "f2", "alice@example.com", created))
+	if err := r.RevokeSession(ctx, "token-s2"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	got, err = r.GetSessionByRefreshToken(ctx, "token-s2")
+	if err != nil {
+		t.Fatalf("GetSessionByRefreshToken: %v", err)
+	}
+	if !got.IsRevoked || got.ReplacedBy != "" {
+		t.Errorf("revoked session = %+v, want it revoked without a replacement", got)
+	}
+
+	if err := r.DeleteSession(ctx, "token-s2"); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if _, err := r.GetSessionByRefreshToken(ctx, "token-s2"); !errors.Is(err, user.ErrSessionNotFound) {
+		t.Errorf("GetSessionByRefreshToken after DeleteSession: err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func testListActiveSessions(t *testing.T, r user.Repository) {
+	ctx := context.Background()
+	created := now()
+
+	expired := session("expired", "f4", "alice@example.com", created.Add(-2*time.Hour))
+	createSessions(t, r,
+		session("old", "f1", "alice@example.com", created.Add(-time.Minute)),
+		session("new", "f2", "alice@example.com", created),
+		session("revoked", "f3", "alice@example.com", created),
+		expired,
+		session("bob", "f5", "bob@example.com", created),
+	)
+	if err := r.RevokeSession(ctx, "token-revoked"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+
+	sessions, err := r.ListActiveSessions(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("ListActiveSessions: %v", err)
+	}
+	var ids []string
+	for _, s := range sessions {
+		ids = append(ids, s.ID)
+	}
+	if len(ids) != 2 || ids[0] != "new" || ids[1] != "old" {
+		t.Errorf("ListActiveSessions = %v, want [new old]", ids)
+	}
+
+	sessions, err = r.ListActiveSessions(ctx, "nobody@example.com")
+	if err != nil || sessions == nil || len(sessions) != 0 {
+		t.Errorf("ListActiveSessions of an unknown email = %v, %v, want an empty list", sessions, err)
+	}
+}
+
+func testRevokeSessions(t *testing.T, r user.Repository) {
+	ctx := context.Background()
+	created := now()
+
+	createSessions(t, r,
+		session("a1", "fa", "alice@example.com", created),
+		session("a2", "fa", "alice@example.com", created),
+		session("b1", "fb", "alice@example.com", created),
+		session("c1", "fc", "alice@example.com", created),
+		session("d1", "fd", "bob@example.com", created),
+		session("d2", "fd", "bob@example.com", created),
+	)
+
+	ok, err := r.RevokeSessionByID(ctx, "alice@example.com", "d1")
+	if err != nil || ok {
+		t.Errorf("RevokeSessionByID of another user's session = %v, %v, want false", ok, err)
+	}
+	ok, err = r.RevokeSessionByID(ctx, "alice@example.com", "missing")
+	if err != nil || ok {
+		t.Errorf("RevokeSessionByID of an unknown session = %v, %v, want false", ok, err)
+	}
+	ok, err = r.RevokeSessionByID(ctx, "alice@example.com", "a2")
+	if err != nil || !ok {
+		t.Fatalf("RevokeSessionByID = %v, %v, want true", ok, err)
+	}
+	if !revoked(t, r, "a1") || !revoked(t, r, "a2") {
+		t.Error("RevokeSessionByID did not revoke the whole family")
+	}
+	if revoked(t, r, "b1") || revoked(t, r, "d1") {
+		t.Error("RevokeSessionByID revoked another family")
+	}
+
+	if err := r.RevokeOtherSessions(ctx, "alice@example.com", "fb"); err != nil {
+		t.Fatalf("RevokeOtherSessions: %v", err)
+	}
+	if revoked(t, r, "b1") {
+		t.Error("RevokeOtherSessions revoked the kept family")
+	}
+	if !revoked(t, r, "c1") {
+		t.Error("RevokeOtherSessions did not revoke another family")
+	}
+	if revoked(t, r, "d1") {
+		t.Error("RevokeOtherSessions revoked another user's session")
+	}
+
+	if err := r.RevokeOtherSessions(ctx, "alice@example.com", ""); err != nil {
+		t.Fatalf("RevokeOtherSessions: %v", err)
+	}
+	if !revoked(t, r, "b1") {
+		t.Error("RevokeOtherSessions with no family to keep left a session")
+	}
+
+	if err := r.RevokeSessionFamily(ctx, "fd"); err != nil {
+		t.Fatalf("RevokeSessionFamily: %v", err)
+	}
+	if !revoked(t, r, "d1") || !revoked(t, r, "d2") {
+		t.Error("RevokeSessionFamily did not revoke the family")
+	}
+}
+
+func testLoginAttempts(t *testing.T, r user.Repository) {
+	ctx := context.Background()
+
+	a, err := r.GetLoginAttempts(ctx, "email", "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetLoginAttempts: %v", err)
+	}
+	if a.Scope != "email" || a.Key != "alice@example.com" || a.Failures != 0 {
+		t.Errorf("GetLoginAttempts before any failure = %+v, want no failures", a)
+	}
+
+	for want := 1; want <= 3; want++ {
+		a, err = r.RecordLoginFailure(ctx, "email", "alice@example.com", time.Hour)
+		if err != nil {
+			t.Fatalf("RecordLoginFailure: %v", err)
+		}
+		if a.Failures != want {
+			t.Errorf("RecordLoginFailure failures = %d, want %d", a.Failures, want)
+		}
+	}
+	if time.Since(a.LastFailureAt) > time.Minute {
+		t.Errorf("RecordLoginFailure last failure = %v, want about now", a.LastFailureAt)
+	}
+
+	a, _ = r.GetLoginAttempts(ctx, "email", "alice@example.com")
+	if a.Failures != 3 {
+		t.Errorf("GetLoginAttempts failures = %d, want 3", a.Failures)
+	}
+	a, _ = r.GetLoginAttempts(ctx, "ip", "alice@example.com")
+	if a.Failures != 0 {
+		t.Errorf("GetLoginAttempts of another scope: failures = %d, want 0", a.Failures)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	a, err = r.RecordLoginFailure(ctx, "email", "alice@example.com", time.Millisecond)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure: %v", err)
+	}
+	if a.Failures != 1 {
+		t.Errorf("RecordLoginFailure after resetAfter: failures = %d, want 1", a.Failures)
+	}
+
+	if err := r.ClearLoginAttempts(ctx, "email", "alice@example.com"); err != nil {
+		t.Fatalf("ClearLoginAttempts: %v", err)
+	}
+	a, _ = r.GetLoginAttempts(ctx, "email", "alice@example.com")
+	if a.Failures != 0 {
+		t.Errorf("GetLoginAttempts after ClearLoginAttempts: failures = %d, want 0", a.Failures)
+	}
+}
+
+func testEmailTokens(t *testing.T, r user.Repository) {
+	ctx := context.Background()
+	created := now()
+
+	tokens := []*user.EmailToken{
+		{ID: "valid", Email: "alice@example.com", Purpose: user.PurposeVerifyEmail, CreatedAt: created, ExpiresAt: created.Add(time.Hour)},
+		{ID: "expired", Email: "alice@example.com", Purpose: user.PurposeVerifyEmail, CreatedAt: created.Add(-time.Hour), ExpiresAt: created.Add(-time.Second)},
+	}
+	for _, token := range tokens {
+		if err := r.CreateEmailToken(ctx, token); err != nil {
+			t.Fatalf("CreateEmailToken(%s): %v", token.ID, err)
+		}
+	}
+
+	if _, err := r.UseEmailToken(ctx, "valid", user.PurposeResetPassword); !errors.Is(err, user.ErrInvalidEmailToken) {
+		t.Errorf("UseEmailToken for another purpose: err = %v, want ErrInvalidEmailToken", err)
+	}
+
+	got, err := r.UseEmailToken(ctx, "valid", user.PurposeVerifyEmail)
+	if err != nil {
+		t.Fatalf("UseEmailToken: %v", err)
+	}
+	if got.Email != "alice@example.com" || got.UsedAt == nil || !got.ExpiresAt.Equal(tokens[0].ExpiresAt) {
+		t.Errorf("UseEmailToken = %+v, want the used token", got)
+	}
+
+	if _, err := r.UseEmailToken(ctx, "valid", user.PurposeVerifyEmail); !errors.Is(err, user.ErrInvalidEmailToken) {
+		t.Errorf("second UseEmailToken: err = %v, want ErrInvalidEmailToken", err)
+	}
+	if _, err := r.UseEmailToken(ctx, "expired", user.PurposeVerifyEmail); !errors.Is(err, user.ErrInvalidEmailToken) {
+		t.Errorf("UseEmailToken of an expired token: err = %v, want ErrInvalidEmailToken", err)
+	}
+	if _, err := r.UseEmailToken(ctx, "missing", user.PurposeVerifyEmail); !errors.Is(err, user.ErrInvalidEmailToken) {
+		t.Errorf("UseEmailToken of an unknown token: err = %v, want ErrInvalidEmailToken", err)
+	}
+}
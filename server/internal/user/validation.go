@@ -0,0 +1,94 @@
+package user
+
+import (
+	"server/internal/validate"
+	"strings"
+)
+
+var (
+	usernameRules = []validate.Rule{
+		validate.Required(),
+		validate.MinLength(3),
+		validate.MaxLength(32),
+		validate.Charset("letters, digits, '-', '_' and '.'", validate.Username),
+	}
+	emailRules = []validate.Rule{
+		validate.Required(),
+		validate.MaxLength(254),
+		validate.Email(),
+	}
+	// bcrypt ignores everything past 72 bytes
+	passwordRules = []validate.Rule{
+		validate.Required(),
+		validate.MinLength(8),
+		validate.MaxBytes(72),
+		validate.Password(),
+	}
+)
+
+// Validate normalizes the request in place and checks every field.
+func (req *CreateUserRequest) Validate() error {
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = validate.NormalizeEmail(req.Email)
+
+	var v validate.Validator
+	v.Field("username", req.Username, usernameRules...)
+	v.Field("email", req.Email, emailRules...)
+	v.Field("password", req.Password, passwordRules...)
+	return v.Err()
+}
+
+// Validate does not apply the password rules, accounts created before they
+// existed must still be able to log in.
+func (req *LoginUserRequest) Validate() error {
+	req.Email = validate.NormalizeEmail(req.Email)
+
+	var v validate.Validator
+	v.Field("email", req.Email, emailRules...)
+	v.Field("password", req.Password, validate.Required(), validate.MaxBytes(72))
+	return v.Err()
+}
+
+func (req *ResendVerificationRequest) Validate() error {
+	req.Email = validate.NormalizeEmail(req.Email)
+
+	var v validate.Validator
+	v.Field("email", req.Email, emailRules...)
+	return v.Err()
+}
+
+func (req *VerifyEmailRequest) Validate() error {
+	var v validate.Validator
+	v.Field("token", req.Token, validate.Required())
+	return v.Err()
+}
+
+func (req *ForgotPasswordRequest) Validate() error {
+	req.Email = validate.NormalizeEmail(req.Email)
+
+	var v validate.Validator
+	v.Field("email", req.Email, emailRules...)
+	return v.Err()
+}
+
+func (req *ResetPasswordRequest) Validate() error {
+	var v validate.Validator
+	v.Field("token", req.Token, validate.Required())
+	v.Field("password", req.Password, passwordRules...)
+	return v.Err()
+}
+
+func (req *ChangePasswordRequest) Validate() error {
+	var v validate.Validator
+	v.Field("old_password", req.OldPassword, validate.Required())
+	v.Field("new_password", req.NewPassword, passwordRules...)
+	return v.Err()
+}
+
+func (req *UnlockAccountRequest) Validate() error {
+	req.Email = validate.NormalizeEmail(req.Email)
+
+	var v validate.Validator
+	v.Field("email", req.Email, emailRules...)
+	return v.Err()
+}
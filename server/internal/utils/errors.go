@@ -2,17 +2,73 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"server/internal/apperr"
+	"server/internal/validate"
+	"strconv"
+	"strings"
 )
 
 type errorResponse struct {
-	Error string `json:"error"`
+	Error  string          `json:"error"`
+	Code   string          `json:"code"`
+	Fields validate.Errors `json:"fields,omitempty"`
 }
 
+// WriteError answers with err translated into a status and a stable code.
+// Typed errors from apperr and validate decide the status and message
+// themselves, anything else is answered with status and clientMsg so
+// internal details never reach the client.
 func WriteError(w http.ResponseWriter, r *http.Request, status int, clientMsg string, err error) {
+	res := errorResponse{Error: clientMsg, Code: statusCode(status)}
+
+	var fields validate.Errors
+	var appErr *apperr.Error
+	switch {
+	case errors.As(err, &fields):
+		status = http.StatusUnprocessableEntity
+		res = errorResponse{Error: "validation failed", Code: "validation_failed", Fields: fields}
+	case errors.As(err, &appErr) && appErr.Kind != apperr.Internal:
+		status = kindStatus(appErr.Kind)
+		res.Error = appErr.Message
+		res.Code = appErr.Code
+		if appErr.RetryAfter > 0 {
+			seconds := int(math.Ceil(appErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+	}
+
 	log.Printf("request %s %s -> %d: %v", r.Method, r.URL.Path, status, err)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(errorResponse{Error: clientMsg})
+	json.NewEncoder(w).Encode(res)
+}
+
+func kindStatus(kind apperr.Kind) int {
+	switch kind {
+	case apperr.NotFound:
+		return http.StatusNotFound
+	case apperr.Conflict:
+		return http.StatusConflict
+	case apperr.Unauthorized:
+		return http.StatusUnauthorized
+	case apperr.Forbidden:
+		return http.StatusForbidden
+	case apperr.Validation:
+		return http.StatusUnprocessableEntity
+	case apperr.RateLimited:
+		return http.StatusTooManyRequests
+	case apperr.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// statusCode is the code of untyped errors, e.g. "bad_request".
+func statusCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
 }
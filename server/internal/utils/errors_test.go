@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"server/internal/apperr"
+	"server/internal/validate"
+)
+
+// writeError runs WriteError and decodes what it answered.
+func writeError(t *testing.T, status int, msg string, err error) (*httptest.ResponseRecorder, errorResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	WriteError(rec, httptest.NewRequest(http.MethodGet, "/rooms", nil), status, msg, err)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var res errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+	return rec, res
+}
+
+func TestWriteErrorKinds(t *testing.T) {
+	tests := []struct {
+		kind   apperr.Kind
+		status int
+	}{
+		{apperr.NotFound, http.StatusNotFound},
+		{apperr.Conflict, http.StatusConflict},
+		{apperr.Unauthorized, http.StatusUnauthorized},
+		{apperr.Forbidden, http.StatusForbidden},
+		{apperr.Validation, http.StatusUnprocessableEntity},
+		{apperr.RateLimited, http.StatusTooManyRequests},
+		{apperr.Unavailable, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		sentinel := apperr.New(tt.kind, "some_code", "safe message")
+		// Wrapped in other errors on the way up, the kind still decides
+		for _, err := range []error{sentinel, sentinel.Wrap(errors.New("cause")), fmt.Errorf("service: %w", sentinel)} {
+			rec, res := writeError(t, http.StatusInternalServerError, "fallback", err)
+			if rec.Code != tt.status || res.Code != "some_code" || res.Error != "safe message" {
+				t.Errorf("%v error %v: got %d %+v, want %d with its code and message", tt.kind, err, rec.Code, res, tt.status)
+			}
+		}
+	}
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		err    error
+		code   string
+	}{
+		{"plain error", http.StatusInternalServerError, errors.New("pq: connection refused"), "internal_server_error"},
+		{"no error", http.StatusBadRequest, nil, "bad_request"},
+		{"internal kind", http.StatusInternalServerError, apperr.New(apperr.Internal, "db_down", "database is down"), "internal_server_error"},
+		{"handler status", http.StatusServiceUnavailable, errors.New("closing"), "service_unavailable"},
+	}
+	for _, tt := range tests {
+		rec, res := writeError(t, tt.status, "could not load room", tt.err)
+		if rec.Code != tt.status || res.Error != "could not load room" || res.Code != tt.code || res.Fields != nil {
+			t.Errorf("%s: got %d %+v, want %d with the client message and %s", tt.name, rec.Code, res, tt.status, tt.code)
+		}
+	}
+}
+
+func TestWriteErrorValidation(t *testing.T) {
+	var v validate.Validator
+	v.Field("name", "", validate.Required())
+	v.Field("topic", "way too long", validate.MaxLength(3))
+
+	rec, res := writeError(t, http.StatusBadRequest, "invalid request", fmt.Errorf("creating room: %w", v.Err()))
+	want := validate.Errors{{Field: "name", Message: "is required"}, {Field: "topic", Message: "must be at most 3 characters"}}
+	if rec.Code != http.StatusUnprocessableEntity || res.Code != "validation_failed" || !reflect.DeepEqual(res.Fields, want) {
+		t.Errorf("got %d %+v, want 422 listing %+v", rec.Code, res, want)
+	}
+}
+
+func TestWriteErrorRetryAfter(t *testing.T) {
+	limited := apperr.New(apperr.RateLimited, "too_many_attempts", "too many attempts")
+	tests := []struct {
+		retryAfter time.Duration
+		header     string
+	}{
+		{0, ""},
+		{time.Second, "1"},
+		// Rounded up so clients never come back too early
+		{1500 * time.Millisecond, "2"},
+		{time.Millisecond, "1"},
+		{2 * time.Minute, "120"},
+	}
+	for _, tt := range tests {
+		rec, _ := writeError(t, http.StatusInternalServerError, "", limited.WithRetryAfter(tt.retryAfter))
+		if got := rec.Header().Get("Retry-After"); rec.Code != http.StatusTooManyRequests || got != tt.header {
+			t.Errorf("retrying after %v: got %d with Retry-After %q, want 429 with %q", tt.retryAfter, rec.Code, got, tt.header)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"strings"
 )
 
 func GenerateSecureToken(length int) (string, error) {
@@ -12,3 +15,28 @@ func GenerateSecureToken(length int) (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
+
+// SignValue appends an HMAC of value so it can be handed to clients and
+// checked without a lookup when it comes back.
+func SignValue(secret []byte, value string) string {
+	return value + "." + signature(secret, value)
+}
+
+// VerifySignedValue returns the value from a string made by SignValue.
+func VerifySignedValue(secret []byte, signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+	value, sig := signed[:i], signed[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, value))) {
+		return "", false
+	}
+	return value, true
+}
+
+func signature(secret []byte, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,174 @@
+// Package validate checks decoded request payloads against declarative
+// rules and reports every failing field at once.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError describes why one field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is the list of failing fields returned by Validator.Err.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Rule checks a single value and returns a message when it is invalid.
+type Rule func(value string) (message string, ok bool)
+
+// Validator collects field errors. The zero value is ready to use.
+type Validator struct {
+	errs Errors
+}
+
+// Field runs the rules against value in order and records the first one
+// that fails, so a field is reported at most once.
+func (v *Validator) Field(name, value string, rules ...Rule) {
+	for _, rule := range rules {
+		if msg, ok := rule(value); !ok {
+			v.errs = append(v.errs, FieldError{Field: name, Message: msg})
+			return
+		}
+	}
+}
+
+// Optional is like Field but skips empty values.
+func (v *Validator) Optional(name, value string, rules ...Rule) {
+	if value == "" {
+		return
+	}
+	v.Field(name, value, rules...)
+}
+
+// Check records message for the field unless ok, for values that aren't
+// strings.
+func (v *Validator) Check(name string, ok bool, message string) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: name, Message: message})
+	}
+}
+
+// Err returns the collected Errors, or nil if every field passed.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+func Required() Rule {
+	return func(value string) (string, bool) {
+		return "is required", strings.TrimSpace(value) != ""
+	}
+}
+
+// MinLength and MaxLength count characters, not bytes.
+func MinLength(n int) Rule {
+	return func(value string) (string, bool) {
+		return fmt.Sprintf("must be at least %d characters", n), utf8.RuneCountInString(value) >= n
+	}
+}
+
+func MaxLength(n int) Rule {
+	return func(value string) (string, bool) {
+		return fmt.Sprintf("must be at most %d characters", n), utf8.RuneCountInString(value) <= n
+	}
+}
+
+// MaxBytes limits the encoded size, bcrypt for instance ignores anything
+// past 72 bytes.
+func MaxBytes(n int) Rule {
+	return func(value string) (string, bool) {
+		return fmt.Sprintf("must be at most %d bytes", n), len(value) <= n
+	}
+}
+
+// Duration accepts durations such as "30s" or "1h30m" of at least min.
+func Duration(min time.Duration) Rule {
+	return func(value string) (string, bool) {
+		d, err := time.ParseDuration(value)
+		return fmt.Sprintf("must be a duration such as 30s or 10m, at least %s", min), err == nil && d >= min
+	}
+}
+
+// Email accepts a bare address such as "jane@example.com", without a
+// display name.
+func Email() Rule {
+	return func(value string) (string, bool) {
+		addr, err := mail.ParseAddress(value)
+		return "must be a valid email address", err == nil && addr.Address == value && addr.Name == ""
+	}
+}
+
+// Charset only allows runes accepted by allowed. description completes the
+// sentence "may only contain ...".
+func Charset(description string, allowed func(r rune) bool) Rule {
+	return func(value string) (string, bool) {
+		for _, r := range value {
+			if !allowed(r) {
+				return "may only contain " + description, false
+			}
+		}
+		return "", true
+	}
+}
+
+// OneOf only allows the listed values.
+func OneOf(values ...string) Rule {
+	return func(value string) (string, bool) {
+		for _, v := range values {
+			if value == v {
+				return "", true
+			}
+		}
+		return "must be one of " + strings.Join(values, ", "), false
+	}
+}
+
+// Password requires a letter and a digit or symbol on top of the length
+// rules, which rules out the most common dictionary passwords.
+func Password() Rule {
+	return func(value string) (string, bool) {
+		var letter, other bool
+		for _, r := range value {
+			switch {
+			case unicode.IsLetter(r):
+				letter = true
+			case unicode.IsDigit(r), unicode.IsPunct(r), unicode.IsSymbol(r):
+				other = true
+			}
+		}
+		return "must contain a letter and a digit or symbol", letter && other
+	}
+}
+
+// Slug allows lowercase letters, digits, '-' and '_'.
+func Slug(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+}
+
+// Username allows letters, digits, '-', '_' and '.'.
+func Username(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.'
+}
+
+// NormalizeEmail trims and lowercases an address. Emails are compared case
+// insensitively everywhere, so every address is stored normalized.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
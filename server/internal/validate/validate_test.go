@@ -0,0 +1,169 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rule  Rule
+		value string
+		ok    bool
+	}{
+		{"required", Required(), "x", true},
+		{"required empty", Required(), "", false},
+		{"required blank", Required(), " \t\n", false},
+
+		{"min length", MinLength(3), "abc", true},
+		{"min length short", MinLength(3), "ab", false},
+		{"min length counts runes", MinLength(3), "žžž", true},
+		{"max length", MaxLength(3), "abc", true},
+		{"max length long", MaxLength(3), "abcd", false},
+		{"max length counts runes", MaxLength(3), "žžž", true},
+		{"max bytes", MaxBytes(4), "abcd", true},
+		{"max bytes counts bytes", MaxBytes(4), "žžž", false},
+
+		{"duration", Duration(time.Second), "1m30s", true},
+		{"duration at the minimum", Duration(time.Second), "1s", true},
+		{"duration too short", Duration(time.Second), "500ms", false},
+		{"duration zero allowed", Duration(0), "0s", true},
+		{"duration negative", Duration(0), "-1s", false},
+		{"duration without unit", Duration(0), "30", false},
+		{"duration garbage", Duration(0), "soon", false},
+
+		{"email", Email(), "jane@example.com", true},
+		{"email subaddress", Email(), "jane+chat@example.co.uk", true},
+		{"email without at", Email(), "jane.example.com", false},
+		{"email without domain", Email(), "jane@", false},
+		{"email with display name", Email(), "Jane <jane@example.com>", false},
+		{"email in brackets", Email(), "<jane@example.com>", false},
+		{"email with spaces", Email(), " jane@example.com ", false},
+		{"email empty", Email(), "", false},
+
+		{"charset", Charset("slugs", Slug), "general-chat_2", true},
+		{"charset uppercase", Charset("slugs", Slug), "General", false},
+		{"charset space", Charset("slugs", Slug), "general chat", false},
+		{"charset empty", Charset("slugs", Slug), "", true},
+
+		{"one of", OneOf("public", "private"), "private", true},
+		{"one of other", OneOf("public", "private"), "secret", false},
+		{"one of is case sensitive", OneOf("public", "private"), "Public", false},
+
+		{"password letter and digit", Password(), "password1", true},
+		{"password letter and symbol", Password(), "pass-word", true},
+		{"password non-ASCII letter", Password(), "ßecret!!", true},
+		{"password letters only", Password(), "password", false},
+		{"password digits only", Password(), "12345678", false},
+		{"password spaces do not count", Password(), "pass word", false},
+	}
+	for _, tt := range tests {
+		msg, ok := tt.rule(tt.value)
+		if ok != tt.ok {
+			t.Errorf("%s: rule(%q) = %v, want %v", tt.name, tt.value, ok, tt.ok)
+		}
+		if !ok && msg == "" {
+			t.Errorf("%s: rule(%q) failed without a message", tt.name, tt.value)
+		}
+	}
+}
+
+func TestRuleMessages(t *testing.T) {
+	tests := []struct {
+		rule  Rule
+		value string
+		want  string
+	}{
+		{Required(), "", "is required"},
+		{MinLength(8), "short", "must be at least 8 characters"},
+		{MaxLength(2), "long", "must be at most 2 characters"},
+		{MaxBytes(72), strings.Repeat("x", 73), "must be at most 72 bytes"},
+		{Duration(time.Second), "0s", "must be a duration such as 30s or 10m, at least 1s"},
+		{Charset("letters", func(r rune) bool { return r >= 'a' && r <= 'z' }), "a1", "may only contain letters"},
+		{OneOf("on", "off"), "maybe", "must be one of on, off"},
+	}
+	for _, tt := range tests {
+		if msg, _ := tt.rule(tt.value); msg != tt.want {
+			t.Errorf("rule(%q) = %q, want %q", tt.value, msg, tt.want)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	var v Validator
+	if err := v.Err(); err != nil {
+		t.Fatalf("Err of a new validator = %v, want nil", err)
+	}
+
+	v.Field("name", "ok", Required())
+	// Only the first failing rule of a field is reported
+	v.Field("username", "", Required(), MinLength(3))
+	v.Optional("topic", "", MinLength(3))
+	v.Optional("bio", "x", MinLength(3))
+	v.Check("limit", false, "must be positive")
+	v.Check("offset", true, "must be positive")
+
+	err := fmt.Errorf("creating user: %w", v.Err())
+	var got Errors
+	if !errors.As(err, &got) {
+		t.Fatalf("Err = %v, want Errors", err)
+	}
+	want := Errors{
+		{Field: "username", Message: "is required"},
+		{Field: "bio", Message: "must be at least 3 characters"},
+		{Field: "limit", Message: "must be positive"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Err = %+v, want %+v", got, want)
+	}
+	wantMsg := "validation failed: username: is required; bio: must be at least 3 characters; limit: must be positive"
+	if got.Error() != wantMsg {
+		t.Errorf("Error() = %q, want %q", got.Error(), wantMsg)
+	}
+}
+
+func TestCharsets(t *testing.T) {
+	tests := []struct {
+		r        rune
+		slug     bool
+		username bool
+	}{
+		{'a', true, true},
+		{'0', true, true},
+		{'-', true, true},
+		{'_', true, true},
+		{'.', false, true},
+		{'A', false, true},
+		{'é', false, true},
+		{' ', false, false},
+		{'/', false, false},
+		{'@', false, false},
+	}
+	for _, tt := range tests {
+		if got := Slug(tt.r); got != tt.slug {
+			t.Errorf("Slug(%q) = %v, want %v", tt.r, got, tt.slug)
+		}
+		if got := Username(tt.r); got != tt.username {
+			t.Errorf("Username(%q) = %v, want %v", tt.r, got, tt.username)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := map[string]string{
+		"jane@example.com":      "jane@example.com",
+		"  Jane@Example.COM \n": "jane@example.com",
+		"JANE+Chat@example.com": "jane+chat@example.com",
+		"":                      "",
+	}
+	for in, want := range tests {
+		if got := NormalizeEmail(in); got != want {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"time"
+)
+
+// deliveryKey identifies a message by its sender and the ID the client
+// gave it.
+type deliveryKey struct {
+	userID   string
+	clientID string
+}
+
+// delivery is a message sent with a client ID. ack is the ack or nack
+// answering it, nil until the persister is done with the message.
+type delivery struct {
+	key  deliveryKey
+	seen time.Time
+	ack  *Message
+}
+
+// deliveries remembers messages sent with a client ID for window, so a
+// message retried, even over a new connection, is answered again instead of
+// being delivered twice. The hub guards it with its mutex.
+type deliveries struct {
+	window time.Duration
+	byKey  map[deliveryKey]*delivery
+	// order holds the deliveries oldest first so expired ones are cheap
+	// to find
+	order []*delivery
+}
+
+func newDeliveries(window time.Duration) *deliveries {
+	return &deliveries{
+		window: window,
+		byKey:  make(map[deliveryKey]*delivery),
+	}
+}
+
+func (d *deliveries) get(key deliveryKey, now time.Time) *delivery {
+	d.expire(now)
+	return d.byKey[key]
+}
+
+func (d *deliveries) add(key deliveryKey, now time.Time) {
+	dl := &delivery{key: key, seen: now}
+	d.byKey[key] = dl
+	d.order = append(d.order, dl)
+}
+
+// forget drops a delivery so a retry of the message is handled as new.
+func (d *deliveries) forget(key deliveryKey) {
+	delete(d.byKey, key)
+}
+
+func (d *deliveries) expire(now time.Time) {
+	for len(d.order) > 0 && now.Sub(d.order[0].seen) > d.window {
+		// The key may have been forgotten and sent again since
+		if d.byKey[d.order[0].key] == d.order[0] {
+			delete(d.byKey, d.order[0].key)
+		}
+		d.order[0] = nil
+		d.order = d.order[1:]
+	}
+}
+
+// ackFrame answers the message m, now stored as id with sequence number
+// seq.
+func ackFrame(m *Message, id, seq int64, createdAt time.Time) *Message {
+	return &Message{
+		Type:      EventAck,
+		ID:        m.ref,
+		RoomID:    m.RoomID,
+		UserID:    m.UserID,
+		CreatedAt: time.Now(),
+		Payload:   &AckPayload{MessageID: id, Seq: seq, CreatedAt: createdAt},
+	}
+}
+
+// nackFrame tells the sender of m why it was not delivered or stored.
+func nackFrame(m *Message, err error) *Message {
+	nack := errorFrame(m.ref, err)
+	nack.Type = EventNack
+	nack.RoomID = m.RoomID
+	nack.UserID = m.UserID
+	return nack
+}
+
+// acked records the answer to a message and sends it to its sender's
+// current connection, which may not be the one the message came from. A
+// nack is not remembered, so the sender may retry the message.
+func (h *Hub) acked(ack *Message) {
+	key := deliveryKey{ack.UserID, ack.ID}
+	if ack.Type == EventNack {
+		h.deliveries.forget(key)
+	} else if dl := h.deliveries.get(key, time.Now()); dl != nil {
+		dl.ack = ack
+	}
+	room, ok := h.rooms[ack.RoomID]
+	if !ok {
+		return
+	}
+	if cl, ok := room.Clients[ack.UserID]; ok {
+		h.send(room, cl, ack)
+	}
+}
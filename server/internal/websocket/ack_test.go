@@ -0,0 +1,129 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeliveries(t *testing.T) {
+	d := newDeliveries(time.Minute)
+	start := time.Now()
+	first := deliveryKey{"1", "a"}
+	second := deliveryKey{"1", "b"}
+
+	d.add(first, start)
+	d.add(second, start.Add(30*time.Second))
+	if d.get(first, start.Add(time.Minute)) == nil {
+		t.Error("delivery forgotten at the end of its window")
+	}
+	if d.get(deliveryKey{"2", "a"}, start) != nil {
+		t.Error("another user's message with the same ID is a known delivery")
+	}
+	if d.get(first, start.Add(61*time.Second)) != nil {
+		t.Error("delivery still known after its window")
+	}
+	if d.get(second, start.Add(61*time.Second)) == nil {
+		t.Error("newer delivery expired with an older one")
+	}
+
+	// A message sent again after a nack starts a window of its own
+	d.forget(second)
+	d.add(second, start.Add(80*time.Second))
+	if d.get(second, start.Add(100*time.Second)) == nil {
+		t.Error("delivery sent again expired with the forgotten one")
+	}
+}
+
+func TestAckFrames(t *testing.T) {
+	m := &Message{Type: EventMessage, RoomID: "lobby", UserID: "1", ref: "m1"}
+	storedAt := time.Now()
+
+	ack := ackFrame(m, 42, 7, storedAt)
+	payload, ok := ack.Payload.(*AckPayload)
+	if ack.Type != EventAck || ack.ID != "m1" || ack.UserID != "1" || !ok {
+		t.Fatalf("ackFrame = %+v, want an ack of m1 to user 1", ack)
+	}
+	if payload.MessageID != 42 || payload.Seq != 7 || !payload.CreatedAt.Equal(storedAt) {
+		t.Errorf("ack payload = %+v, want message 42 with seq 7", payload)
+	}
+
+	nack := nackFrame(m, ErrMuted.WithRetryAfter(1500*time.Millisecond))
+	errPayload, ok := nack.Payload.(*ErrorPayload)
+	if nack.Type != EventNack || nack.ID != "m1" || nack.RoomID != "lobby" || nack.UserID != "1" || !ok {
+		t.Fatalf("nackFrame = %+v, want a nack of m1 to user 1", nack)
+	}
+	if errPayload.Code != "muted" || errPayload.RetryAfter != 2 {
+		t.Errorf("nack payload = %+v, want code muted retrying after 2s", errPayload)
+	}
+}
+
+func TestResendWithinWindow(t *testing.T) {
+	h, messages := newTestHub(t, DefaultConfig(), &stubRooms{})
+	alice := joinLobby(h, 1)
+	bob := joinLobby(h, 2)
+
+	post(h, alice, "m1", "hello")
+	recvType(t, bob, EventMessage)
+	recvType(t, alice, EventMessage)
+	ack := recvType(t, alice, EventAck)
+
+	// The retry is answered with the same ack, over a new connection too
+	alice = joinLobby(h, 1)
+	post(h, alice, "m1", "hello")
+	if again := recvType(t, alice, EventAck); again != ack {
+		t.Errorf("retry got %+v, want the original ack %+v", again, ack)
+	}
+	none(t, bob)
+	if seq, _ := messages.LastSeq(context.Background(), "lobby"); seq != 1 {
+		t.Errorf("%d messages stored, want 1", seq)
+	}
+}
+
+func TestResendAfterWindow(t *testing.T) {
+	config := DefaultConfig()
+	config.DedupeWindow = 20 * time.Millisecond
+	h, _ := newTestHub(t, config, &stubRooms{})
+	alice := joinLobby(h, 1)
+	bob := joinLobby(h, 2)
+
+	post(h, alice, "m1", "hello")
+	first := recvType(t, bob, EventMessage)
+	recvType(t, alice, EventMessage)
+	recvType(t, alice, EventAck)
+
+	time.Sleep(2 * config.DedupeWindow)
+	post(h, alice, "m1", "hello")
+	if again := recvType(t, bob, EventMessage); again.Seq != first.Seq+1 {
+		t.Errorf("message sent after the window got seq %d, want it delivered again as %d", again.Seq, first.Seq+1)
+	}
+}
+
+func TestNackWhenQueueFull(t *testing.T) {
+	// The persister isn't running, so nothing leaves its queue
+	h := NewHub(DefaultConfig(), &memMessages{}, &stubRooms{})
+	if _, err := h.LoadRoom(context.Background(), "lobby"); err != nil {
+		t.Fatalf("LoadRoom: %v", err)
+	}
+	alice := joinLobby(h, 1)
+	for len(h.persister.queue) < cap(h.persister.queue) {
+		h.persister.queue <- &Message{}
+	}
+
+	m := &Message{Type: EventMessage, Content: "hello", RoomID: "lobby", UserID: alice.ID, ref: "m1"}
+	h.mu.Lock()
+	h.post(m)
+	known := h.deliveries.get(deliveryKey{alice.ID, "m1"}, time.Now())
+	h.mu.Unlock()
+
+	nack := recvType(t, alice, EventNack)
+	if payload := nack.Payload.(*ErrorPayload); nack.ID != "m1" || payload.Code != "not_stored" {
+		t.Errorf("got nack %+v with %+v, want not_stored for m1", nack, payload)
+	}
+	if known != nil {
+		t.Error("nacked message is still a known delivery, its retry would be dropped")
+	}
+	if h.Stats().UnsavedEvents != 1 {
+		t.Errorf("hub counted %d unsaved events, want 1", h.Stats().UnsavedEvents)
+	}
+}
@@ -1,6 +1,14 @@
 package websocket
 
-import "github.com/gorilla/websocket"
+import (
+	"encoding/json"
+	"log"
+	"server/internal/room"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
 
 type Client struct {
 	Conn     *websocket.Conn
@@ -8,10 +16,211 @@ type Client struct {
 	ID       string `json:"id"`
 	RoomID   string `json:"room_id"`
 	Username string `json:"username"`
+
+	spill     *spillQueue
+	dropped   atomic.Uint64
+	closeCode int
+	// replaying is set while missed events are replayed, live ones then
+	// wait in spill up to the hub's replayLimit whatever the overflow
+	// policy. The hub guards it with its mutex.
+	replaying bool
+}
+
+func newClient(conn *websocket.Conn, id, roomID, username string) *Client {
+	return &Client{
+		Conn:     conn,
+		Message:  make(chan *Message, 25),
+		ID:       id,
+		RoomID:   roomID,
+		Username: username,
+		spill:    newSpillQueue(),
+	}
 }
 
+// Dropped returns how many messages this client missed because it could not
+// keep up.
+func (c *Client) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// Message is an event queued for the clients of a room. Type is one of the
+// registered event types and ID identifies the event, or for error frames
+// the client frame they answer. Persisted events are identified by their ID
+// in the room's history and numbered by Seq within their room. System
+// messages come from the server.
 type Message struct {
-	Content  string `json:"content"`
-	RoomID   string `json:"room_id"`
-	Username string `json:"username"`
+	Type       string           `json:"-"`
+	ID         string           `json:"-"`
+	Seq        int64            `json:"seq,omitempty"`
+	Content    string           `json:"content"`
+	RoomID     string           `json:"room_id"`
+	UserID     string           `json:"user_id"`
+	Username   string           `json:"username"`
+	System     bool             `json:"system"`
+	Moderation *room.AuditEntry `json:"moderation,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+
+	// Payload replaces the message as the payload of its envelope, for
+	// events that don't carry a message
+	Payload any `json:"-"`
+	// ref is the ID of the client frame the message came from
+	ref string
+	// storedID is the ID of a persisted event in the room's history
+	storedID int64
+}
+
+// envelope wraps the message for the wire.
+func (m *Message) envelope() (*Envelope, error) {
+	var payload any = m
+	if m.Payload != nil {
+		payload = m.Payload
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{Type: m.Type, ID: m.ID, TS: m.CreatedAt, Payload: data}, nil
+}
+
+// writeMessage pumps messages from the hub to the connection and keeps it
+// alive with pings. It is the only goroutine allowed to write to Conn.
+func (c *Client) writeMessage(config Config) {
+	ticker := time.NewTicker(config.PingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.Message:
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
+			if !ok {
+				// The hub closed the channel
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
+				return
+			}
+			if err := c.write(message); err != nil {
+				log.Printf("error writing to client %s: %v", c.ID, err)
+				return
+			}
+			if err := c.writeSpilled(config); err != nil {
+				log.Printf("error writing to client %s: %v", c.ID, err)
+				return
+			}
+		case <-c.spill.ready:
+			if err := c.writeSpilled(config); err != nil {
+				log.Printf("error writing to client %s: %v", c.ID, err)
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeSpilled writes the spilled messages once everything buffered before
+// them has been written.
+func (c *Client) writeSpilled(config Config) error {
+	if len(c.Message) > 0 {
+		return nil
+	}
+	for _, message := range c.spill.take() {
+		c.Conn.SetWriteDeadline(time.Now().Add(config.WriteWait))
+		if err := c.write(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) write(m *Message) error {
+	frame, err := m.envelope()
+	if err != nil {
+		return err
+	}
+	return c.Conn.WriteJSON(frame)
+}
+
+func (c *Client) closeMessage() []byte {
+	if c.closeCode == 0 {
+		return []byte{}
+	}
+	return websocket.FormatCloseMessage(c.closeCode, "")
+}
+
+// readMessage pumps frames from the connection to the hub until the
+// connection fails or the peer stops answering pings, then unregisters the
+// client. Frames that fail validation are answered with an error frame,
+// commands are run by moderator.
+func (c *Client) readMessage(hub *Hub, moderator Moderator) {
+	defer func() {
+		hub.Unregister <- c
+		c.Conn.Close()
+	}()
+
+	c.Conn.SetReadLimit(hub.config.MaxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(hub.config.PongWait))
+	})
+
+	for {
+		kind, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("error reading from client %s: %v", c.ID, err)
+			}
+			return
+		}
+		if kind != websocket.TextMessage {
+			hub.Send(c, errorFrame("", ErrInvalidFrame))
+			continue
+		}
+		frame, payload, err := decodeFrame(data)
+		if err != nil {
+			hub.Send(c, errorFrame(frame.ID, err))
+			continue
+		}
+		c.handle(hub, moderator, frame, payload)
+	}
+}
+
+// handle acts on a valid frame from the client.
+func (c *Client) handle(hub *Hub, moderator Moderator, frame *Envelope, payload inboundPayload) {
+	switch p := payload.(type) {
+	case *MessagePayload:
+		if isCommand(p.Content) {
+			c.runCommand(hub, moderator, frame.ID, p.Content)
+			return
+		}
+		hub.Broadcast <- &Message{
+			Type:      EventMessage,
+			Content:   p.Content,
+			RoomID:    c.RoomID,
+			UserID:    c.ID,
+			Username:  c.Username,
+			CreatedAt: time.Now(),
+			ref:       frame.ID,
+		}
+	case *TypingPayload:
+		hub.Broadcast <- &Message{
+			Type:      EventTyping,
+			RoomID:    c.RoomID,
+			UserID:    c.ID,
+			Username:  c.Username,
+			CreatedAt: time.Now(),
+			Payload: &TypingEvent{
+				RoomID:   c.RoomID,
+				UserID:   c.ID,
+				Username: c.Username,
+				Active:   p.Active,
+			},
+		}
+	case *ModeratePayload:
+		c.moderate(hub, moderator, frame.ID, p.request())
+	}
 }
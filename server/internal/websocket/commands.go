@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"context"
+	"server/internal/apperr"
+	"server/internal/room"
+	"strconv"
+	"strings"
+)
+
+// Moderator runs the moderation commands typed in a room. room.Service is
+// one.
+type Moderator interface {
+	Moderate(c context.Context, req *room.ModerateRequest) (*room.AuditEntry, error)
+}
+
+// command describes the arguments of a moderation command after its name.
+type command struct {
+	action string
+	usage  string
+	// target and duration tell whether the command starts with a user ID
+	// and a duration, what is left over is the reason
+	target   bool
+	duration bool
+	reason   bool
+}
+
+var commands = map[string]command{
+	"/kick":   {action: room.ActionKick, usage: "/kick <user_id> [reason]", target: true, reason: true},
+	"/ban":    {action: room.ActionBan, usage: "/ban <user_id> [reason]", target: true, reason: true},
+	"/unban":  {action: room.ActionUnban, usage: "/unban <user_id>", target: true},
+	"/mute":   {action: room.ActionMute, usage: "/mute <user_id> <duration> [reason]", target: true, duration: true, reason: true},
+	"/unmute": {action: room.ActionUnmute, usage: "/unmute <user_id>", target: true},
+	"/slow":   {action: room.ActionSlowMode, usage: "/slow <duration|off>", duration: true},
+}
+
+var (
+	ErrInvalidCommand   = apperr.New(apperr.Validation, "invalid_command", "invalid command")
+	ErrCommandsDisabled = apperr.New(apperr.Forbidden, "commands_disabled", "commands are not available")
+)
+
+// invalidCommand is ErrInvalidCommand explaining what is wrong.
+func invalidCommand(message string) error {
+	err := *ErrInvalidCommand
+	err.Message = message
+	return &err
+}
+
+func isCommand(text string) bool {
+	return strings.HasPrefix(text, "/")
+}
+
+// parseCommand turns a line such as "/mute 42 10m spamming" into a
+// moderation request for the client's room.
+func parseCommand(text string) (*room.ModerateRequest, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, ErrInvalidCommand
+	}
+	cmd, ok := commands[fields[0]]
+	if !ok {
+		return nil, invalidCommand("unknown command " + fields[0])
+	}
+	usage := invalidCommand("usage: " + cmd.usage)
+	args := fields[1:]
+
+	req := &room.ModerateRequest{Action: cmd.action}
+	if cmd.target {
+		if len(args) == 0 {
+			return nil, usage
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil, usage
+		}
+		req.UserID = id
+		args = args[1:]
+	}
+	if cmd.duration {
+		if len(args) == 0 {
+			return nil, usage
+		}
+		req.Duration = args[0]
+		if cmd.action == room.ActionSlowMode && req.Duration == "off" {
+			req.Duration = "0s"
+		}
+		args = args[1:]
+	}
+	if len(args) > 0 && !cmd.reason {
+		return nil, usage
+	}
+	req.Reason = strings.Join(args, " ")
+	return req, nil
+}
+
+// runCommand runs a slash command on behalf of the client.
+func (c *Client) runCommand(hub *Hub, moderator Moderator, ref, text string) {
+	req, err := parseCommand(text)
+	if err != nil {
+		hub.Send(c, errorFrame(ref, err))
+		return
+	}
+	c.moderate(hub, moderator, ref, req)
+}
+
+// moderate runs a moderation action in the client's room. Failures are
+// only shown to the client, the outcome is announced by the hub.
+func (c *Client) moderate(hub *Hub, moderator Moderator, ref string, req *room.ModerateRequest) {
+	if moderator == nil {
+		hub.Send(c, errorFrame(ref, ErrCommandsDisabled))
+		return
+	}
+	actorID, err := strconv.ParseInt(c.ID, 10, 64)
+	if err != nil {
+		hub.Send(c, errorFrame(ref, err))
+		return
+	}
+	req.RoomID = c.RoomID
+	req.ActorID = actorID
+	if err := req.Validate(); err != nil {
+		hub.Send(c, errorFrame(ref, err))
+		return
+	}
+	if _, err := moderator.Moderate(context.Background(), req); err != nil {
+		hub.Send(c, errorFrame(ref, err))
+	}
+}
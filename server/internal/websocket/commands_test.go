@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"server/internal/room"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    *room.ModerateRequest
+		wantErr string
+	}{
+		{text: "/kick 42", want: &room.ModerateRequest{Action: room.ActionKick, UserID: 42}},
+		{text: "/kick 42 spamming the room", want: &room.ModerateRequest{Action: room.ActionKick, UserID: 42, Reason: "spamming the room"}},
+		{text: "  /ban   42   rude  ", want: &room.ModerateRequest{Action: room.ActionBan, UserID: 42, Reason: "rude"}},
+		{text: "/unban 42", want: &room.ModerateRequest{Action: room.ActionUnban, UserID: 42}},
+		{text: "/mute 42 10m", want: &room.ModerateRequest{Action: room.ActionMute, UserID: 42, Duration: "10m"}},
+		{text: "/mute 42 10m calm down", want: &room.ModerateRequest{Action: room.ActionMute, UserID: 42, Duration: "10m", Reason: "calm down"}},
+		{text: "/unmute 42", want: &room.ModerateRequest{Action: room.ActionUnmute, UserID: 42}},
+		{text: "/slow 30s", want: &room.ModerateRequest{Action: room.ActionSlowMode, Duration: "30s"}},
+		{text: "/slow off", want: &room.ModerateRequest{Action: room.ActionSlowMode, Duration: "0s"}},
+
+		{text: "/", wantErr: "unknown command /"},
+		{text: "/smite 42", wantErr: "unknown command /smite"},
+		{text: "/KICK 42", wantErr: "unknown command /KICK"},
+		{text: "/kick", wantErr: "usage: /kick <user_id> [reason]"},
+		{text: "/kick bob", wantErr: "usage: /kick <user_id> [reason]"},
+		{text: "/unban 42 sorry", wantErr: "usage: /unban <user_id>"},
+		{text: "/mute 42", wantErr: "usage: /mute <user_id> <duration> [reason]"},
+		{text: "/unmute 42 now", wantErr: "usage: /unmute <user_id>"},
+		{text: "/slow", wantErr: "usage: /slow <duration|off>"},
+		{text: "/slow 30s please", wantErr: "usage: /slow <duration|off>"},
+	}
+	for _, tt := range tests {
+		got, err := parseCommand(tt.text)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr || !errors.Is(err, ErrInvalidCommand) {
+				t.Errorf("parseCommand(%q) = %+v, %v, want error %q", tt.text, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCommand(%q) = %+v, %v, want %+v", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+// moderator records the requests it is given and fails them with err.
+type moderator struct {
+	reqs []*room.ModerateRequest
+	err  error
+}
+
+func (m *moderator) Moderate(c context.Context, req *room.ModerateRequest) (*room.AuditEntry, error) {
+	m.reqs = append(m.reqs, req)
+	return nil, m.err
+}
+
+func TestRunCommand(t *testing.T) {
+	h, _ := newTestHub(t, DefaultConfig(), &stubRooms{})
+	alice := joinLobby(h, 1)
+
+	mod := &moderator{}
+	alice.runCommand(h, mod, "c1", "/mute 3 10m spam")
+	want := &room.ModerateRequest{RoomID: "lobby", ActorID: 1, Action: room.ActionMute, UserID: 3, Duration: "10m", Reason: "spam"}
+	if len(mod.reqs) != 1 || !reflect.DeepEqual(mod.reqs[0], want) {
+		t.Errorf("moderator got %+v, want %+v", mod.reqs, want)
+	}
+	none(t, alice)
+
+	tests := []struct {
+		name      string
+		moderator Moderator
+		text      string
+		code      string
+	}{
+		{"bad usage", mod, "/mute 3", "invalid_command"},
+		{"invalid duration", mod, "/mute 3 soon", "validation_failed"},
+		{"refused", &moderator{err: room.ErrForbidden}, "/kick 3", "room_forbidden"},
+		{"no moderator", nil, "/kick 3", "commands_disabled"},
+	}
+	for i, tt := range tests {
+		ref := fmt.Sprintf("c%d", i+2)
+		alice.runCommand(h, tt.moderator, ref, tt.text)
+		e := recvType(t, alice, EventError)
+		if payload := e.Payload.(*ErrorPayload); e.ID != ref || payload.Code != tt.code {
+			t.Errorf("%s: got error %+v with %+v, want %s for %s", tt.name, e, payload, tt.code, ref)
+		}
+	}
+	if len(mod.reqs) != 1 {
+		t.Errorf("moderator got %d requests, want only the valid one", len(mod.reqs))
+	}
+}
+
+func TestMutedPostRejected(t *testing.T) {
+	rooms := &stubRooms{mutes: []*room.Mute{{RoomID: "lobby", UserID: 1, MutedUntil: time.Now().Add(time.Minute)}}}
+	h, _ := newTestHub(t, DefaultConfig(), rooms)
+	alice := joinLobby(h, 1)
+	bob := joinLobby(h, 2)
+
+	post(h, alice, "m1", "let me speak")
+	nack := recvType(t, alice, EventNack)
+	if payload := nack.Payload.(*ErrorPayload); nack.ID != "m1" || payload.Code != "muted" || payload.RetryAfter != 60 {
+		t.Errorf("got nack %+v with %+v, want muted for a minute", nack, payload)
+	}
+	none(t, bob)
+
+	h.Moderated(&room.AuditEntry{RoomID: "lobby", Action: room.ActionUnmute, ActorID: 2, TargetID: 1, CreatedAt: time.Now()})
+	recvType(t, alice, EventModeration)
+	recvType(t, bob, EventModeration)
+	post(h, alice, "m2", "thanks")
+	if got := recvType(t, bob, EventMessage); got.Content != "thanks" {
+		t.Errorf("bob got %q, want the message posted after the unmute", got.Content)
+	}
+
+	// Mutes that come in while the room is loaded apply right away
+	h.Moderated(&room.AuditEntry{RoomID: "lobby", Action: room.ActionMute, ActorID: 1, TargetID: 2, Seconds: 30, CreatedAt: time.Now()})
+	recvType(t, bob, EventModeration)
+	post(h, bob, "m3", "but")
+	if nack := recvType(t, bob, EventNack); nack.ID != "m3" {
+		t.Errorf("bob got %+v, want a nack of m3", nack)
+	}
+}
+
+func TestSlowMode(t *testing.T) {
+	h, _ := newTestHub(t, DefaultConfig(), &stubRooms{})
+	alice := joinLobby(h, 1)
+	bob := joinLobby(h, 2)
+
+	h.Moderated(&room.AuditEntry{RoomID: "lobby", Action: room.ActionSlowMode, ActorID: 2, Seconds: 60, CreatedAt: time.Now()})
+	recvType(t, alice, EventModeration)
+	recvType(t, bob, EventModeration)
+
+	post(h, alice, "m1", "first")
+	recvType(t, bob, EventMessage)
+	recvType(t, alice, EventMessage)
+	recvType(t, alice, EventAck)
+
+	post(h, alice, "m2", "too soon")
+	nack := recvType(t, alice, EventNack)
+	if payload := nack.Payload.(*ErrorPayload); nack.ID != "m2" || payload.Code != "slow_mode" || payload.RetryAfter != 60 {
+		t.Errorf("got nack %+v with %+v, want slow_mode for a minute", nack, payload)
+	}
+	none(t, bob)
+
+	// Everyone has their own interval
+	post(h, bob, "m3", "me too")
+	recvType(t, alice, EventMessage)
+
+	h.Moderated(&room.AuditEntry{RoomID: "lobby", Action: room.ActionSlowMode, ActorID: 2, CreatedAt: time.Now()})
+	recvType(t, alice, EventModeration)
+	post(h, alice, "m4", "free again")
+	if got := recvType(t, alice, EventMessage); got.Content != "free again" {
+		t.Errorf("alice got %q, want her message after slow mode was turned off", got.Content)
+	}
+}
+
+func TestBanBlocksJoin(t *testing.T) {
+	rooms := &stubRooms{bans: map[int64]bool{3: true}}
+	h, _ := newTestHub(t, DefaultConfig(), rooms)
+	srv := newTestServer(t, h)
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if code := closeCode(t, base+"/ws/lobby?user=3"); code != CloseBanned {
+		t.Errorf("banned user was closed with %d, want %d", code, CloseBanned)
+	}
+
+	// Connected users are disconnected when banned
+	alice := joinLobby(h, 1)
+	carol := joinLobby(h, 4)
+	h.Moderated(&room.AuditEntry{RoomID: "lobby", Action: room.ActionBan, ActorID: 1, TargetID: 4, CreatedAt: time.Now()})
+	if code := closed(t, carol); code != CloseBanned {
+		t.Errorf("carol was closed with %d, want %d", code, CloseBanned)
+	}
+	if e := recvType(t, alice, EventModeration); e.Moderation == nil || e.Moderation.TargetID != 4 {
+		t.Errorf("alice got %+v, want the ban of user 4", e)
+	}
+}
@@ -1,30 +1,593 @@
 package websocket
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"server/internal/message"
+	"server/internal/room"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Config holds the per-connection limits applied to every client.
+type Config struct {
+	// WriteWait is the time allowed to write a frame to the peer.
+	WriteWait time.Duration
+	// PongWait is the time allowed to read the next pong from the peer.
+	PongWait time.Duration
+	// PingPeriod is how often pings are sent, it must be less than PongWait.
+	PingPeriod time.Duration
+	// MaxMessageSize is the largest frame accepted from the peer.
+	MaxMessageSize int64
+	// OverflowPolicy handles clients whose Message buffer is full.
+	OverflowPolicy OverflowPolicy
+	// DedupeWindow is how long a client message ID is remembered, a retry
+	// within it is acked again instead of delivered twice.
+	DedupeWindow time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		WriteWait:      10 * time.Second,
+		PongWait:       60 * time.Second,
+		PingPeriod:     54 * time.Second,
+		MaxMessageSize: 4096,
+		OverflowPolicy: DisconnectPolicy{Code: websocket.CloseTryAgainLater},
+		DedupeWindow:   5 * time.Minute,
+	}
+}
+
+func (c Config) Validate() error {
+	if c.WriteWait <= 0 || c.PongWait <= 0 || c.PingPeriod <= 0 {
+		return fmt.Errorf("websocket timeouts must be positive")
+	}
+	if c.PingPeriod >= c.PongWait {
+		return fmt.Errorf("ping period (%s) must be less than pong wait (%s)", c.PingPeriod, c.PongWait)
+	}
+	if c.MaxMessageSize <= 0 {
+		return fmt.Errorf("max message size must be positive")
+	}
+	if c.OverflowPolicy == nil {
+		return fmt.Errorf("overflow policy is required")
+	}
+	if c.DedupeWindow <= 0 {
+		return fmt.Errorf("dedupe window must be positive")
+	}
+	return nil
+}
+
+// ErrShuttingDown is returned to clients joining while the hub shuts down.
+var ErrShuttingDown = errors.New("server is shutting down")
+
 type Room struct {
 	ID      string             `json:"id"`
 	Name    string             `json:"name"`
-	Clients map[string]*Client `json:"id"`
+	Clients map[string]*Client `json:"clients"`
+
+	direct bool
+	// seq is the sequence number of the last stored event broadcast
+	seq int64
+	// muted holds when each muted client may post again, slowMode how long
+	// clients wait between two messages and lastPost when they last did
+	muted    map[string]time.Time
+	slowMode time.Duration
+	lastPost map[string]time.Time
+}
+
+// Stats counts the messages the hub could not deliver, and the events it
+// dropped unstored because the database fell behind.
+type Stats struct {
+	DroppedMessages     uint64 `json:"dropped_messages"`
+	DisconnectedClients uint64 `json:"disconnected_clients"`
+	UnsavedEvents       uint64 `json:"unsaved_events"`
 }
 
 type Hub struct {
-	Rooms      map[string]*Room
-	Register   chan *Client
+	config     Config
 	Unregister chan *Client
 	Broadcast  chan *Message
+
+	// mu guards rooms, which only holds the rooms loaded since startup, and
+	// closing
+	mu         sync.Mutex
+	rooms      map[string]*Room
+	closing    bool
+	pumps      sync.WaitGroup
+	roomRepo   room.Repository
+	persister  *persister
+	deliveries *deliveries
+	// results holds the stored events and nacks of the persister until the
+	// hub handles them, it never blocks so the persister can't stall on the
+	// hub
+	results      *spillQueue
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+	unsaved      atomic.Uint64
 }
 
-func NewHub() *Hub {
+func NewHub(config Config, messages message.Repository, rooms room.Repository) *Hub {
+	results := newSpillQueue()
 	return &Hub{
-		Rooms: make(map[string]*Room),
+		config:     config,
+		Unregister: make(chan *Client),
+		Broadcast:  make(chan *Message, 5),
+		rooms:      make(map[string]*Room),
+		roomRepo:   rooms,
+		persister:  newPersister(messages, rooms, results),
+		deliveries: newDeliveries(config.DedupeWindow),
+		results:    results,
+	}
+}
+
+// LoadRoom makes sure the room is live in the hub, loading it from the
+// database the first time somebody joins it.
+func (h *Hub) LoadRoom(ctx context.Context, id string) (*room.Room, error) {
+	stored, err := h.roomRepo.GetRoomByID(ctx, id)
+	if errors.Is(err, room.ErrRoomNotFound) {
+		stored, err = h.newDirectRoom(ctx, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var mutes []*room.Mute
+	if stored.Kind == room.KindRoom {
+		if mutes, err = h.roomRepo.ListMutes(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	// Events are broadcast once stored, so live delivery picks up after the
+	// last stored one
+	seq, err := h.persister.repository.LastSeq(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		return nil, ErrShuttingDown
+	}
+	if r, ok := h.rooms[id]; ok {
+		r.Name = stored.Name
+		return stored, nil
+	}
+	r := &Room{
+		ID:       stored.ID,
+		Name:     stored.Name,
+		Clients:  make(map[string]*Client),
+		direct:   stored.Kind == room.KindDirect,
+		seq:      seq,
+		muted:    make(map[string]time.Time),
+		slowMode: time.Duration(stored.SlowModeSeconds) * time.Second,
+		lastPost: make(map[string]time.Time),
+	}
+	for _, m := range mutes {
+		r.muted[strconv.FormatInt(m.UserID, 10)] = m.MutedUntil
+	}
+	h.rooms[id] = r
+	return stored, nil
+}
+
+// newDirectRoom returns a direct room that isn't stored yet, the persister
+// stores it with its first message.
+func (h *Hub) newDirectRoom(ctx context.Context, id string) (*room.Room, error) {
+	a, b, ok := room.DirectParticipants(id)
+	if !ok {
+		return nil, room.ErrRoomNotFound
 	}
+	for _, userID := range []int64{a, b} {
+		if _, err := h.roomRepo.GetParticipant(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	return &room.Room{
+		ID:         id,
+		Visibility: room.VisibilityPrivate,
+		Kind:       room.KindDirect,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// RoomUpdated keeps the live room in sync with the database.
+func (h *Hub) RoomUpdated(stored *room.Room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r, ok := h.rooms[stored.ID]; ok {
+		r.Name = stored.Name
+	}
+}
+
+// RoomDeleted disconnects everybody in the room and forgets it.
+func (h *Hub) RoomDeleted(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[id]
+	if !ok {
+		return
+	}
+	for _, cl := range r.Clients {
+		cl.closeCode = websocket.CloseGoingAway
+		close(cl.Message)
+	}
+	delete(h.rooms, id)
 }
 
 func (h *Hub) Run() {
+	go h.persister.run()
+
 	for {
 		select {
-		case cl := <-h.Register:
 		case cl := <-h.Unregister:
+			h.mu.Lock()
+			h.unregister(cl)
+			h.mu.Unlock()
+		case m := <-h.Broadcast:
+			h.mu.Lock()
+			h.post(m)
+			h.mu.Unlock()
+		case <-h.results.ready:
+			h.mu.Lock()
+			for _, m := range h.results.take() {
+				if m.Type == EventNack {
+					h.acked(m)
+				} else {
+					h.stored(m)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// Register adds the client to its room. It returns the sequence number of
+// the last event broadcast to the room before, the client gets every event
+// after it.
+func (h *Hub) Register(cl *Client) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.register(cl)
+	if room, ok := h.rooms[cl.RoomID]; ok {
+		return room.seq
+	}
+	return 0
+}
+
+func (h *Hub) register(cl *Client) {
+	room, ok := h.rooms[cl.RoomID]
+	if !ok || h.closing {
+		if h.closing {
+			cl.closeCode = websocket.CloseGoingAway
+		}
+		// Closing the channel makes the write pump send a close frame
+		close(cl.Message)
+		return
+	}
+	// A user only keeps one connection per room, the newest one wins
+	if existing, ok := room.Clients[cl.ID]; ok {
+		close(existing.Message)
+	}
+	room.Clients[cl.ID] = cl
+}
+
+func (h *Hub) unregister(cl *Client) {
+	room, ok := h.rooms[cl.RoomID]
+	if !ok {
+		return
+	}
+	// The client may already have been replaced by a newer connection
+	if existing, ok := room.Clients[cl.ID]; !ok || existing != cl {
+		return
+	}
+	h.remove(room, cl)
+}
+
+// remove deletes the client from its room, closes its buffer so the write
+// pump shuts the connection down, and tells the rest of the room.
+func (h *Hub) remove(room *Room, cl *Client) {
+	delete(room.Clients, cl.ID)
+	close(cl.Message)
+
+	// Direct conversations have no presence notices, both sides know who
+	// is there
+	if room.direct {
+		return
+	}
+	h.broadcast(&Message{
+		Type:      EventLeave,
+		Content:   fmt.Sprintf("%s has left the room", cl.Username),
+		RoomID:    cl.RoomID,
+		UserID:    cl.ID,
+		Username:  cl.Username,
+		System:    true,
+		CreatedAt: time.Now(),
+	})
+}
+
+// post broadcasts an event unless it is a message already delivered under
+// the same client ID, or whose sender is muted or posting faster than slow
+// mode allows. Retries are answered again, dropped messages get a nack.
+func (h *Hub) post(m *Message) {
+	room, ok := h.rooms[m.RoomID]
+	if !ok || m.Type != EventMessage || m.System {
+		h.broadcast(m)
+		return
+	}
+	now := time.Now()
+	cl, connected := room.Clients[m.UserID]
+
+	key := deliveryKey{m.UserID, m.ref}
+	if m.ref != "" {
+		if dl := h.deliveries.get(key, now); dl != nil {
+			// Retries get the original's answer, or nothing yet while it
+			// is being stored since acked sends it to this connection
+			if dl.ack != nil && connected {
+				h.send(room, cl, dl.ack)
+			}
+			return
+		}
+	}
+
+	if until, ok := room.muted[m.UserID]; ok {
+		if now.Before(until) {
+			if connected {
+				h.send(room, cl, nackFrame(m, ErrMuted.WithRetryAfter(until.Sub(now))))
+			}
+			return
+		}
+		delete(room.muted, m.UserID)
+	}
+	if room.slowMode > 0 {
+		if wait := room.lastPost[m.UserID].Add(room.slowMode).Sub(now); wait > 0 {
+			if connected {
+				h.send(room, cl, nackFrame(m, ErrSlowMode.WithRetryAfter(wait)))
+			}
+			return
+		}
+		room.lastPost[m.UserID] = now
+	}
+	if m.ref != "" {
+		h.deliveries.add(key, now)
+	}
+	h.broadcast(m)
+}
+
+// send delivers an event to one client without saving it.
+func (h *Hub) send(room *Room, cl *Client, m *Message) {
+	if !h.deliver(cl, m) {
+		h.disconnected.Add(1)
+		h.remove(room, cl)
+	}
+}
+
+// Send delivers an event to the client if it is still connected.
+func (h *Hub) Send(cl *Client, m *Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.rooms[cl.RoomID]
+	if !ok {
+		return
+	}
+	if existing, ok := room.Clients[cl.ID]; ok && existing == cl {
+		h.send(room, cl, m)
+	}
+}
+
+// Moderated applies a moderation action to the live room and tells its
+// members. Rooms nobody is in pick the change up when they are loaded.
+func (h *Hub) Moderated(entry *room.AuditEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.rooms[entry.RoomID]
+	if !ok || h.closing {
+		return
+	}
+
+	target := strconv.FormatInt(entry.TargetID, 10)
+	switch entry.Action {
+	case room.ActionKick:
+		h.disconnect(r, target, CloseKicked)
+	case room.ActionBan:
+		h.disconnect(r, target, CloseBanned)
+	case room.ActionMute:
+		r.muted[target] = entry.CreatedAt.Add(entry.Duration())
+	case room.ActionUnmute:
+		delete(r.muted, target)
+	case room.ActionSlowMode:
+		r.slowMode = entry.Duration()
+	}
+
+	h.broadcast(&Message{
+		Type:       EventModeration,
+		Content:    entry.Summary(),
+		RoomID:     r.ID,
+		UserID:     strconv.FormatInt(entry.ActorID, 10),
+		Username:   entry.ActorName,
+		System:     true,
+		Moderation: entry,
+		CreatedAt:  entry.CreatedAt,
+	})
+}
+
+// disconnect closes the connection of a user with code, without the usual
+// leave notice.
+func (h *Hub) disconnect(room *Room, userID string, code int) {
+	cl, ok := room.Clients[userID]
+	if !ok {
+		return
+	}
+	delete(room.Clients, userID)
+	cl.closeCode = code
+	close(cl.Message)
+}
+
+// persist queues m for the database without blocking the hub, the persister
+// hands it back to stored once saved. When the persister is that far behind
+// the event is dropped, and its sender is told it was not stored.
+func (h *Hub) persist(room *Room, m *Message) {
+	select {
+	case h.persister.queue <- m:
+	default:
+		h.unsaved.Add(1)
+		log.Printf("persister queue full, not storing %s event in room %s", m.Type, room.ID)
+		if m.ref != "" {
+			h.acked(nackFrame(m, ErrNotStored))
+		}
+	}
+}
+
+// stored broadcasts an event the persister saved and acks it to its sender.
+func (h *Hub) stored(m *Message) {
+	room, ok := h.rooms[m.RoomID]
+	if !ok || h.closing {
+		return
+	}
+	room.seq = m.Seq
+	h.deliverAll(room, m)
+	if m.ref != "" {
+		h.acked(ackFrame(m, m.storedID, m.Seq, m.CreatedAt))
+	}
+}
+
+// broadcast sends an event to the room. Persisted events are only sent once
+// stored, so a sender told its message was not stored can resend it
+// without anybody seeing it twice.
+func (h *Hub) broadcast(m *Message) {
+	room, ok := h.rooms[m.RoomID]
+	if !ok || h.closing {
+		return
+	}
+	if eventTypes[m.Type].persisted {
+		h.persist(room, m)
+		return
+	}
+	if m.ID == "" {
+		m.ID = uuid.NewString()
+	}
+	h.deliverAll(room, m)
+}
+
+// deliverAll delivers an event to every client of the room, disconnecting
+// the ones that can't keep up.
+func (h *Hub) deliverAll(room *Room, m *Message) {
+	var slow []*Client
+	for _, cl := range room.Clients {
+		if !h.deliver(cl, m) {
+			slow = append(slow, cl)
+		}
+	}
+	for _, cl := range slow {
+		// An earlier removal may have already dropped this client
+		if existing, ok := room.Clients[cl.ID]; ok && existing == cl {
+			log.Printf("disconnecting slow client %s from room %s", cl.ID, room.ID)
+			h.disconnected.Add(1)
+			h.remove(room, cl)
+		}
+	}
+}
+
+// deliver queues a message for a client without ever blocking the hub. It
+// returns false if the client must be disconnected.
+func (h *Hub) deliver(cl *Client, m *Message) bool {
+	if cl.replaying {
+		// A client that can't even replay as fast as events arrive is
+		// disconnected, it resumes again from where it got to
+		if !cl.spill.push(m, h.replayLimit(cl)) {
+			cl.closeCode = websocket.CloseTryAgainLater
+			return false
+		}
+		return true
+	}
+	// Anything spilled must be written first to keep messages in order
+	if cl.spill.len() == 0 {
+		select {
+		case cl.Message <- m:
+			return true
+		default:
+		}
+	}
+	switch h.config.OverflowPolicy.Overflow(cl, m) {
+	case Dropped:
+		cl.dropped.Add(1)
+		h.dropped.Add(1)
+	case Disconnect:
+		return false
+	}
+	return true
+}
+
+// replayLimit is how many live events may wait for a client while it
+// replays, as many as the overflow policy would hold for it otherwise.
+func (h *Hub) replayLimit(cl *Client) int {
+	if p, ok := h.config.OverflowPolicy.(SpillPolicy); ok {
+		return cap(cl.Message) + p.Limit
+	}
+	return cap(cl.Message)
+}
+
+// startPump reserves a write pump for a new connection. It returns false
+// once the hub is shutting down, the connection must then be closed.
+func (h *Hub) startPump() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		return false
+	}
+	h.pumps.Add(1)
+	return true
+}
+
+// Shutdown stops accepting clients, sends a going away close frame to every
+// connected client and waits until the messages already broadcast are
+// saved, or until ctx is done.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	// Messages read before the shutdown are still saved
+	for pending := true; pending; {
+		select {
 		case m := <-h.Broadcast:
+			h.post(m)
+		default:
+			pending = false
 		}
 	}
+	h.closing = true
+	for id, r := range h.rooms {
+		for _, cl := range r.Clients {
+			cl.closeCode = websocket.CloseGoingAway
+			close(cl.Message)
+		}
+		delete(h.rooms, id)
+	}
+	// Nothing is broadcast once closing is set, so the queue can be closed
+	close(h.persister.queue)
+	h.mu.Unlock()
+
+	pumps := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		close(pumps)
+	}()
+	for _, done := range []<-chan struct{}{pumps, h.persister.done} {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (h *Hub) Stats() Stats {
+	return Stats{
+		DroppedMessages:     h.dropped.Load(),
+		DisconnectedClients: h.disconnected.Load(),
+		UnsavedEvents:       h.unsaved.Load(),
+	}
 }
@@ -0,0 +1,221 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"server/internal/message"
+	"server/internal/room"
+)
+
+// memMessages stores the history of rooms in memory, numbering events like
+// the database does.
+type memMessages struct {
+	mu   sync.Mutex
+	msgs []*message.Message
+	// fail makes storing fail
+	fail bool
+	// pause, when set, holds up the next ListMessagesSince: it is sent on
+	// once the call starts and the call goes on once it is sent on again
+	pause chan struct{}
+}
+
+func (s *memMessages) CreateMessage(ctx context.Context, m *message.Message) (*message.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return nil, errors.New("database is down")
+	}
+	stored := *m
+	stored.ID = int64(len(s.msgs) + 1)
+	stored.Seq = s.lastSeq(m.RoomID) + 1
+	s.msgs = append(s.msgs, &stored)
+	return &stored, nil
+}
+
+func (s *memMessages) ListMessages(ctx context.Context, roomID string, page message.Page) ([]*message.Message, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *memMessages) ListMessagesSince(ctx context.Context, roomID string, since int64, limit int) ([]*message.Message, error) {
+	s.mu.Lock()
+	pause := s.pause
+	s.pause = nil
+	s.mu.Unlock()
+	if pause != nil {
+		pause <- struct{}{}
+		<-pause
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var stored []*message.Message
+	for _, m := range s.msgs {
+		if m.RoomID == roomID && m.Seq > since && len(stored) < limit {
+			stored = append(stored, m)
+		}
+	}
+	return stored, nil
+}
+
+func (s *memMessages) LastSeq(ctx context.Context, roomID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSeq(roomID), nil
+}
+
+func (s *memMessages) lastSeq(roomID string) int64 {
+	var seq int64
+	for _, m := range s.msgs {
+		if m.RoomID == roomID {
+			seq = m.Seq
+		}
+	}
+	return seq
+}
+
+func (s *memMessages) setFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+// stubRooms serves public rooms, with the bans and mutes it is given. The
+// hub only calls the methods it implements.
+type stubRooms struct {
+	room.Repository
+	mu    sync.Mutex
+	bans  map[int64]bool
+	mutes []*room.Mute
+}
+
+func (s *stubRooms) GetRoomByID(ctx context.Context, id string) (*room.Room, error) {
+	return &room.Room{ID: id, Name: id, Visibility: room.VisibilityPublic, Kind: room.KindRoom}, nil
+}
+
+func (s *stubRooms) ListMutes(ctx context.Context, roomID string) ([]*room.Mute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mutes, nil
+}
+
+func (s *stubRooms) IsBanned(ctx context.Context, roomID string, userID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bans[userID], nil
+}
+
+// newTestHub runs a hub with the room "lobby" loaded.
+func newTestHub(t *testing.T, config Config, rooms *stubRooms) (*Hub, *memMessages) {
+	t.Helper()
+	messages := &memMessages{}
+	h := NewHub(config, messages, rooms)
+	go h.Run()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		h.Shutdown(ctx)
+	})
+	if _, err := h.LoadRoom(context.Background(), "lobby"); err != nil {
+		t.Fatalf("LoadRoom: %v", err)
+	}
+	return h, messages
+}
+
+// joinLobby registers a client without a connection, tests read its
+// buffer instead.
+func joinLobby(h *Hub, userID int64) *Client {
+	id := strconv.FormatInt(userID, 10)
+	cl := newClient(nil, id, "lobby", "user"+id)
+	h.Register(cl)
+	return cl
+}
+
+// post sends a message from the client the way its read pump does.
+func post(h *Hub, cl *Client, ref, content string) {
+	h.Broadcast <- &Message{
+		Type:      EventMessage,
+		Content:   content,
+		RoomID:    cl.RoomID,
+		UserID:    cl.ID,
+		Username:  cl.Username,
+		CreatedAt: time.Now(),
+		ref:       ref,
+	}
+}
+
+func recv(t *testing.T, cl *Client) *Message {
+	t.Helper()
+	select {
+	case m, ok := <-cl.Message:
+		if !ok {
+			t.Fatalf("client %s was disconnected", cl.ID)
+		}
+		return m
+	case <-time.After(time.Second):
+		t.Fatalf("client %s got nothing", cl.ID)
+		return nil
+	}
+}
+
+func recvType(t *testing.T, cl *Client, eventType string) *Message {
+	t.Helper()
+	m := recv(t, cl)
+	if m.Type != eventType {
+		t.Fatalf("client %s got a %s event %+v, want %s", cl.ID, m.Type, m, eventType)
+	}
+	return m
+}
+
+func none(t *testing.T, cl *Client) {
+	t.Helper()
+	select {
+	case m, ok := <-cl.Message:
+		if ok {
+			t.Fatalf("client %s got an unexpected %s event %+v", cl.ID, m.Type, m)
+		}
+		t.Fatalf("client %s was disconnected", cl.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// closed waits until the hub disconnected the client and returns the close
+// code it was given.
+func closed(t *testing.T, cl *Client) int {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-cl.Message:
+			if !ok {
+				return cl.closeCode
+			}
+		case <-deadline:
+			t.Fatalf("client %s is still connected", cl.ID)
+		}
+	}
+}
+
+func TestStoredBeforeBroadcast(t *testing.T) {
+	h, messages := newTestHub(t, DefaultConfig(), &stubRooms{})
+	alice := joinLobby(h, 1)
+	bob := joinLobby(h, 2)
+
+	post(h, alice, "m1", "hello")
+	got := recvType(t, bob, EventMessage)
+	if got.Seq != 1 || got.ID != "1" || got.Content != "hello" {
+		t.Errorf("bob got %+v, want the stored message 1 with seq 1", got)
+	}
+	recvType(t, alice, EventMessage)
+	recvType(t, alice, EventAck)
+
+	// Nobody sees a message that could not be stored
+	messages.setFail(true)
+	post(h, alice, "m2", "lost")
+	recvType(t, alice, EventNack)
+	none(t, bob)
+}
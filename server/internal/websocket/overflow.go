@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// OverflowResult tells the hub what an OverflowPolicy did with a message.
+type OverflowResult int
+
+const (
+	// Queued means the message will still reach the client.
+	Queued OverflowResult = iota
+	// Dropped means a message was lost, either the new one or an older one
+	// evicted to make room for it.
+	Dropped
+	// Disconnect means the client must be removed from its room.
+	Disconnect
+)
+
+// OverflowPolicy decides what happens when a client's Message buffer is full.
+// It is only ever called from the hub goroutine and must not block.
+type OverflowPolicy interface {
+	Overflow(cl *Client, m *Message) OverflowResult
+}
+
+// DropNewestPolicy discards the message that did not fit.
+type DropNewestPolicy struct{}
+
+func (DropNewestPolicy) Overflow(cl *Client, m *Message) OverflowResult {
+	return Dropped
+}
+
+// DropOldestPolicy evicts the oldest buffered message to make room.
+type DropOldestPolicy struct{}
+
+func (DropOldestPolicy) Overflow(cl *Client, m *Message) OverflowResult {
+	select {
+	case <-cl.Message:
+	default:
+	}
+	select {
+	case cl.Message <- m:
+	default:
+	}
+	return Dropped
+}
+
+// DisconnectPolicy closes the connection of a client that can't keep up.
+type DisconnectPolicy struct {
+	Code int
+}
+
+func (p DisconnectPolicy) Overflow(cl *Client, m *Message) OverflowResult {
+	cl.closeCode = p.Code
+	return Disconnect
+}
+
+// SpillPolicy parks messages in a per-client queue of up to Limit messages
+// and disconnects the client once that queue is full as well.
+type SpillPolicy struct {
+	Limit int
+}
+
+func (p SpillPolicy) Overflow(cl *Client, m *Message) OverflowResult {
+	if !cl.spill.push(m, p.Limit) {
+		cl.closeCode = websocket.CloseTryAgainLater
+		return Disconnect
+	}
+	return Queued
+}
+
+// NewOverflowPolicy builds a policy from its configuration name.
+func NewOverflowPolicy(name string, spillLimit int) (OverflowPolicy, error) {
+	switch name {
+	case "drop-newest":
+		return DropNewestPolicy{}, nil
+	case "drop-oldest":
+		return DropOldestPolicy{}, nil
+	case "disconnect":
+		return DisconnectPolicy{Code: websocket.CloseTryAgainLater}, nil
+	case "spill":
+		if spillLimit <= 0 {
+			return nil, fmt.Errorf("spill limit must be positive")
+		}
+		return SpillPolicy{Limit: spillLimit}, nil
+	default:
+		return nil, fmt.Errorf("unknown overflow policy %q", name)
+	}
+}
+
+// spillQueue holds the messages that did not fit in a client's buffer. They
+// are written once the buffer has been drained so ordering is preserved.
+type spillQueue struct {
+	mu    sync.Mutex
+	items []*Message
+	ready chan struct{}
+}
+
+func newSpillQueue() *spillQueue {
+	return &spillQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *spillQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// push queues m unless limit messages are queued already, a limit of 0
+// means no limit.
+func (q *spillQueue) push(m *Message, limit int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if limit > 0 && len(q.items) >= limit {
+		return false
+	}
+	q.items = append(q.items, m)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func (q *spillQueue) take() []*Message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := q.items
+	q.items = nil
+	return items
+}
@@ -0,0 +1,197 @@
+package websocket
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// deliverNumbered delivers events with the contents from to to in order to
+// everybody in the lobby, the way the hub delivers stored events.
+func deliverNumbered(h *Hub, from, to int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := from; i <= to; i++ {
+		h.deliverAll(h.rooms["lobby"], &Message{Type: EventMessage, Content: strconv.Itoa(i), RoomID: "lobby"})
+	}
+}
+
+// buffered empties the client's buffer and returns the contents of what
+// was in it.
+func buffered(cl *Client) []int {
+	var got []int
+	for {
+		select {
+		case m := <-cl.Message:
+			n, _ := strconv.Atoi(m.Content)
+			got = append(got, n)
+		default:
+			return got
+		}
+	}
+}
+
+func spilled(cl *Client) []int {
+	var got []int
+	for _, m := range cl.spill.take() {
+		n, _ := strconv.Atoi(m.Content)
+		got = append(got, n)
+	}
+	return got
+}
+
+func numbers(from, to int) []int {
+	var n []int
+	for i := from; i <= to; i++ {
+		n = append(n, i)
+	}
+	return n
+}
+
+func withPolicy(policy OverflowPolicy) Config {
+	config := DefaultConfig()
+	config.OverflowPolicy = policy
+	return config
+}
+
+func connected(h *Hub, cl *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rooms["lobby"].Clients[cl.ID] == cl
+}
+
+func TestDropNewestPolicy(t *testing.T) {
+	h, _ := newTestHub(t, withPolicy(DropNewestPolicy{}), &stubRooms{})
+	bob := joinLobby(h, 2)
+
+	deliverNumbered(h, 1, 30)
+	if got := buffered(bob); !slices.Equal(got, numbers(1, 25)) {
+		t.Errorf("bob's buffer holds %v, want 1 to 25", got)
+	}
+	if bob.Dropped() != 5 || h.Stats().DroppedMessages != 5 {
+		t.Errorf("dropped %d, hub counted %d, want 5", bob.Dropped(), h.Stats().DroppedMessages)
+	}
+	if !connected(h, bob) {
+		t.Error("bob was disconnected")
+	}
+}
+
+func TestDropOldestPolicy(t *testing.T) {
+	h, _ := newTestHub(t, withPolicy(DropOldestPolicy{}), &stubRooms{})
+	bob := joinLobby(h, 2)
+
+	deliverNumbered(h, 1, 30)
+	if got := buffered(bob); !slices.Equal(got, numbers(6, 30)) {
+		t.Errorf("bob's buffer holds %v, want 6 to 30", got)
+	}
+	if bob.Dropped() != 5 || h.Stats().DroppedMessages != 5 {
+		t.Errorf("dropped %d, hub counted %d, want 5", bob.Dropped(), h.Stats().DroppedMessages)
+	}
+	if !connected(h, bob) {
+		t.Error("bob was disconnected")
+	}
+}
+
+func TestDisconnectPolicy(t *testing.T) {
+	h, _ := newTestHub(t, DefaultConfig(), &stubRooms{})
+	alice := joinLobby(h, 1)
+	bob := joinLobby(h, 2)
+
+	deliverNumbered(h, 1, 25)
+	// Alice keeps up, bob doesn't
+	if got := buffered(alice); !slices.Equal(got, numbers(1, 25)) {
+		t.Fatalf("alice's buffer holds %v, want 1 to 25", got)
+	}
+	deliverNumbered(h, 26, 26)
+
+	if code := closed(t, bob); code != websocket.CloseTryAgainLater {
+		t.Errorf("bob was closed with %d, want %d", code, websocket.CloseTryAgainLater)
+	}
+	if h.Stats().DisconnectedClients != 1 {
+		t.Errorf("hub counted %d disconnected clients, want 1", h.Stats().DisconnectedClients)
+	}
+	if got := recv(t, alice); got.Content != "26" {
+		t.Errorf("alice got %+v, want message 26", got)
+	}
+	if !connected(h, alice) {
+		t.Error("alice was disconnected")
+	}
+}
+
+func TestSpillPolicy(t *testing.T) {
+	h, _ := newTestHub(t, withPolicy(SpillPolicy{Limit: 3}), &stubRooms{})
+	bob := joinLobby(h, 2)
+
+	deliverNumbered(h, 1, 27)
+	// Once something is spilled, later messages queue up behind it even
+	// when the buffer has room
+	<-bob.Message
+	deliverNumbered(h, 28, 28)
+	if got := buffered(bob); !slices.Equal(got, numbers(2, 25)) {
+		t.Errorf("bob's buffer holds %v, want 2 to 25", got)
+	}
+	if got := spilled(bob); !slices.Equal(got, numbers(26, 28)) {
+		t.Errorf("bob's spill holds %v, want 26 to 28", got)
+	}
+	if bob.Dropped() != 0 {
+		t.Errorf("dropped %d messages, want none", bob.Dropped())
+	}
+
+	// Past the limit the client is disconnected
+	deliverNumbered(h, 29, 29+25+3)
+	if code := closed(t, bob); code != websocket.CloseTryAgainLater {
+		t.Errorf("bob was closed with %d, want %d", code, websocket.CloseTryAgainLater)
+	}
+}
+
+func TestNewOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		want    OverflowPolicy
+		wantErr bool
+	}{
+		{name: "drop-newest", want: DropNewestPolicy{}},
+		{name: "drop-oldest", want: DropOldestPolicy{}},
+		{name: "disconnect", want: DisconnectPolicy{Code: websocket.CloseTryAgainLater}},
+		{name: "spill", limit: 100, want: SpillPolicy{Limit: 100}},
+		{name: "spill", limit: 0, wantErr: true},
+		{name: "buffer", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NewOverflowPolicy(tt.name, tt.limit)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewOverflowPolicy(%q, %d) error = %v, want error %v", tt.name, tt.limit, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NewOverflowPolicy(%q, %d) = %#v, want %#v", tt.name, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSpillQueuePush(t *testing.T) {
+	q := newSpillQueue()
+	for i := 0; i < 2; i++ {
+		if !q.push(&Message{}, 2) {
+			t.Fatalf("push %d under the limit failed", i+1)
+		}
+	}
+	if q.push(&Message{}, 2) {
+		t.Error("push over the limit succeeded")
+	}
+	if !q.push(&Message{}, 0) {
+		t.Error("push without a limit failed")
+	}
+	select {
+	case <-q.ready:
+	default:
+		t.Error("push did not signal ready")
+	}
+	if n := len(q.take()); n != 3 || q.len() != 0 {
+		t.Errorf("take returned %d messages and left %d, want 3 and 0", n, q.len())
+	}
+}
@@ -0,0 +1,97 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"server/internal/message"
+	"server/internal/room"
+	"strconv"
+	"time"
+)
+
+// persister writes events to the database in the order they were posted,
+// off the hub goroutine so a slow database can't stall rooms, and hands them
+// back to the hub to broadcast once stored. The hub never waits for room in
+// its queue, see Hub.persist.
+type persister struct {
+	repository message.Repository
+	rooms      room.Repository
+	queue      chan *Message
+	// results receives every stored event, and the nack of every message
+	// sent with a client ID that could not be stored
+	results *spillQueue
+	timeout time.Duration
+	// done is closed once the queue is closed and everything in it saved
+	done chan struct{}
+	// direct holds the direct rooms known to be stored, only run touches it
+	direct map[string]bool
+}
+
+func newPersister(repository message.Repository, rooms room.Repository, results *spillQueue) *persister {
+	return &persister{
+		repository: repository,
+		rooms:      rooms,
+		results:    results,
+		direct:     make(map[string]bool),
+		queue:      make(chan *Message, 256),
+		timeout:    time.Duration(2) * time.Second,
+		done:       make(chan struct{}),
+	}
+}
+
+func (p *persister) run() {
+	defer close(p.done)
+	for m := range p.queue {
+		p.save(m)
+	}
+}
+
+func (p *persister) save(m *Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	// A direct conversation is stored with its first message
+	if a, b, ok := room.DirectParticipants(m.RoomID); ok && !p.direct[m.RoomID] {
+		if err := p.rooms.CreateDirectRoom(ctx, a, b, m.CreatedAt); err != nil {
+			log.Printf("error storing direct room %s: %v", m.RoomID, err)
+			p.answer(m, nackFrame(m, ErrNotStored))
+			return
+		}
+		p.direct[m.RoomID] = true
+	}
+
+	stored, err := p.repository.CreateMessage(ctx, &message.Message{
+		RoomID:    m.RoomID,
+		Type:      m.Type,
+		UserID:    senderID(m),
+		Username:  m.Username,
+		Content:   m.Content,
+		CreatedAt: m.CreatedAt,
+	})
+	if err != nil {
+		log.Printf("error persisting message for room %s: %v", m.RoomID, err)
+		p.answer(m, nackFrame(m, ErrNotStored))
+		return
+	}
+	m.storedID = stored.ID
+	m.ID = strconv.FormatInt(stored.ID, 10)
+	m.Seq = stored.Seq
+	m.CreatedAt = stored.CreatedAt
+	p.results.push(m, 0)
+}
+
+// senderID is the user ID of the sender of m as stored, nil if it has none.
+func senderID(m *Message) *int64 {
+	id, err := strconv.ParseInt(m.UserID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// answer hands the nack of m to the hub if its sender asked for one.
+func (p *persister) answer(m *Message, nack *Message) {
+	if m.ref != "" {
+		p.results.push(nack, 0)
+	}
+}
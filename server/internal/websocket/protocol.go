@@ -0,0 +1,250 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"server/internal/apperr"
+	"server/internal/room"
+	"server/internal/validate"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolV1 is the first version of the envelope protocol.
+const ProtocolV1 = "chat.v1"
+
+// Protocols lists the versions the server speaks, newest first. Clients
+// offer theirs in Sec-WebSocket-Protocol and get the newest one both sides
+// know, clients offering none get ProtocolV1.
+var Protocols = []string{ProtocolV1}
+
+// negotiate picks the protocol for a connection, it reports false when the
+// client only offered versions the server doesn't know.
+func negotiate(r *http.Request) (string, bool) {
+	offered := websocket.Subprotocols(r)
+	if len(offered) == 0 {
+		return ProtocolV1, true
+	}
+	for _, p := range Protocols {
+		if slices.Contains(offered, p) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// Envelope wraps every frame in both directions. ID is chosen by the server
+// for its events, frames from clients may set one and get it back on the
+// error frames answering them.
+type Envelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	TS      time.Time       `json:"ts"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Event types carried by envelopes.
+const (
+	// EventMessage is a chat message, sent by clients and relayed to the
+	// room.
+	EventMessage = "message"
+	// EventTyping tells the room a user started or stopped typing.
+	EventTyping = "typing"
+	// EventModerate runs a moderation action, like the slash commands.
+	EventModerate = "moderate"
+	// EventJoin and EventLeave announce users coming and going.
+	EventJoin  = "join"
+	EventLeave = "leave"
+	// EventModeration announces a moderation action to the room.
+	EventModeration = "moderation"
+	// EventError answers a frame the server could not accept.
+	EventError = "error"
+	// EventAck tells the sender of a message with an ID that it was
+	// delivered and stored, EventNack that it was not.
+	EventAck  = "ack"
+	EventNack = "nack"
+)
+
+// MaxIDLength bounds the IDs clients put on their frames.
+const MaxIDLength = 64
+
+// eventType describes an event. Clients may only send the ones with a
+// payload constructor, persisted ones are saved to the room's history.
+type eventType struct {
+	payload   func() inboundPayload
+	persisted bool
+}
+
+type inboundPayload interface {
+	Validate() error
+}
+
+var eventTypes = map[string]eventType{
+	EventMessage:    {payload: func() inboundPayload { return &MessagePayload{} }, persisted: true},
+	EventTyping:     {payload: func() inboundPayload { return &TypingPayload{} }},
+	EventModerate:   {payload: func() inboundPayload { return &ModeratePayload{} }},
+	EventJoin:       {persisted: true},
+	EventLeave:      {persisted: true},
+	EventModeration: {persisted: true},
+	EventError:      {},
+	EventAck:        {},
+	EventNack:       {},
+}
+
+var (
+	ErrInvalidFrame   = apperr.New(apperr.Validation, "invalid_frame", "frames must be JSON envelopes")
+	ErrInvalidPayload = apperr.New(apperr.Validation, "invalid_payload", "payload does not match the event type")
+	ErrMuted          = apperr.New(apperr.Forbidden, "muted", "you are muted")
+	ErrSlowMode       = apperr.New(apperr.RateLimited, "slow_mode", "slow mode is on")
+	ErrNotStored      = apperr.New(apperr.Unavailable, "not_stored", "message could not be stored")
+)
+
+// MessagePayload is the payload of a message frame sent by a client.
+// Content starting with a slash is run as a command. Messages sent with an
+// envelope ID are acked once stored and delivered at most once per ID.
+type MessagePayload struct {
+	Content string `json:"content"`
+}
+
+// TypingPayload is the payload of typing frames in both directions.
+type TypingPayload struct {
+	Active bool `json:"active"`
+}
+
+// TypingEvent is sent to the room when a user starts or stops typing.
+type TypingEvent struct {
+	RoomID   string `json:"room_id"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Active   bool   `json:"active"`
+}
+
+// ModeratePayload is the payload of a moderate frame, its fields mean the
+// same as in room.ModerateRequest.
+type ModeratePayload struct {
+	Action   string `json:"action"`
+	UserID   int64  `json:"user_id"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+func (p *MessagePayload) Validate() error {
+	var v validate.Validator
+	v.Field("content", p.Content, validate.Required())
+	return v.Err()
+}
+
+func (p *TypingPayload) Validate() error {
+	return nil
+}
+
+func (p *ModeratePayload) Validate() error {
+	var v validate.Validator
+	v.Field("action", p.Action, validate.Required(), validate.OneOf(
+		room.ActionKick, room.ActionBan, room.ActionUnban,
+		room.ActionMute, room.ActionUnmute, room.ActionSlowMode,
+	))
+	if err := v.Err(); err != nil {
+		return err
+	}
+	return p.request().Validate()
+}
+
+func (p *ModeratePayload) request() *room.ModerateRequest {
+	return &room.ModerateRequest{
+		Action:   p.Action,
+		UserID:   p.UserID,
+		Duration: p.Duration,
+		Reason:   p.Reason,
+	}
+}
+
+// AckPayload is the payload of an ack, MessageID is the ID the message has
+// in the room's history and Seq its sequence number in the room.
+type AckPayload struct {
+	MessageID int64     `json:"message_id"`
+	Seq       int64     `json:"seq"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrorPayload explains why a frame was refused. Code is stable, Fields
+// lists the invalid fields of the payload.
+type ErrorPayload struct {
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	Fields     validate.Errors `json:"fields,omitempty"`
+	RetryAfter int             `json:"retry_after,omitempty"`
+}
+
+// decodeFrame parses a frame from a client and validates it against its
+// event type. The envelope is returned even when the payload is invalid so
+// the error frame can refer to it.
+func decodeFrame(data []byte) (*Envelope, inboundPayload, error) {
+	var frame Envelope
+	if err := strictUnmarshal(data, &frame); err != nil {
+		return &frame, nil, ErrInvalidFrame.Wrap(err)
+	}
+
+	var v validate.Validator
+	event, known := eventTypes[frame.Type]
+	v.Field("type", frame.Type, validate.Required())
+	v.Check("type", frame.Type == "" || (known && event.payload != nil), "is not an event clients can send")
+	v.Optional("id", frame.ID, validate.MaxLength(MaxIDLength))
+	if err := v.Err(); err != nil {
+		return &frame, nil, err
+	}
+
+	payload := event.payload()
+	if len(frame.Payload) > 0 {
+		if err := strictUnmarshal(frame.Payload, payload); err != nil {
+			return &frame, nil, ErrInvalidPayload.Wrap(err)
+		}
+	}
+	if err := payload.Validate(); err != nil {
+		return &frame, nil, err
+	}
+	return &frame, payload, nil
+}
+
+func strictUnmarshal(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// errorFrame builds the error frame answering the frame with ID ref.
+func errorFrame(ref string, err error) *Message {
+	payload := &ErrorPayload{Code: "internal", Message: "could not handle frame"}
+
+	var fields validate.Errors
+	var appErr *apperr.Error
+	switch {
+	case errors.As(err, &fields):
+		payload = &ErrorPayload{Code: "validation_failed", Message: "validation failed", Fields: fields}
+	case errors.As(err, &appErr) && appErr.Kind != apperr.Internal:
+		payload = &ErrorPayload{Code: appErr.Code, Message: appErr.Message}
+		if appErr.RetryAfter > 0 {
+			payload.RetryAfter = int(math.Ceil(appErr.RetryAfter.Seconds()))
+		}
+	default:
+		log.Printf("error handling frame %q: %v", ref, err)
+	}
+	return &Message{
+		Type:      EventError,
+		ID:        ref,
+		CreatedAt: time.Now(),
+		Payload:   payload,
+	}
+}
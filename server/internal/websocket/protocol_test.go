@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"server/internal/apperr"
+	"server/internal/validate"
+)
+
+func TestDecodeFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		want    inboundPayload
+		wantErr error
+		// field is the invalid field for validation errors
+		field string
+	}{
+		{
+			name:  "message",
+			frame: `{"type":"message","id":"m1","payload":{"content":"hi"}}`,
+			want:  &MessagePayload{Content: "hi"},
+		},
+		{
+			name:  "typing",
+			frame: `{"type":"typing","payload":{"active":true}}`,
+			want:  &TypingPayload{Active: true},
+		},
+		{
+			name:  "typing without payload",
+			frame: `{"type":"typing"}`,
+			want:  &TypingPayload{},
+		},
+		{
+			name:  "moderate",
+			frame: `{"type":"moderate","payload":{"action":"kick","user_id":2}}`,
+			want:  &ModeratePayload{Action: "kick", UserID: 2},
+		},
+		{name: "not JSON", frame: `hello`, wantErr: ErrInvalidFrame},
+		{name: "trailing data", frame: `{"type":"typing"} {}`, wantErr: ErrInvalidFrame},
+		{name: "unknown envelope field", frame: `{"type":"typing","room":"lobby"}`, wantErr: ErrInvalidFrame},
+		{name: "no type", frame: `{"payload":{}}`, field: "type"},
+		{name: "unknown type", frame: `{"type":"shout"}`, field: "type"},
+		{name: "server event", frame: `{"type":"join"}`, field: "type"},
+		{name: "long ID", frame: `{"type":"typing","id":"` + strings.Repeat("x", MaxIDLength+1) + `"}`, field: "id"},
+		{name: "unknown payload field", frame: `{"type":"message","payload":{"content":"hi","html":true}}`, wantErr: ErrInvalidPayload},
+		{name: "payload of the wrong type", frame: `{"type":"typing","payload":{"active":"yes"}}`, wantErr: ErrInvalidPayload},
+		{name: "empty message", frame: `{"type":"message","payload":{"content":""}}`, field: "content"},
+		{name: "unknown action", frame: `{"type":"moderate","payload":{"action":"smite","user_id":2}}`, field: "action"},
+		{name: "mute without duration", frame: `{"type":"moderate","payload":{"action":"mute","user_id":2}}`, field: "duration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, payload, err := decodeFrame([]byte(tt.frame))
+			if frame == nil {
+				t.Fatal("decodeFrame returned no envelope")
+			}
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.field != "":
+				var fields validate.Errors
+				if !errors.As(err, &fields) || !hasField(fields, tt.field) {
+					t.Errorf("error = %v, want %s to be invalid", err, tt.field)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("decodeFrame: %v", err)
+				}
+				if !equalPayload(payload, tt.want) {
+					t.Errorf("payload = %+v, want %+v", payload, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func hasField(errs validate.Errors, field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func equalPayload(got, want inboundPayload) bool {
+	switch want := want.(type) {
+	case *MessagePayload:
+		got, ok := got.(*MessagePayload)
+		return ok && *got == *want
+	case *TypingPayload:
+		got, ok := got.(*TypingPayload)
+		return ok && *got == *want
+	case *ModeratePayload:
+		got, ok := got.(*ModeratePayload)
+		return ok && *got == *want
+	}
+	return false
+}
+
+// Error frames refer to the frame they answer whenever it has an ID.
+func TestDecodeFrameKeepsID(t *testing.T) {
+	frame, _, err := decodeFrame([]byte(`{"type":"message","id":"m1","payload":{"content":""}}`))
+	if err == nil || frame.ID != "m1" {
+		t.Errorf("decodeFrame = %+v, %v, want an error for frame m1", frame, err)
+	}
+}
+
+func TestStrictUnmarshal(t *testing.T) {
+	var p MessagePayload
+	if err := strictUnmarshal([]byte(`{"content":"hi"}`), &p); err != nil || p.Content != "hi" {
+		t.Errorf("strictUnmarshal = %+v, %v", p, err)
+	}
+	for _, data := range []string{`{"content":"hi","extra":1}`, `{"content":"hi"}{}`, `{"content":`, ``} {
+		if err := strictUnmarshal([]byte(data), &MessagePayload{}); err == nil {
+			t.Errorf("strictUnmarshal(%q) succeeded", data)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		offered string
+		want    string
+		ok      bool
+	}{
+		{offered: "", want: ProtocolV1, ok: true},
+		{offered: "chat.v1", want: ProtocolV1, ok: true},
+		{offered: "chat.v9, chat.v1", want: ProtocolV1, ok: true},
+		{offered: "chat.v9", ok: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws/lobby", nil)
+		if tt.offered != "" {
+			r.Header.Set("Sec-WebSocket-Protocol", tt.offered)
+		}
+		got, ok := negotiate(r)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("negotiate(%q) = %q, %v, want %q, %v", tt.offered, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorPayload
+	}{
+		{
+			name: "validation",
+			err:  validate.Errors{{Field: "content", Message: "is required"}},
+			want: ErrorPayload{Code: "validation_failed", Message: "validation failed", Fields: validate.Errors{{Field: "content", Message: "is required"}}},
+		},
+		{
+			name: "application error",
+			err:  ErrInvalidFrame.Wrap(errors.New("bad JSON")),
+			want: ErrorPayload{Code: "invalid_frame", Message: ErrInvalidFrame.Message},
+		},
+		{
+			name: "retry after",
+			err:  ErrSlowMode.WithRetryAfter(2500 * time.Millisecond),
+			want: ErrorPayload{Code: "slow_mode", Message: ErrSlowMode.Message, RetryAfter: 3},
+		},
+		{
+			name: "internal error is hidden",
+			err:  errors.New("connection refused"),
+			want: ErrorPayload{Code: "internal", Message: "could not handle frame"},
+		},
+		{
+			name: "internal application error is hidden",
+			err:  apperr.New(apperr.Internal, "db", "database exploded"),
+			want: ErrorPayload{Code: "internal", Message: "could not handle frame"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := errorFrame("f1", tt.err)
+			got, ok := m.Payload.(*ErrorPayload)
+			if m.Type != EventError || m.ID != "f1" || !ok {
+				t.Fatalf("errorFrame = %+v, want an error frame for f1", m)
+			}
+			if got.Code != tt.want.Code || got.Message != tt.want.Message || got.RetryAfter != tt.want.RetryAfter ||
+				len(got.Fields) != len(tt.want.Fields) {
+				t.Errorf("payload = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
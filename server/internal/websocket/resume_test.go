@@ -0,0 +1,234 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"server/internal/auth"
+	"server/internal/message"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves the hub's rooms, the user ID is taken from the user
+// query parameter instead of a token.
+func newTestServer(t *testing.T, h *Hub) *httptest.Server {
+	t.Helper()
+	handler := NewHandler(h, nil, []string{"https://chat.example.com"})
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := r.URL.Query().Get("user")
+			id, _ := strconv.ParseInt(user, 10, 64)
+			identity := auth.Identity{UserID: id, Username: "user" + user, Verified: true}
+			next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+		})
+	})
+	r.Get("/ws/{roomId}", handler.JoinRoom)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial joins the lobby as the user, resuming after since unless it is
+// negative.
+func dial(t *testing.T, srv *httptest.Server, userID int64, since int64) *websocket.Conn {
+	t.Helper()
+	url := fmt.Sprintf("ws%s/ws/lobby?user=%d", strings.TrimPrefix(srv.URL, "http"), userID)
+	if since >= 0 {
+		url += fmt.Sprintf("&since=%d", since)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("could not join as user %d: %v", userID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// event is a frame as clients see it.
+type event struct {
+	Envelope
+	Seq    int64
+	UserID string
+}
+
+func readEvent(conn *websocket.Conn) (*event, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var e event
+	if err := conn.ReadJSON(&e.Envelope); err != nil {
+		return nil, err
+	}
+	var payload struct {
+		Seq    int64  `json:"seq"`
+		UserID string `json:"user_id"`
+	}
+	json.Unmarshal(e.Payload, &payload)
+	e.Seq = payload.Seq
+	e.UserID = payload.UserID
+	return &e, nil
+}
+
+// sender posts messages over a connection and waits for their acks.
+type sender struct {
+	conn *websocket.Conn
+	acks chan string
+}
+
+func newSender(t *testing.T, conn *websocket.Conn) *sender {
+	s := &sender{conn: conn, acks: make(chan string, 16)}
+	go func() {
+		defer close(s.acks)
+		for {
+			e, err := readEvent(conn)
+			if err != nil {
+				return
+			}
+			if e.Type == EventAck {
+				s.acks <- e.ID
+			}
+		}
+	}()
+	return s
+}
+
+func (s *sender) post(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		ref := fmt.Sprintf("m%d-%d", time.Now().UnixNano(), i)
+		frame := map[string]any{"type": EventMessage, "id": ref, "payload": map[string]string{"content": "hi"}}
+		if err := s.conn.WriteJSON(frame); err != nil {
+			t.Fatalf("could not post: %v", err)
+		}
+		select {
+		case ack, ok := <-s.acks:
+			if !ok || ack != ref {
+				t.Fatalf("got ack %q, want %q", ack, ref)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %q was not acked", ref)
+		}
+	}
+}
+
+// seed stores n messages in the lobby.
+func seed(t *testing.T, messages *memMessages, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		_, err := messages.CreateMessage(context.Background(), &message.Message{RoomID: "lobby", Type: EventMessage, Content: "old", CreatedAt: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readSeqs reads stored events until one numbered last, or until the
+// connection is closed, and returns their sequence numbers.
+func readSeqs(t *testing.T, conn *websocket.Conn, last int64) ([]int64, error) {
+	t.Helper()
+	var seqs []int64
+	for {
+		e, err := readEvent(conn)
+		if err != nil {
+			return seqs, err
+		}
+		if e.Seq == 0 {
+			continue
+		}
+		seqs = append(seqs, e.Seq)
+		if e.Seq == last {
+			return seqs, nil
+		}
+	}
+}
+
+// checkSeqs fails unless seqs counts from first up without gaps or
+// repeats.
+func checkSeqs(t *testing.T, seqs []int64, first int64) {
+	t.Helper()
+	for i, seq := range seqs {
+		if want := first + int64(i); seq != want {
+			t.Fatalf("event %d has seq %d, want %d (got %v)", i, seq, want, seqs)
+		}
+	}
+}
+
+func TestResumeWhileMessagesArrive(t *testing.T) {
+	config := DefaultConfig()
+	config.OverflowPolicy = SpillPolicy{Limit: 500}
+	h, messages := newTestHub(t, config, &stubRooms{})
+	seed(t, messages, 2*message.MaxPageSize+50)
+	srv := newTestServer(t, h)
+
+	alice := newSender(t, dial(t, srv, 1, -1))
+	pause := make(chan struct{})
+	messages.mu.Lock()
+	messages.pause = pause
+	messages.mu.Unlock()
+
+	bob := dial(t, srv, 2, 10)
+	// Half the messages arrive before the replay reads anything, the rest
+	// while it is going on
+	<-pause
+	alice.post(t, 50)
+	pause <- struct{}{}
+	alice.post(t, 50)
+
+	last, _ := messages.LastSeq(context.Background(), "lobby")
+	seqs, err := readSeqs(t, bob, last)
+	if err != nil {
+		t.Fatalf("reading after the replay: %v", err)
+	}
+	checkSeqs(t, seqs, 11)
+	if n := int64(len(seqs)); n != last-10 {
+		t.Errorf("bob got %d events, want %d", n, last-10)
+	}
+}
+
+func TestResumeOverflowDisconnects(t *testing.T) {
+	h, messages := newTestHub(t, DefaultConfig(), &stubRooms{})
+	seed(t, messages, 20)
+	srv := newTestServer(t, h)
+
+	alice := newSender(t, dial(t, srv, 1, -1))
+	pause := make(chan struct{})
+	messages.mu.Lock()
+	messages.pause = pause
+	messages.mu.Unlock()
+
+	bob := dial(t, srv, 2, 0)
+	<-pause
+	// More than a client's buffer arrives before the replay gets anywhere
+	alice.post(t, 30)
+	pause <- struct{}{}
+
+	seqs, err := readSeqs(t, bob, -1)
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+		t.Fatalf("bob's connection ended with %v, want close code %d", err, websocket.CloseTryAgainLater)
+	}
+	checkSeqs(t, seqs, 1)
+	if len(seqs) == 0 {
+		t.Fatal("bob got nothing before being disconnected")
+	}
+
+	// Resuming from where the first connection got to misses nothing
+	since := seqs[len(seqs)-1]
+	bob = dial(t, srv, 2, since)
+	alice.post(t, 1)
+	last, _ := messages.LastSeq(context.Background(), "lobby")
+	rest, err := readSeqs(t, bob, last)
+	if err != nil {
+		t.Fatalf("reading after resuming again: %v", err)
+	}
+	checkSeqs(t, rest, since+1)
+}
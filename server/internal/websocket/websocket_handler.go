@@ -2,66 +2,80 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"server/internal/token"
-	"server/internal/user"
+	"net/url"
+	"server/internal/auth"
+	"server/internal/room"
 	"server/internal/utils"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/websocket"
 )
 
 type Handler struct {
-	hub      *Hub
-	jwtMaker *token.JWTMaker
-	user.Repository
+	hub       *Hub
+	moderator Moderator
+	upgrader  websocket.Upgrader
+	// origins holds the allowed origins as scheme://host, lowercased
+	origins map[string]bool
 }
 
-func NewHandler(hub *Hub, jwtMaker *token.JWTMaker, service *user.Service) *Handler {
-	return &Handler{
-		hub:      hub,
-		jwtMaker: jwtMaker,
-		service:  service,
+// NewHandler serves websockets to clients that aren't browsers, to pages of
+// the server itself and to pages of allowedOrigins such as
+// "https://chat.example.com". Other pages are refused, browsers would send
+// them the cookies of whoever is signed in.
+func NewHandler(hub *Hub, moderator Moderator, allowedOrigins []string) *Handler {
+	h := &Handler{
+		hub:       hub,
+		moderator: moderator,
+		origins:   make(map[string]bool),
 	}
-}
-
-type CreateRoomReq struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-}
-
-func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
-	var req CreateRoomReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.WriteError(w, r, http.StatusBadRequest, "invalid payload", err)
-		return
+	for _, origin := range allowedOrigins {
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			h.origins[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+		}
 	}
-	h.hub.Rooms[req.ID] = &Room{
-		ID:      req.ID,
-		Name:    req.Name,
-		Clients: make(map[string]*Client),
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		Subprotocols:    Protocols,
+		CheckOrigin:     h.checkOrigin,
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(req)
-	log.Println("created a room")
+	return h
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
+// checkOrigin reports whether the Origin header, which only browsers send,
+// is the server itself or an allowed origin.
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
 		return true
-	},
-	// CheckOrigin: func(r *http.Request) bool {
-	// 	origin := r.Header.Get("Origin")
-	// 	return origin == "http:localhost:3000"
-	// },
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host) || h.origins[strings.ToLower(u.Scheme+"://"+u.Host)]
 }
 
+// Close codes sent by the server. Application close codes start at 4000.
+const (
+	// CloseKicked disconnects a user kicked by a moderator, they may join
+	// again.
+	CloseKicked = 4001
+	// CloseBanned disconnects a banned user and turns away their joins.
+	CloseBanned = 4002
+	// CloseNotMember is sent to users who may not join a room, it follows
+	// the matching HTTP status.
+	CloseNotMember = 4403
+)
+
 func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 
 	roomID := chi.URLParam(r, "roomId")
@@ -69,55 +83,147 @@ func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 		utils.WriteError(w, r, http.StatusBadRequest, "room ID required", nil)
 		return
 	}
-	userID, username, err := h.getUserFromToken(r)
-	if err != nil {
-		utils.WriteError(w, r, http.StatusUnauthorized, "authenication required", err)
+	identity, ok := auth.IdentityFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
 		return
 	}
+	h.join(w, r, identity, roomID)
+}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		utils.WriteError(w, r, http.StatusBadRequest, "error creating room", err)
+// JoinDirect joins the direct conversation with another user. Nothing is
+// stored until one of them sends a message.
+func (h *Handler) JoinDirect(w http.ResponseWriter, r *http.Request) {
+	identity, ok := auth.IdentityFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, "authentication required", nil)
 		return
 	}
-	client := &Client{
-		Conn: conn,
-		Message: make(chan *Message, 25),
-		ID: userID,
-		RoomID: roomID,
-		Username: username,
+	otherID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil || otherID <= 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, "invalid user ID", err)
+		return
 	}
-	m := &Message{
-		Content: fmt.Sprintf("%s has joined the room", username),
-		RoomID: roomID,
-		Username: username,
+	if otherID == identity.UserID {
+		utils.WriteError(w, r, http.StatusBadRequest, "can't message yourself", nil)
+		return
 	}
-
-	// Register a client 
-	h.hub.Register <- client
-
-	// Brodcast the message
-
-	// writeMessage()
-
-	// readMessage()
+	h.join(w, r, identity, room.DirectRoomID(identity.UserID, otherID))
 }
 
-func (h *Handler) getUserFromToken(r *http.Request) (userID, username string, err error) {
-	tokenCookie, err := r.Cookie("access_token")
+// join connects the user to a room. Clients resuming after a reconnect pass
+// the sequence number of the last event they got as since, the events they
+// missed are replayed before live delivery starts.
+func (h *Handler) join(w http.ResponseWriter, r *http.Request, identity auth.Identity, roomID string) {
+	if _, ok := negotiate(r); !ok {
+		utils.WriteError(w, r, http.StatusBadRequest, "unsupported protocol version, supported: "+strings.Join(Protocols, ", "), nil)
+		return
+	}
+	since := int64(-1)
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseInt(s, 10, 64); err != nil || since < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, "invalid since sequence number", err)
+			return
+		}
+	}
+	userID := strconv.FormatInt(identity.UserID, 10)
+	username := identity.Username
+	// Outsiders are turned away before anything is loaded, so they can't
+	// tell whether the conversation exists
+	if _, _, direct := room.DirectParticipants(roomID); direct && !room.IsParticipant(roomID, identity.UserID) {
+		h.reject(w, r, CloseNotMember, room.ErrNotMember.Message)
+		return
+	}
+	stored, err := h.hub.LoadRoom(r.Context(), roomID)
+	if errors.Is(err, ErrShuttingDown) {
+		utils.WriteError(w, r, http.StatusServiceUnavailable, "server is shutting down", err)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not load room", err)
+		return
+	}
+	banned, err := h.hub.roomRepo.IsBanned(r.Context(), roomID, identity.UserID)
 	if err != nil {
-		return "", "", err
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not load room", err)
+		return
+	}
+	if banned {
+		h.reject(w, r, CloseBanned, room.ErrBanned.Message)
+		return
 	}
-	tokenString := tokenCookie.Value
-	claims, err := h.jwtMaker.VerifyToken(tokenString)
+	allowed, err := room.CanRead(r.Context(), h.hub.roomRepo, stored, identity.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusInternalServerError, "could not load room", err)
+		return
+	}
+	if !allowed {
+		h.reject(w, r, CloseNotMember, room.ErrNotMember.Message)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		return "", "", fmt.Errorf("invalid token: %w", err)
+		// The upgrader has already replied to the client
+		log.Printf("error upgrading connection: %v", err)
+		return
+	}
+	if !h.hub.startPump() {
+		closeConn(conn, websocket.CloseGoingAway, "", h.hub.config.WriteWait)
+		return
+	}
+	client := newClient(conn, userID, roomID, username)
+	// Live events wait until the missed ones are written, however many
+	client.replaying = since >= 0
+
+	until := h.hub.Register(client)
+	if stored.Kind != room.KindDirect {
+		h.hub.Broadcast <- &Message{
+			Type:      EventJoin,
+			Content:   fmt.Sprintf("%s has joined the room", username),
+			RoomID:    roomID,
+			UserID:    userID,
+			Username:  username,
+			System:    true,
+			CreatedAt: time.Now(),
+		}
 	}
-	ctx := r.Context()
-	user, err := h.Repository.GetUserByEmail(ctx, claims.Email)
+
+	go func() {
+		defer h.hub.pumps.Done()
+		if since >= 0 {
+			if err := client.replay(h.hub, since, until); err != nil {
+				log.Printf("error replaying room %s to client %s: %v", roomID, userID, err)
+				closeConn(conn, websocket.CloseTryAgainLater, "could not replay missed messages", h.hub.config.WriteWait)
+				return
+			}
+		}
+		client.writeMessage(h.hub.config)
+	}()
+	client.readMessage(h.hub, h.moderator)
+}
+
+// Stats reports the messages the hub could not deliver or store, for
+// operators.
+func (h *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.hub.Stats())
+}
+
+// reject accepts the connection only to close it with code. Browsers hide
+// the status of a failed handshake from scripts, close codes they show.
+func (h *Handler) reject(w http.ResponseWriter, r *http.Request, code int, reason string) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to find username: %w", err)
+		log.Printf("error upgrading connection: %v", err)
+		return
 	}
+	closeConn(conn, code, reason, h.hub.config.WriteWait)
+}
 
-	return strconv.Itoa(int(user.ID)), user.Username, nil
+func closeConn(conn *websocket.Conn, code int, reason string, writeWait time.Duration) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	conn.Close()
 }
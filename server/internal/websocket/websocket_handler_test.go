@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeCode joins the room as the user and returns the code the server
+// closes the connection with.
+func closeCode(t *testing.T, url string) int {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("could not connect to %s: %v", url, err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr.Code
+		}
+		if err != nil {
+			t.Fatalf("reading from %s: %v", url, err)
+		}
+	}
+}
+
+func TestJoinDirectRoomAsOutsider(t *testing.T) {
+	h, _ := newTestHub(t, DefaultConfig(), &stubRooms{})
+	srv := newTestServer(t, h)
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if code := closeCode(t, fmt.Sprintf("%s/ws/dm:1:2?user=3", base)); code != CloseNotMember {
+		t.Errorf("outsider joining dm:1:2 was closed with %d, want %d", code, CloseNotMember)
+	}
+	h.mu.Lock()
+	_, loaded := h.rooms["dm:1:2"]
+	h.mu.Unlock()
+	if loaded {
+		t.Error("the conversation was loaded for an outsider")
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	h, _ := newTestHub(t, DefaultConfig(), &stubRooms{})
+	srv := newTestServer(t, h)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/lobby?user=1"
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{srv.URL, true},
+		{"https://chat.example.com", true},
+		{"HTTPS://Chat.Example.com", true},
+		{"http://chat.example.com", false},
+		{"https://chat.example.com:8443", false},
+		{"https://evil.example.com", false},
+		{"https://chat.example.com.evil.example.com", false},
+		{"http:localhost:3000", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, res, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("joining from %q: got error %v, want allowed %v", tt.origin, err, tt.ok)
+		}
+		if !tt.ok && res != nil && res.StatusCode != http.StatusForbidden {
+			t.Errorf("joining from %q answered %d, want 403", tt.origin, res.StatusCode)
+		}
+	}
+}
+
+func TestNewHandlerOrigins(t *testing.T) {
+	h := NewHandler(nil, nil, []string{"http://localhost:3000/app/", "not an origin"})
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"http://localhost:3000", true},
+		{"http://LOCALHOST:3000", true},
+		{"http://localhost:3001", false},
+		{"not an origin", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://api.example.com/ws/lobby", nil)
+		r.Header.Set("Origin", tt.origin)
+		if got := h.checkOrigin(r); got != tt.ok {
+			t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.ok)
+		}
+	}
+}